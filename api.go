@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// newHTTPClient создает HTTP-клиента с общими настройками TLS (см. setUpTLS)
+func newHTTPClient() *http.Client {
+	tr := &http.Transport{
+		TLSClientConfig: tlsClientConfig(),
+	}
+	return &http.Client{Transport: tr}
+}
+
+// doRequest выполняет запрос к API Gitlab с токеном доступа. Запрос вместе с чтением ответа
+// ограничен operationTimeouts.API
+func doRequest(ctx context.Context, method, reqURL, token string, body io.Reader, contentType string) (*http.Response, error) {
+	return doRequestTimeout(ctx, operationTimeouts.API, method, reqURL, token, body, contentType)
+}
+
+// doTransfer -- doRequest без ограничения времени для скачивания и загрузки файлов, прерывается только отменой ctx
+func doTransfer(ctx context.Context, method, reqURL, token string, body io.Reader, contentType string) (*http.Response, error) {
+	return doRequestTimeout(ctx, 0, method, reqURL, token, body, contentType)
+}
+
+// doRequestTimeout выполняет запрос с ограничением времени timeout (0 -- без ограничения)
+func doRequestTimeout(ctx context.Context, timeout time.Duration, method, reqURL, token string, body io.Reader, contentType string) (*http.Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("PRIVATE-TOKEN", token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to perform request: %w", err)
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// getJSON выполняет GET запрос и декодирует ответ в v. Код ответа должен быть 200
+func getJSON(ctx context.Context, reqURL, token string, v interface{}) error {
+	resp, err := doRequest(ctx, "GET", reqURL, token, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("GitLab API request %s failed with status code %d: %s", reqURL, resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+	return nil
+}
+
+// getAllPages проходит по всем страницам списка (per_page=100) и возвращает объединенный результат
+func getAllPages[T any](ctx context.Context, reqURL, token string) ([]T, error) {
+	separator := "?"
+	if u, err := url.Parse(reqURL); err == nil && u.RawQuery != "" {
+		separator = "&"
+	}
+	var all []T
+	for page := 1; ; page++ {
+		var perPage []T
+		if err := getJSON(ctx, fmt.Sprintf("%s%sper_page=100&page=%d", reqURL, separator, page), token, &perPage); err != nil {
+			return nil, err
+		}
+		if len(perPage) == 0 {
+			break
+		}
+		all = append(all, perPage...)
+		if len(perPage) < 100 {
+			break
+		}
+	}
+	return all, nil
+}
+
+// getProjectIDByPath получает ID проекта по его полному пути (group/subgroup/project)
+func getProjectIDByPath(ctx context.Context, gitlabURL, token, fullPath string) (int, error) {
+	var project Project
+	if err := getJSON(ctx, fmt.Sprintf("%s/api/v4/projects/%s", gitlabURL, url.PathEscape(fullPath)), token, &project); err != nil {
+		return 0, err
+	}
+	return project.ID, nil
+}
+
+// downloadToTemp скачивает файл во временную директорию и возвращает открытый файл
+// (позиционированный на начало) и его sha256. Файл удаляет вызывающая сторона
+func downloadToTemp(ctx context.Context, reqURL, token string) (*os.File, string, error) {
+	resp, err := doTransfer(ctx, "GET", reqURL, token, nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to download %s. Status: %d", reqURL, resp.StatusCode)
+	}
+	file, err := os.CreateTemp(tmpDir, "download-*")
+	if err != nil {
+		return nil, "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, "", err
+	}
+	return file, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// uploadFile загружает файл методом PUT (используется API пакетов)
+func uploadFile(ctx context.Context, reqURL, token string, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", reqURL, file)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("PRIVATE-TOKEN", token)
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to perform request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to upload file. Status: %d, Response: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
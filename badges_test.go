@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// -update перезаписывает golden файлы testdata/badge-routing/*.golden текущим результатом
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// addRepo создает проект с одним коммитом
+func addRepo(fake *fakeGitLab, group *fakeGroup, projectPath string) {
+	fake.addProject(group, projectPath, projectPath, []map[string]string{{"README.md": projectPath + "\n"}}, nil, nil)
+}
+
+// TestBadgeRouting проверяет, какие группы и проекты попадают на Gitlab-destination и с какими бейджами.
+// Ожидаемое дерево Gitlab-destination (группы с бейджами, проекты с ветками и тегами) лежит в golden файлах
+func TestBadgeRouting(t *testing.T) {
+	tests := []struct {
+		name string
+		// downstream -- синхронизация резервации с xxxxx Gitlab (Gitlab-destination -- destAddress),
+		// иначе -- синхронизация в резервацию
+		downstream bool
+		source     func(source *fakeGitLab)
+		dest       func(dest *fakeGitLab)
+	}{
+		{
+			// В резервацию переносятся только xxxxx и xxxxx-dep, все остальные корневые группы пропускаются
+			name: "reservation-root-filter",
+			source: func(source *fakeGitLab) {
+				addRepo(source, source.addGroup("xxxxx", "xxxxx", nil), "app")
+				addRepo(source, source.addGroup("xxxxx-dep", "xxxxx-dep", nil), "dep")
+				addRepo(source, source.addGroup("other", "other", nil), "tool")
+				addRepo(source, source.addGroup("mock-sync", "mock-sync", nil), "loop")
+			},
+		},
+		{
+			// Вложенные подгруппы воссоздаются внутри mock-sync с проектами на каждом уровне
+			name: "reservation-nested-subgroups",
+			source: func(source *fakeGitLab) {
+				root := source.addGroup("xxxxx", "xxxxx", nil)
+				a := source.addGroup("A", "a", root)
+				b := source.addGroup("B", "b", a)
+				c := source.addGroup("C", "c", b)
+				addRepo(source, root, "root-app")
+				addRepo(source, a, "a-app")
+				addRepo(source, c, "c-app")
+				source.addGroup("Empty", "empty", b)
+			},
+		},
+		{
+			// Бейдж каждой группы копируется на её копию, подгруппы без бейджа бейдж родителя не получают.
+			// Группа private в резервацию переносится
+			name: "reservation-badge-copy",
+			source: func(source *fakeGitLab) {
+				root := source.addGroup("xxxxx", "xxxxx", nil)
+				source.addBadge(root, "private")
+				a := source.addGroup("A", "a", root)
+				source.addBadge(a, "xxx")
+				b := source.addGroup("B", "b", a)
+				addRepo(source, b, "b-app")
+				kept := source.addGroup("Kept", "kept", root)
+				source.addBadge(kept, "xxx")
+			},
+			dest: func(dest *fakeGitLab) {
+				// Уже установленный бейдж не заменяется и не дублируется
+				area := dest.addGroup("mock-sync", "mock-sync", nil)
+				root := dest.addGroup("xxxxx", "xxxxx", area)
+				kept := dest.addGroup("kept", "kept", root)
+				dest.addBadge(kept, "manual")
+			},
+		},
+		{
+			// В конце синхронизации с корневой группы mock-sync снимается бейдж
+			name: "reservation-root-badge-removal",
+			source: func(source *fakeGitLab) {
+				addRepo(source, source.addGroup("xxxxx", "xxxxx", nil), "app")
+			},
+			dest: func(dest *fakeGitLab) {
+				dest.addBadge(dest.addGroup("mock-sync", "mock-sync", nil), "private")
+			},
+		},
+		{
+			// На xxxxx Gitlab переносится только mock-sync резервации, пути сохраняются как есть
+			name:       "downstream-root-filter",
+			downstream: true,
+			source: func(source *fakeGitLab) {
+				area := source.addGroup("mock-sync", "mock-sync", nil)
+				root := source.addGroup("xxxxx", "xxxxx", area)
+				addRepo(source, root, "app")
+				addRepo(source, source.addGroup("Sub", "sub", root), "lib")
+				addRepo(source, source.addGroup("xxxxx", "xxxxx", nil), "direct")
+			},
+		},
+		{
+			// Группы с бейджем private пропускаются вместе со всеми подгруппами и проектами,
+			// бейджи на xxxxx Gitlab не копируются
+			name:       "downstream-private-skipped",
+			downstream: true,
+			source: func(source *fakeGitLab) {
+				area := source.addGroup("mock-sync", "mock-sync", nil)
+				root := source.addGroup("xxxxx", "xxxxx", area)
+				addRepo(source, root, "app")
+				secret := source.addGroup("Secret", "secret", root)
+				source.addBadge(secret, "private")
+				addRepo(source, secret, "hidden")
+				addRepo(source, source.addGroup("Nested", "nested", secret), "hidden-nested")
+				open := source.addGroup("Open", "open", root)
+				source.addBadge(open, "xxx")
+				addRepo(source, open, "visible")
+			},
+		},
+		{
+			// Бейдж private на самой mock-sync закрывает перенос целиком
+			name:       "downstream-private-area",
+			downstream: true,
+			source: func(source *fakeGitLab) {
+				area := source.addGroup("mock-sync", "mock-sync", nil)
+				source.addBadge(area, "private")
+				addRepo(source, source.addGroup("xxxxx", "xxxxx", area), "app")
+			},
+		},
+		{
+			name:       "downstream-root-badge-removal",
+			downstream: true,
+			source: func(source *fakeGitLab) {
+				area := source.addGroup("mock-sync", "mock-sync", nil)
+				addRepo(source, source.addGroup("xxxxx", "xxxxx", area), "app")
+			},
+			dest: func(dest *fakeGitLab) {
+				area := dest.addGroup("mock-sync", "mock-sync", nil)
+				dest.addBadge(area, "private")
+				dest.addBadge(area, "xxx")
+			},
+		},
+	}
+	// Рабочая директория теста меняется на временную, поэтому путь к golden файлам нужен абсолютный
+	goldenDir, err := filepath.Abs(filepath.Join("testdata", "badge-routing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setUpTestWorkspace(t)
+			source := newFakeGitLab(t, "source-token")
+			dest := newFakeGitLab(t, "dest-token")
+			tt.source(source)
+			if tt.dest != nil {
+				tt.dest(dest)
+			}
+			if tt.downstream {
+				saved := destAddress
+				destAddress = dest.URL()
+				t.Cleanup(func() { destAddress = saved })
+			}
+
+			runSync(t, newTestConfig(t, source, dest, nil))
+
+			got := dest.dump()
+			goldenPath := filepath.Join(goldenDir, tt.name+".golden")
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(goldenPath), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(goldenPath, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("failed to read golden file (run with -update to create): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("destination tree mismatch\n--- got:\n%s--- want (%s):\n%s", got, goldenPath, want)
+			}
+		})
+	}
+}
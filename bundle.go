@@ -0,0 +1,899 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Команды программы. Без команды выполняется обычная синхронизация двух Gitlab
+const (
+	commandBundle   = "bundle"   // Выгрузить Gitlab-source в файлы для переноса в изолированную сеть
+	commandUnbundle = "unbundle" // Загрузить файлы на Gitlab-destination в изолированной сети
+	commandKeygen   = "keygen"   // Создать ключи подписи и шифрования выгрузки
+)
+
+// bundleManifestFile -- манифест внутри директории или архива с выгрузкой
+const bundleManifestFile = "manifest.json"
+
+// Command -- команда, переданная в аргументах программы
+type Command struct {
+	Name  string
+	Path  string // Директория или архив (.tar.gz) с выгрузкой, для keygen -- директория для ключей
+	Since string // Манифест предыдущей выгрузки для инкрементальной выгрузки
+}
+
+// BundleManifest описывает содержимое выгрузки
+type BundleManifest struct {
+	CreatedAt   time.Time       `json:"created_at"`
+	Source      string          `json:"source"`
+	Incremental bool            `json:"incremental"`
+	Encrypted   bool            `json:"encrypted"`
+	Groups      []BundleGroup   `json:"groups"`
+	Projects    []BundleProject `json:"projects"`
+	LFSObjects  []BundleFile    `json:"lfs_objects"`
+}
+
+// BundleGroup -- группа Gitlab-source в выгрузке (в порядке обхода: родители раньше детей)
+type BundleGroup struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	FullPath string `json:"full_path"`
+	Badge    string `json:"badge,omitempty"`
+}
+
+// BundleProject -- проект в выгрузке. Пустой File означает, что проект не изменился с прошлой выгрузки
+type BundleProject struct {
+	ID          int               `json:"id"`
+	Name        string            `json:"name"`
+	Path        string            `json:"path"`
+	Group       string            `json:"group"`
+	Mode        string            `json:"mode"`
+	File        string            `json:"file,omitempty"`
+	SHA256      string            `json:"sha256,omitempty"`
+	Incremental bool              `json:"incremental,omitempty"`
+	Refs        map[string]string `json:"refs,omitempty"`
+	LFS         []string          `json:"lfs,omitempty"`
+}
+
+// fullPath -- полный путь проекта на Gitlab-source. В выгрузках старых версий пути нет, там использовалось имя
+func (p BundleProject) fullPath() string {
+	if p.Path == "" {
+		return p.Group + "/" + p.Name
+	}
+	return p.Group + "/" + p.Path
+}
+
+// BundleFile -- файл выгрузки с контрольной суммой
+type BundleFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// parseCommand разбирает аргументы программы. Пути приводятся к абсолютным до смены рабочей директории
+func parseCommand(args []string) (Command, error) {
+	if len(args) == 0 {
+		return Command{}, nil
+	}
+	command := Command{Name: args[0]}
+	flags := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	switch command.Name {
+	case commandBundle:
+		flags.StringVar(&command.Since, "since", "", "manifest.json of the previous bundle for an incremental bundle")
+	case commandUnbundle, commandKeygen:
+	default:
+		return Command{}, fmt.Errorf("unknown command %q, expected %s, %s or %s", command.Name, commandBundle, commandUnbundle, commandKeygen)
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return Command{}, err
+	}
+	if flags.NArg() != 1 {
+		return Command{}, fmt.Errorf("usage: %s %s [flags] <directory|file.tar.gz>", filepath.Base(os.Args[0]), command.Name)
+	}
+	var err error
+	if command.Path, err = filepath.Abs(flags.Arg(0)); err != nil {
+		return Command{}, err
+	}
+	if command.Since != "" {
+		if command.Since, err = filepath.Abs(command.Since); err != nil {
+			return Command{}, err
+		}
+	}
+	return command, nil
+}
+
+// isTarball проверяет, нужно ли упаковать выгрузку в архив вместо директории
+func isTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// bundler обходит Gitlab-source и складывает проекты в директорию выгрузки
+type bundler struct {
+	config          Config
+	generalLogger   *log.Logger
+	corruptedLogger *log.Logger
+	findingsLogger  *log.Logger
+	dir             string
+	manifest        *BundleManifest
+	previous        map[string]BundleProject // Проекты прошлой выгрузки по полному пути
+	lfsSeen         map[string]bool
+	signingKey      ed25519.PrivateKey
+	encryptionKey   *ecdh.PublicKey
+}
+
+// runBundle выгружает Gitlab-source в директорию или архив
+func runBundle(ctx context.Context, config Config, generalLogger, corruptedLogger, findingsLogger *log.Logger, command Command) error {
+	fmt.Println("[DEBUG] runBundle-> Start bundle to: ", command.Path)
+	generalLogger.Println("[DEBUG] runBundle-> Start bundle to: ", command.Path)
+	// git bundle создается и разбирается системным git при любой реализации операций git
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("git is required for %s: %w", command.Name, err)
+	}
+	b := &bundler{
+		config:          config,
+		generalLogger:   generalLogger,
+		corruptedLogger: corruptedLogger,
+		findingsLogger:  findingsLogger,
+		dir:             command.Path,
+		manifest:        &BundleManifest{CreatedAt: time.Now(), Source: config.GitlabURLSource},
+		previous:        make(map[string]BundleProject),
+		lfsSeen:         make(map[string]bool),
+	}
+	// Прочитаем ключи подписи и шифрования, если они заданы
+	var err error
+	if config.BundleSigningKey != "" {
+		if b.signingKey, err = loadSigningKey(config.BundleSigningKey); err != nil {
+			return err
+		}
+	}
+	if config.BundleEncryptionKey != "" {
+		if b.encryptionKey, err = loadEncryptionKey(config.BundleEncryptionKey); err != nil {
+			return err
+		}
+		b.manifest.Encrypted = true
+	}
+	// Прочитаем предыдущий манифест для инкрементальной выгрузки
+	if command.Since != "" {
+		previous, err := readManifest(command.Since)
+		if err != nil {
+			return err
+		}
+		for _, project := range previous.Projects {
+			b.previous[project.fullPath()] = project
+		}
+		b.manifest.Incremental = true
+	}
+	// Архив сначала собираем во временной директории
+	if isTarball(command.Path) {
+		stageDir, err := os.MkdirTemp("", "gitlab-bundle-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(stageDir)
+		b.dir = stageDir
+	}
+	for _, dir := range []string{"projects", "lfs"} {
+		if err := os.MkdirAll(filepath.Join(b.dir, dir), 0755); err != nil {
+			return err
+		}
+	}
+	rootGroups, err := getRootGroups(ctx, generalLogger, config.GitlabURLSource, config.PrivateTokenSource)
+	if err != nil {
+		return err
+	}
+	for _, group := range rootGroups {
+		if !rootGroupAllowed(config, group) {
+			continue
+		}
+		if err := b.bundleGroup(ctx, group); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(b.manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(b.dir, bundleManifestFile), data, 0644); err != nil {
+		return err
+	}
+	// Подпишем манифест: в нем контрольные суммы всех файлов выгрузки
+	if b.signingKey != nil {
+		if err := signFile(filepath.Join(b.dir, bundleManifestFile), b.signingKey); err != nil {
+			return err
+		}
+	}
+	if isTarball(command.Path) {
+		if err := writeTarGz(b.dir, command.Path); err != nil {
+			return err
+		}
+	}
+	fmt.Println("[SUCCESS] runBundle<- Bundle complete: ", command.Path)
+	generalLogger.Println("[SUCCESS] runBundle<- Bundle complete: ", command.Path)
+	return nil
+}
+
+// bundleGroup выгружает проекты группы и рекурсивно всех её подгрупп
+func (b *bundler) bundleGroup(ctx context.Context, group Group) error {
+	config := b.config
+	// Фильтруем группы так же, как и при прямой синхронизации
+	badge, _ := getBadge(ctx, config.GitlabURLSource, config.PrivateTokenSource, group.ID)
+	if config.GitlabURLDest == destAddress && badge == "private" {
+		return nil
+	}
+	b.manifest.Groups = append(b.manifest.Groups, BundleGroup{Name: group.Name, Path: group.Path, FullPath: group.FullPath, Badge: badge})
+	projects := getProjectsFromGroup(ctx, b.generalLogger, config.GitlabURLSource, config.PrivateTokenSource, group.ID)
+	config.exports.prefetch(ctx, archiveProjectIDs(config, projects))
+	for _, project := range projects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b.bundleProject(ctx, group, project)
+	}
+	subgroups, err := getSubgroupsInGroup(ctx, b.generalLogger, fmt.Sprintf("%s/api/v4", config.GitlabURLSource), config.PrivateTokenSource, group.ID)
+	if err != nil {
+		return err
+	}
+	for _, subgroup := range subgroups {
+		if err := b.bundleGroup(ctx, subgroup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bundleProject выгружает один проект: git bundle (или архив экспорта) и LFS объекты
+func (b *bundler) bundleProject(ctx context.Context, group Group, project Project) {
+	config := b.config
+	fullPath := project.PathWithNamespace
+	entry := BundleProject{ID: project.ID, Name: project.Name, Path: project.Path, Group: group.FullPath, Mode: transferModeFor(config, fullPath)}
+	projectReport := &ProjectReport{Project: fullPath, Mode: commandBundle + "/" + entry.Mode, Status: statusSuccess}
+	config.report.add(projectReport)
+	// Слишком большие проекты в выгрузку не попадают
+	projectReport.RepositorySize = project.Statistics.RepositorySize
+	err := checkProjectSize(config, project)
+	if errors.Is(err, errRepositoryTooLarge) {
+		fmt.Printf("[WARNING] Skipping project %s: %v\n", fullPath, err)
+		b.generalLogger.Printf("[WARNING] Skipping project %s: %v\n", fullPath, err)
+		projectReport.Status = statusSkipped
+		projectReport.Error = err.Error()
+		return
+	}
+	switch {
+	case err != nil:
+	case entry.Mode == transferModeArchive:
+		if err = checkArchiveMode(config, fullPath); err == nil {
+			noteNotScanned(config, b.findingsLogger, fullPath)
+			err = b.exportArchive(ctx, &entry)
+		}
+	case project.EmptyRepo:
+		// Пустой репозиторий выгружать нечего, проект попадет в манифест без файла
+	default:
+		err = b.createGitBundle(ctx, project, &entry, projectReport)
+	}
+	if errors.Is(err, errSecretGateClosed) {
+		// Статус проекта уже выставил secretGate, в выгрузку проект не попадает
+		return
+	}
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to bundle project %s: %v\n", fullPath, err)
+		b.generalLogger.Printf("[ERROR] Failed to bundle project %s: %v\n", fullPath, err)
+		b.corruptedLogger.Printf("Project currupted: %d;%s\n", project.ID, project.Name)
+		projectReport.Status = statusFailed
+		projectReport.Error = err.Error()
+		return
+	}
+	if entry.File == "" {
+		projectReport.Status = statusSkipped
+	}
+	b.manifest.Projects = append(b.manifest.Projects, entry)
+}
+
+// exportArchive кладет в выгрузку архив экспорта проекта
+func (b *bundler) exportArchive(ctx context.Context, entry *BundleProject) error {
+	config := b.config
+	if err := config.exports.wait(ctx, entry.ID); err != nil {
+		return err
+	}
+	entry.File = path.Join("projects", fmt.Sprintf("%d.tar.gz", entry.ID))
+	for {
+		err := downloadProject(ctx, config.GitlabURLSource, config.PrivateTokenSource, entry.ID, filepath.Join(b.dir, entry.File))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errRateLimited) {
+			return err
+		}
+		if err := sleepContext(ctx, exportCheckPeriod); err != nil {
+			return err
+		}
+	}
+	var err error
+	entry.File, entry.SHA256, err = b.seal(entry.File)
+	return err
+}
+
+// seal шифрует файл выгрузки (если задан ключ шифрования) и считает sha256 того, что останется в выгрузке.
+// Возвращает новый относительный путь файла и его sha256
+func (b *bundler) seal(relPath string) (string, string, error) {
+	filePath := filepath.Join(b.dir, filepath.FromSlash(relPath))
+	if b.encryptionKey != nil {
+		if err := encryptFile(filePath, filePath+encryptedSuffix, b.encryptionKey); err != nil {
+			return "", "", fmt.Errorf("failed to encrypt %s: %w", relPath, err)
+		}
+		if err := os.Remove(filePath); err != nil {
+			return "", "", err
+		}
+		relPath += encryptedSuffix
+		filePath += encryptedSuffix
+	}
+	sum, err := fileSHA256(filePath)
+	return relPath, sum, err
+}
+
+// createGitBundle клонирует репозиторий и создает git bundle. Для инкрементальной выгрузки в bundle
+// попадают только коммиты, которых не было в прошлой выгрузке, а неизменившиеся проекты пропускаются
+func (b *bundler) createGitBundle(ctx context.Context, project Project, entry *BundleProject, projectReport *ProjectReport) error {
+	config := b.config
+	mirrorDir := filepath.Join(tmpDir, fmt.Sprintf("bundle-%d.git", entry.ID))
+	defer os.RemoveAll(mirrorDir)
+	repoURL := buildSourceRepoURL(config, project)
+	if err := cloneRepo(ctx, b.generalLogger, b.corruptedLogger, config.gitSource, repoURL, mirrorDir); err != nil {
+		return err
+	}
+	if err := checkLFSSupported(config, mirrorDir); err != nil {
+		return err
+	}
+	// Удалим из истории то, что не должно попасть на Gitlab-destination, и перепишем авторов, как при прямом переносе
+	if filter, ok := historyFilterFor(config, entry.fullPath()); ok || config.identities != nil {
+		if err := rewriteHistory(b.generalLogger, newHistoryRewriter(filter, config.identities), mirrorDir, entry.fullPath()); err != nil {
+			return err
+		}
+		if config.identities != nil {
+			if err := config.state.persist(); err != nil {
+				return err
+			}
+		}
+	}
+	projectReport.LFS = fetchLFS(ctx, config, b.generalLogger, b.corruptedLogger, mirrorDir, repoURL)
+	// Без части LFS объектов проект перенесся бы неполным
+	if err := lfsIncomplete(projectReport.LFS, nil); err != nil {
+		return err
+	}
+	// Проверим историю на секреты до того, как она попадет в выгрузку
+	if !secretGate(config, b.generalLogger, b.findingsLogger, mirrorDir, entry.fullPath(), projectReport) {
+		return errSecretGateClosed
+	}
+	refs, err := listRefs(mirrorDir)
+	if err != nil {
+		return err
+	}
+	entry.Refs = refs
+	previous, hasPrevious := b.previous[entry.fullPath()]
+	if hasPrevious && refsEqual(previous.Refs, refs) {
+		return nil
+	}
+	entry.File = path.Join("projects", fmt.Sprintf("%d.bundle", entry.ID))
+	bundlePath := filepath.Join(b.dir, entry.File)
+	args := []string{"-C", mirrorDir, "bundle", "create", bundlePath, "--branches", "--tags"}
+	if hasPrevious {
+		var known []string
+		for _, sha := range previous.Refs {
+			// Исключаем только коммиты, которые есть в репозитории (история могла быть переписана)
+			if exec.Command("git", "-C", mirrorDir, "cat-file", "-e", sha+"^{commit}").Run() == nil {
+				known = append(known, sha)
+			}
+		}
+		if len(known) != 0 {
+			entry.Incremental = true
+			args = append(append(args, "--not"), known...)
+		}
+	}
+	if err := runGit(ctx, b.generalLogger, args...); err != nil {
+		if !entry.Incremental {
+			return err
+		}
+		// Например, если ветки переехали на уже известные коммиты -- bundle получится пустым. Выгрузим целиком
+		entry.Incremental = false
+		if err := runGit(ctx, b.generalLogger, "-C", mirrorDir, "bundle", "create", bundlePath, "--branches", "--tags"); err != nil {
+			return err
+		}
+	}
+	if entry.File, entry.SHA256, err = b.seal(entry.File); err != nil {
+		return err
+	}
+	return b.collectLFS(mirrorDir, entry)
+}
+
+// collectLFS копирует LFS объекты репозитория в выгрузку (одинаковые объекты хранятся один раз)
+func (b *bundler) collectLFS(mirrorDir string, entry *BundleProject) error {
+	lfsDir := filepath.Join(mirrorDir, "lfs", "objects")
+	err := filepath.WalkDir(lfsDir, func(objectPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		oid := d.Name()
+		entry.LFS = append(entry.LFS, oid)
+		if b.lfsSeen[oid] {
+			return nil
+		}
+		b.lfsSeen[oid] = true
+		target := path.Join("lfs", oid)
+		if err := copyFile(objectPath, filepath.Join(b.dir, target)); err != nil {
+			return err
+		}
+		target, sum, err := b.seal(target)
+		if err != nil {
+			return err
+		}
+		b.manifest.LFSObjects = append(b.manifest.LFSObjects, BundleFile{Path: target, SHA256: sum})
+		return nil
+	})
+	return err
+}
+
+// runUnbundle загружает выгрузку на Gitlab-destination: создает группы и проекты и пушит в них репозитории
+func runUnbundle(ctx context.Context, config Config, generalLogger *log.Logger, command Command) error {
+	fmt.Println("[DEBUG] runUnbundle-> Start unbundle from: ", command.Path)
+	generalLogger.Println("[DEBUG] runUnbundle-> Start unbundle from: ", command.Path)
+	// git bundle создается и разбирается системным git при любой реализации операций git
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("git is required for %s: %w", command.Name, err)
+	}
+	dir := command.Path
+	if isTarball(command.Path) {
+		extractDir, err := os.MkdirTemp("", "gitlab-unbundle-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(extractDir)
+		if err := extractTarGz(command.Path, extractDir); err != nil {
+			return err
+		}
+		dir = extractDir
+	}
+	// Проверим подпись манифеста до того, как доверять контрольным суммам в нем
+	manifestPath := filepath.Join(dir, bundleManifestFile)
+	if config.BundleVerifyKey != "" {
+		verifyKey, err := loadVerifyKey(config.BundleVerifyKey)
+		if err != nil {
+			return err
+		}
+		if err := verifyFileSignature(manifestPath, verifyKey); err != nil {
+			return fmt.Errorf("refusing to import bundle: %w", err)
+		}
+	} else if _, err := os.Stat(manifestPath + signatureSuffix); err == nil {
+		// Подписанную выгрузку без проверки подписи не загружаем: скорее всего, ключ забыли указать
+		return errors.New("refusing to import bundle: bundle is signed, bundleVerifyKey must be set")
+	} else {
+		fmt.Println("[WARNING] Bundle is not signed, its origin is not verified")
+		generalLogger.Println("[WARNING] Bundle is not signed, its origin is not verified")
+	}
+	manifest, err := readManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	var decryptionKey *ecdh.PrivateKey
+	if manifest.Encrypted {
+		if config.BundleDecryptionKey == "" {
+			return errors.New("bundle is encrypted, bundleDecryptionKey must be set")
+		}
+		if decryptionKey, err = loadDecryptionKey(config.BundleDecryptionKey); err != nil {
+			return err
+		}
+	}
+	// Проверим контрольные суммы LFS объектов. Проекты с поврежденными объектами не загружаются
+	lfsFiles := make(map[string]string)
+	for _, object := range manifest.LFSObjects {
+		if err := verifyFile(dir, object.Path, object.SHA256); err != nil {
+			fmt.Printf("[ERROR] LFS object is corrupted: %v\n", err)
+			generalLogger.Printf("[ERROR] LFS object is corrupted: %v\n", err)
+			continue
+		}
+		lfsFiles[strings.TrimSuffix(path.Base(object.Path), encryptedSuffix)] = object.Path
+	}
+	// Создадим группы в том же порядке, в котором они выгружались (родители раньше детей)
+	xxxAreaGroupID := createGroup(ctx, generalLogger, config.GitlabURLDest, config.PrivateTokenDest, Group{Name: xxxArea, Path: xxxArea, FullPath: xxxArea}, 0, true)
+	groupIDs := make(map[string]int)
+	for _, group := range manifest.Groups {
+		parentGroupID := xxxAreaGroupID
+		if slash := strings.LastIndex(group.FullPath, "/"); slash >= 0 {
+			parentGroupID = groupIDs[group.FullPath[:slash]]
+		}
+		groupDest := config.paths.mapPath(group.FullPath)
+		if group.Path == xxxArea {
+			groupIDs[group.FullPath] = parentGroupID
+		} else if groupDest == destGroupPath(config, group.FullPath) {
+			groupIDs[group.FullPath] = createGroup(ctx, generalLogger, config.GitlabURLDest, config.PrivateTokenDest, Group{Name: group.Name, Path: group.Path, FullPath: group.FullPath}, parentGroupID, false)
+		} else {
+			// Группа переименована правилами -- создадим недостающие группы по новому пути
+			groupID, err := ensureGroupPath(ctx, config, generalLogger, groupDest)
+			if err != nil {
+				return err
+			}
+			groupIDs[group.FullPath] = groupID
+		}
+		// Применим бэйдж из исходного Gitlab на удаленный
+		if group.Badge != "" && config.GitlabURLDest != destAddress {
+			existingBadge, _ := getBadge(ctx, config.GitlabURLDest, config.PrivateTokenDest, groupIDs[group.FullPath])
+			if existingBadge == "" {
+				setBadge(ctx, config.GitlabURLDest, config.PrivateTokenDest, group.Badge, groupIDs[group.FullPath])
+			}
+		}
+	}
+	for _, project := range manifest.Projects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fullPath := project.fullPath()
+		projectReport := &ProjectReport{Project: fullPath, Mode: commandUnbundle + "/" + project.Mode, Status: statusSuccess}
+		config.report.add(projectReport)
+		if project.File == "" {
+			projectReport.Status = statusSkipped
+			continue
+		}
+		if err := unbundleProject(ctx, config, generalLogger, dir, project, lfsFiles, decryptionKey, projectReport); err != nil {
+			fmt.Printf("[ERROR] Failed to unbundle project %s: %v\n", fullPath, err)
+			generalLogger.Printf("[ERROR] Failed to unbundle project %s: %v\n", fullPath, err)
+			projectReport.Status = statusFailed
+			projectReport.Error = err.Error()
+			continue
+		}
+	}
+	fmt.Println("[SUCCESS] runUnbundle<- Unbundle complete: ", command.Path)
+	generalLogger.Println("[SUCCESS] runUnbundle<- Unbundle complete: ", command.Path)
+	return nil
+}
+
+// unbundleProject загружает один проект из выгрузки на Gitlab-destination
+func unbundleProject(ctx context.Context, config Config, generalLogger *log.Logger, dir string, project BundleProject, lfsFiles map[string]string, decryptionKey *ecdh.PrivateKey, projectReport *ProjectReport) error {
+	if err := verifyFile(dir, project.File, project.SHA256); err != nil {
+		return err
+	}
+	// Без LFS объектов проект перенесся бы неполным
+	for _, oid := range project.LFS {
+		if _, ok := lfsFiles[oid]; !ok {
+			return fmt.Errorf("LFS object %s is corrupted or missing in bundle", oid)
+		}
+	}
+	if len(project.LFS) != 0 && config.GitBackend == gitBackendGoGit {
+		return fmt.Errorf("project has %d LFS objects: %w", len(project.LFS), errLFSNotSupported)
+	}
+	destPath := config.paths.mapPath(project.fullPath())
+	if err := config.paths.claim(ctx, config, project.fullPath(), destPath); err != nil {
+		return err
+	}
+	groupDest, projectDestPath := splitDestPath(destPath)
+	if groupDest != config.paths.mapPath(project.Group) {
+		if _, err := ensureGroupPath(ctx, config, generalLogger, groupDest); err != nil {
+			return err
+		}
+	}
+	filePath, cleanup, err := openBundleFile(dir, project.File, decryptionKey)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if project.Mode == transferModeArchive {
+		return importArchive(ctx, config, generalLogger, filePath, projectDestPath, groupDest, projectReport)
+	}
+	destURL := buildDestRepoURL(config, groupDest, projectDestPath)
+	mirrorDir := filepath.Join(tmpDir, fmt.Sprintf("unbundle-%d.git", project.ID))
+	defer os.RemoveAll(mirrorDir)
+	if project.Incremental {
+		// Инкрементальный bundle требует коммиты прошлой выгрузки -- возьмем их с Gitlab-destination
+		cloneCtx, cancel := context.WithTimeout(ctx, operationTimeouts.Clone)
+		err := config.gitDest.Clone(cloneCtx, destURL, mirrorDir)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if err := runGit(ctx, generalLogger, "-C", mirrorDir, "fetch", filePath, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"); err != nil {
+			return err
+		}
+	} else if err := runGit(ctx, generalLogger, "clone", "--mirror", filePath, mirrorDir); err != nil {
+		return err
+	}
+	// Положим LFS объекты туда, где их ищет git lfs push
+	for _, oid := range project.LFS {
+		relPath := lfsFiles[oid]
+		if len(oid) < 4 {
+			continue
+		}
+		target := filepath.Join(mirrorDir, "lfs", "objects", oid[0:2], oid[2:4], oid)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		objectPath, cleanupObject, err := openBundleFile(dir, relPath, decryptionKey)
+		if err != nil {
+			return err
+		}
+		err = copyFile(objectPath, target)
+		cleanupObject()
+		if err != nil {
+			return err
+		}
+	}
+	// LFS объекты проекта перечислены в манифесте: без них git lfs не нужен
+	lfsStats := &LFSStats{}
+	var lfsErr error
+	if len(project.LFS) != 0 {
+		lfsErr = pushLFS(ctx, config, generalLogger, mirrorDir, destURL, destPath, lfsStats)
+	}
+	refResults, pushErr := pushRepo(ctx, generalLogger, config.gitDest, mirrorDir, destURL, config.refNamespaces, config.PushBatchSize, pushChunkCommits(config, mirrorDir), config.state.pushedRefs(destPath))
+	projectReport.addRefResults(refResults)
+	if err := config.state.markRefsPushed(destPath, refResults); err != nil {
+		return err
+	}
+	if pushErr != nil {
+		return pushErr
+	}
+	// Новый проект создается только пушем веток, поэтому неудачный push LFS повторим после него
+	if lfsErr != nil {
+		if err := pushLFS(ctx, config, generalLogger, mirrorDir, destURL, destPath, lfsStats); err != nil {
+			return err
+		}
+	}
+	// Разрешим force push в ветку по умолчанию для следующих загрузок
+	destProjectID, err := getProjectIDByPath(ctx, config.GitlabURLDest, config.PrivateTokenDest, destPath)
+	if err != nil {
+		return err
+	}
+	defaultBranchName, err := getProjectDefaultBranch(ctx, config.GitlabURLDest, config.PrivateTokenDest, destProjectID)
+	if err == nil && defaultBranchName != "" {
+		if err := allowForcePush(ctx, config.GitlabURLDest, defaultBranchName, config.PrivateTokenDest, destProjectID); err != nil {
+			fmt.Printf("[WARNING] Failed to remove force push option: %v\n", err)
+			generalLogger.Printf("[WARNING] Failed to remove force push option: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// openBundleFile возвращает путь к расшифрованному файлу выгрузки и функцию удаления временного файла
+func openBundleFile(dir, relPath string, decryptionKey *ecdh.PrivateKey) (string, func(), error) {
+	filePath := filepath.Join(dir, filepath.FromSlash(relPath))
+	if !strings.HasSuffix(relPath, encryptedSuffix) {
+		return filePath, func() {}, nil
+	}
+	if decryptionKey == nil {
+		return "", nil, fmt.Errorf("%s is encrypted, bundleDecryptionKey must be set", relPath)
+	}
+	// Расширение сохраняем: git и gitlab определяют формат файла по нему
+	decryptedDir, err := os.MkdirTemp(tmpDir, "decrypted-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(decryptedDir) }
+	decryptedPath := filepath.Join(decryptedDir, strings.TrimSuffix(path.Base(relPath), encryptedSuffix))
+	if err := decryptFile(filePath, decryptedPath, decryptionKey); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return decryptedPath, cleanup, nil
+}
+
+// readManifest читает манифест выгрузки
+func readManifest(manifestPath string) (*BundleManifest, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// listRefs возвращает ветки и теги репозитория: имя ссылки -> sha
+func listRefs(repoDir string) (map[string]string, error) {
+	output, err := exec.Command("git", "-C", repoDir, "for-each-ref", "--format=%(refname) %(objectname)", "refs/heads/", "refs/tags/").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if name, sha, found := strings.Cut(line, " "); found {
+			refs[name] = sha
+		}
+	}
+	return refs, nil
+}
+
+// refsEqual сравнивает два набора ссылок
+func refsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, sha := range a {
+		if b[name] != sha {
+			return false
+		}
+	}
+	return true
+}
+
+// runGit запускает git с окружением программы, вывод пишется в лог
+func runGit(ctx context.Context, generalLogger *log.Logger, args ...string) error {
+	if _, err := (&execMirror{logger: generalLogger}).run(ctx, "", args...); err != nil {
+		return fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// fileSHA256 считает sha256 файла
+func fileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyFile проверяет контрольную сумму файла выгрузки
+func verifyFile(dir, relPath, expected string) error {
+	sum, err := fileSHA256(filepath.Join(dir, filepath.FromSlash(relPath)))
+	if err != nil {
+		return err
+	}
+	if sum != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", relPath, expected, sum)
+	}
+	return nil
+}
+
+// copyFile копирует файл
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeTarGz упаковывает директорию в архив .tar.gz
+func writeTarGz(srcDir, archivePath string) error {
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	var files []string
+	err = filepath.WalkDir(srcDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, filePath := range files {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, filePath)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := copyInto(tarWriter, filePath); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// copyInto дописывает содержимое файла в writer
+func copyInto(w io.Writer, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// extractTarGz распаковывает архив .tar.gz в директорию
+func extractTarGz(archivePath, destDir string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		// Не даем архиву записать файлы за пределы директории
+		target := filepath.Join(destDir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(destDir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid file path in archive: %s", header.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tarReader); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bundleSource выгружает Gitlab-source в директорию и возвращает ее путь
+func bundleSource(t *testing.T, config Config) string {
+	t.Helper()
+	discard := log.New(io.Discard, "", 0)
+	bundleDir := filepath.Join(t.TempDir(), "bundle")
+	if err := runBundle(context.Background(), config, discard, discard, discard, Command{Name: commandBundle, Path: bundleDir}); err != nil {
+		t.Fatalf("runBundle: %v", err)
+	}
+	return bundleDir
+}
+
+// unbundle загружает выгрузку на Gitlab-destination
+func unbundle(config Config, bundleDir string) error {
+	return runUnbundle(context.Background(), config, log.New(io.Discard, "", 0), Command{Name: commandUnbundle, Path: bundleDir})
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newSourceFixture(t)
+	dest := newFakeGitLab(t, "dest-token")
+	keys := newTestKeys(t)
+	bundleDir := bundleSource(t, newTestConfig(t, source, dest, func(config *Config) {
+		config.BundleSigningKey = filepath.Join(keys, signingKeyFile)
+		config.BundleEncryptionKey = filepath.Join(keys, encryptionKeyFile)
+	}))
+	config := newTestConfig(t, source, dest, func(config *Config) {
+		config.BundleVerifyKey = filepath.Join(keys, verifyKeyFile)
+		config.BundleDecryptionKey = filepath.Join(keys, decryptionKeyFile)
+	})
+
+	if err := unbundle(config, bundleDir); err != nil {
+		t.Fatalf("runUnbundle: %v", err)
+	}
+
+	for sourcePath, destPath := range map[string]string{
+		"xxxxx/app":     "mock-sync/xxxxx/app",
+		"xxxxx/sub/lib": "mock-sync/xxxxx/sub/lib",
+	} {
+		if got, want := dest.refs(destPath), source.refs(sourcePath); !reflect.DeepEqual(got, want) {
+			t.Errorf("refs of %s = %v, want %v", destPath, got, want)
+		}
+	}
+	wantStatuses := map[string]string{
+		"xxxxx/app":     statusSuccess,
+		"xxxxx/empty":   statusSkipped,
+		"xxxxx/sub/lib": statusSuccess,
+	}
+	if got := reportStatuses(config); !reflect.DeepEqual(got, wantStatuses) {
+		t.Errorf("report statuses = %v, want %v", got, wantStatuses)
+	}
+}
+
+// TestUnbundleSignature проверяет, что подписанная выгрузка загружается только с верным ключом проверки
+func TestUnbundleSignature(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newSourceFixture(t)
+	keys := newTestKeys(t)
+	bundleDir := bundleSource(t, newTestConfig(t, source, newFakeGitLab(t, "dest-token"), func(config *Config) {
+		config.BundleSigningKey = filepath.Join(keys, signingKeyFile)
+	}))
+	otherKeys := newTestKeys(t)
+	tests := []struct {
+		name      string
+		verifyKey string
+		wantErr   string
+	}{
+		{name: "no verify key", wantErr: "bundleVerifyKey must be set"},
+		{name: "wrong verify key", verifyKey: filepath.Join(otherKeys, verifyKeyFile), wantErr: "signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := newFakeGitLab(t, "dest-token")
+			config := newTestConfig(t, source, dest, func(config *Config) {
+				config.BundleVerifyKey = tt.verifyKey
+			})
+
+			err := unbundle(config, bundleDir)
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("runUnbundle error = %v, want %q", err, tt.wantErr)
+			}
+			if tree := dest.tree(); len(tree) != 0 {
+				t.Errorf("destination tree = %q, want nothing imported", tree)
+			}
+		})
+	}
+}
+
+// TestUnbundleCorruptedLFS проверяет, что проект с поврежденным LFS объектом не загружается,
+// а остальные проекты загружаются
+func TestUnbundleCorruptedLFS(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newSourceFixture(t)
+	dest := newFakeGitLab(t, "dest-token")
+	bundleDir := bundleSource(t, newTestConfig(t, source, dest, nil))
+	// Добавим в выгрузку LFS объект проекта xxxxx/app с неверной контрольной суммой
+	manifestPath := filepath.Join(bundleDir, bundleManifestFile)
+	manifest, err := readManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oid := strings.Repeat("ab", 32)
+	if err := os.WriteFile(filepath.Join(bundleDir, "lfs", oid), []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest.LFSObjects = append(manifest.LFSObjects, BundleFile{Path: "lfs/" + oid, SHA256: oid})
+	for i := range manifest.Projects {
+		if manifest.Projects[i].fullPath() == "xxxxx/app" {
+			manifest.Projects[i].LFS = append(manifest.Projects[i].LFS, oid)
+		}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := newTestConfig(t, source, dest, nil)
+
+	if err := unbundle(config, bundleDir); err != nil {
+		t.Fatalf("runUnbundle: %v", err)
+	}
+
+	statuses := reportStatuses(config)
+	if statuses["xxxxx/app"] != statusFailed || statuses["xxxxx/sub/lib"] != statusSuccess {
+		t.Errorf("report statuses = %v, want xxxxx/app failed and xxxxx/sub/lib succeeded", statuses)
+	}
+	if dest.projectByPath("mock-sync/xxxxx/app") != nil {
+		t.Error("project with corrupted LFS object was imported")
+	}
+}
+
+// TestBundleRewritesHistory проверяет, что в git bundle попадает история, уже отфильтрованная historyFilters
+// и с переписанными авторами, как при прямом переносе
+func TestBundleRewritesHistory(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		check     func(t *testing.T, source *fakeGitLab, config Config, cloneDir string)
+	}{
+		{
+			name: "history filter",
+			configure: func(config *Config) {
+				config.HistoryFilters = map[string]HistoryFilter{"xxxxx/app": {DropPaths: []string{"src"}}}
+			},
+			check: func(t *testing.T, source *fakeGitLab, config Config, cloneDir string) {
+				if files := source.git(cloneDir, "log", "--all", "--name-only", "--format="); strings.Contains(files, "src/") {
+					t.Errorf("bundled history contains filtered files:\n%s", files)
+				}
+			},
+		},
+		{
+			name: "identities",
+			configure: func(config *Config) {
+				config.IdentityMailmap = writeMailmap(t, "Jane Doe <jane@example.com> <author@example.com>\n")
+			},
+			check: func(t *testing.T, source *fakeGitLab, config Config, cloneDir string) {
+				if authors := strings.TrimSpace(source.git(cloneDir, "log", "--all", "--format=%an <%ae> %cn <%ce>")); strings.Contains(authors, "author@example.com") {
+					t.Errorf("bundled history contains original authors:\n%s", authors)
+				}
+				if got := config.state.Identities["Test Author <author@example.com>"]; got != "Jane Doe <jane@example.com>" {
+					t.Errorf("remembered identity = %q, want Jane Doe <jane@example.com>", got)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setUpTestWorkspace(t)
+			source := newSourceFixture(t)
+			config := newTestConfig(t, source, newFakeGitLab(t, "dest-token"), tt.configure)
+
+			bundleDir := bundleSource(t, config)
+
+			manifest, err := readManifest(filepath.Join(bundleDir, "manifest.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, project := range manifest.Projects {
+				if project.fullPath() != "xxxxx/app" {
+					continue
+				}
+				cloneDir := filepath.Join(t.TempDir(), "app.git")
+				source.git("", "clone", "--quiet", "--mirror", filepath.Join(bundleDir, filepath.FromSlash(project.File)), cloneDir)
+				tt.check(t, source, config, cloneDir)
+				if got, err := listRefs(cloneDir); err != nil || !reflect.DeepEqual(got, project.Refs) {
+					t.Errorf("bundled refs = %v (%v), want manifest refs %v", got, err, project.Refs)
+				}
+				return
+			}
+			t.Fatal("xxxxx/app is missing in manifest")
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Файлы выгрузки шифруются так же, как это делает age: для каждого файла создается одноразовый
+// ключ X25519, общий секрет с ключом получателя превращается в ключ AES-256-GCM через HKDF-SHA256,
+// и файл шифруется блоками по 64 KiB (nonce -- номер блока и признак последнего блока)
+const (
+	encryptedMagic     = "GITLAB-INJECT-ENC-1\n"
+	encryptedSuffix    = ".enc"
+	encryptedChunkSize = 64 * 1024
+	hkdfInfoFileKey    = "gitlab-inject file key"
+	signatureSuffix    = ".sig"
+)
+
+// Файлы ключей, которые создает команда keygen
+const (
+	signingKeyFile    = "signing.key"
+	verifyKeyFile     = "signing.pub"
+	encryptionKeyFile = "encryption.pub"
+	decryptionKeyFile = "encryption.key"
+)
+
+// errSignatureMissing -- рядом с подписываемым файлом нет файла подписи
+var errSignatureMissing = errors.New("signature file is missing")
+
+// generateKeys создает пары ключей для подписи (ed25519) и шифрования (X25519) в директории
+func generateKeys(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	verifyKey, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	decryptionKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	keys := []struct {
+		file    string
+		key     interface{}
+		private bool
+	}{
+		{signingKeyFile, signingKey, true},
+		{verifyKeyFile, verifyKey, false},
+		{decryptionKeyFile, decryptionKey, true},
+		{encryptionKeyFile, decryptionKey.PublicKey(), false},
+	}
+	for _, key := range keys {
+		var block *pem.Block
+		if key.private {
+			der, err := x509.MarshalPKCS8PrivateKey(key.key)
+			if err != nil {
+				return err
+			}
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		} else {
+			der, err := x509.MarshalPKIXPublicKey(key.key)
+			if err != nil {
+				return err
+			}
+			block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+		}
+		mode := os.FileMode(0644)
+		if key.private {
+			mode = 0600
+		}
+		if err := os.WriteFile(filepath.Join(dir, key.file), pem.EncodeToMemory(block), mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPEM читает ключ из PEM файла
+func readPEM(path string, private bool) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM key %s", path)
+	}
+	if private {
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// loadSigningKey читает закрытый ключ ed25519 для подписи манифеста
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	key, err := readPEM(path, true)
+	if err != nil {
+		return nil, err
+	}
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", path)
+	}
+	return signingKey, nil
+}
+
+// loadVerifyKey читает открытый ключ ed25519 для проверки подписи манифеста
+func loadVerifyKey(path string) (ed25519.PublicKey, error) {
+	key, err := readPEM(path, false)
+	if err != nil {
+		return nil, err
+	}
+	verifyKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", path)
+	}
+	return verifyKey, nil
+}
+
+// loadEncryptionKey читает открытый ключ X25519 получателя выгрузки
+func loadEncryptionKey(path string) (*ecdh.PublicKey, error) {
+	key, err := readPEM(path, false)
+	if err != nil {
+		return nil, err
+	}
+	encryptionKey, ok := key.(*ecdh.PublicKey)
+	if !ok || encryptionKey.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%s is not an X25519 public key", path)
+	}
+	return encryptionKey, nil
+}
+
+// loadDecryptionKey читает закрытый ключ X25519 получателя выгрузки
+func loadDecryptionKey(path string) (*ecdh.PrivateKey, error) {
+	key, err := readPEM(path, true)
+	if err != nil {
+		return nil, err
+	}
+	decryptionKey, ok := key.(*ecdh.PrivateKey)
+	if !ok || decryptionKey.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%s is not an X25519 private key", path)
+	}
+	return decryptionKey, nil
+}
+
+// signFile подписывает файл и кладет подпись (base64) рядом в файл с суффиксом .sig
+func signFile(filePath string, key ed25519.PrivateKey) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return os.WriteFile(filePath+signatureSuffix, []byte(signature+"\n"), 0644)
+}
+
+// verifyFileSignature проверяет подпись файла из файла .sig
+func verifyFileSignature(filePath string, key ed25519.PublicKey) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	encoded, err := os.ReadFile(filePath + signatureSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return errSignatureMissing
+	}
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return fmt.Errorf("signature of %s is invalid", filepath.Base(filePath))
+	}
+	return nil
+}
+
+// fileCipher создает AES-GCM для файла по общему секрету X25519
+func fileCipher(secret, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(hkdfInfoFileKey)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce -- nonce блока: 11 байт номера блока и 1 байт признака последнего блока
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptFile шифрует src в dst для получателя recipient
+func encryptFile(src, dst string, recipient *ecdh.PublicKey) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return err
+	}
+	aead, err := fileCipher(secret, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(out)
+	writer.WriteString(encryptedMagic)
+	writer.Write(ephemeral.PublicKey().Bytes())
+	reader := bufio.NewReaderSize(in, encryptedChunkSize)
+	buf := make([]byte, encryptedChunkSize)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// Блок последний, если после него данных больше нет
+		_, peekErr := reader.Peek(1)
+		last := peekErr == io.EOF
+		if _, err := writer.Write(aead.Seal(nil, chunkNonce(counter, last), buf[:n], nil)); err != nil {
+			return err
+		}
+		if last {
+			break
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// decryptFile расшифровывает src в dst. Любое изменение файла приводит к ошибке
+func decryptFile(src, dst string, key *ecdh.PrivateKey) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	reader := bufio.NewReaderSize(in, encryptedChunkSize+64)
+	header := make([]byte, len(encryptedMagic)+32)
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(encryptedMagic)]) != encryptedMagic {
+		return fmt.Errorf("%s is not an encrypted bundle file", filepath.Base(src))
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(header[len(encryptedMagic):])
+	if err != nil {
+		return err
+	}
+	secret, err := key.ECDH(ephemeral)
+	if err != nil {
+		return err
+	}
+	aead, err := fileCipher(secret, ephemeral.Bytes(), key.PublicKey().Bytes())
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	buf := make([]byte, encryptedChunkSize+aead.Overhead())
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to decrypt %s: truncated file", filepath.Base(src))
+		}
+		_, peekErr := reader.Peek(1)
+		last := peekErr == io.EOF
+		plain, err := aead.Open(nil, chunkNonce(counter, last), buf[:n], nil)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", filepath.Base(src), err)
+		}
+		if _, err := out.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			break
+		}
+	}
+	return out.Close()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestKeys создает ключи, как команда keygen, и возвращает директорию с ними
+func newTestKeys(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "keys")
+	if err := generateKeys(dir); err != nil {
+		t.Fatalf("generateKeys: %v", err)
+	}
+	return dir
+}
+
+// loadTestEncryptionKeys читает пару ключей шифрования из директории keygen
+func loadTestEncryptionKeys(t *testing.T, dir string) (*ecdh.PublicKey, *ecdh.PrivateKey) {
+	t.Helper()
+	encryptionKey, err := loadEncryptionKey(filepath.Join(dir, encryptionKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptionKey, err := loadDecryptionKey(filepath.Join(dir, decryptionKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encryptionKey, decryptionKey
+}
+
+func TestEncryptFileRoundTrip(t *testing.T) {
+	encryptionKey, decryptionKey := loadTestEncryptionKeys(t, newTestKeys(t))
+	random := make([]byte, 3*encryptedChunkSize+17)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]byte{
+		"empty":          nil,
+		"small":          []byte("hello\n"),
+		"one chunk":      random[:encryptedChunkSize],
+		"chunk multiple": random[:2*encryptedChunkSize],
+		"several chunks": random,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			plain, encrypted, decrypted := filepath.Join(dir, "plain"), filepath.Join(dir, "plain.enc"), filepath.Join(dir, "decrypted")
+			if err := os.WriteFile(plain, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := encryptFile(plain, encrypted, encryptionKey); err != nil {
+				t.Fatalf("encryptFile: %v", err)
+			}
+			if err := decryptFile(encrypted, decrypted, decryptionKey); err != nil {
+				t.Fatalf("decryptFile: %v", err)
+			}
+
+			got, err := os.ReadFile(decrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("decrypted %d bytes, want original %d bytes", len(got), len(content))
+			}
+			if len(content) > 0 {
+				ciphertext, _ := os.ReadFile(encrypted)
+				if bytes.Contains(ciphertext, content) {
+					t.Error("encrypted file contains plaintext")
+				}
+			}
+		})
+	}
+}
+
+// TestDecryptFileRejectsTampering проверяет, что любое изменение зашифрованного файла и чужой ключ
+// приводят к ошибке, а не к неверному содержимому
+func TestDecryptFileRejectsTampering(t *testing.T) {
+	encryptionKey, decryptionKey := loadTestEncryptionKeys(t, newTestKeys(t))
+	_, wrongKey := loadTestEncryptionKeys(t, newTestKeys(t))
+	dir := t.TempDir()
+	plain, encrypted := filepath.Join(dir, "plain"), filepath.Join(dir, "plain.enc")
+	content := bytes.Repeat([]byte("bundle data\n"), encryptedChunkSize/6)
+	if err := os.WriteFile(plain, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := encryptFile(plain, encrypted, encryptionKey); err != nil {
+		t.Fatalf("encryptFile: %v", err)
+	}
+	ciphertext, err := os.ReadFile(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := len(encryptedMagic) + 32
+	flipped := func(i int) []byte {
+		data := append([]byte{}, ciphertext...)
+		data[i] ^= 1
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+		key  *ecdh.PrivateKey
+	}{
+		{name: "wrong key", data: ciphertext, key: wrongKey},
+		{name: "flipped magic", data: flipped(0), key: decryptionKey},
+		{name: "flipped ephemeral key", data: flipped(header - 1), key: decryptionKey},
+		{name: "flipped first chunk", data: flipped(header + 10), key: decryptionKey},
+		{name: "flipped last chunk", data: flipped(len(ciphertext) - 1), key: decryptionKey},
+		// Отрезанный последний блок: предпоследний не помечен последним
+		{name: "truncated", data: ciphertext[:header+encryptedChunkSize+16], key: decryptionKey},
+		{name: "appended", data: append(append([]byte{}, ciphertext...), 0), key: decryptionKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := filepath.Join(t.TempDir(), "tampered.enc")
+			if err := os.WriteFile(tampered, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := decryptFile(tampered, filepath.Join(t.TempDir(), "decrypted"), tt.key); err == nil {
+				t.Error("decryptFile succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestSignFile(t *testing.T) {
+	keys := newTestKeys(t)
+	signingKey, err := loadSigningKey(filepath.Join(keys, signingKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyKey, err := loadVerifyKey(filepath.Join(keys, verifyKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(t.TempDir(), bundleManifestFile)
+	if err := os.WriteFile(filePath, []byte(`{"projects": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyFileSignature(filePath, verifyKey); !errors.Is(err, errSignatureMissing) {
+		t.Errorf("verify without signature = %v, want %v", err, errSignatureMissing)
+	}
+	if err := signFile(filePath, signingKey); err != nil {
+		t.Fatalf("signFile: %v", err)
+	}
+	if err := verifyFileSignature(filePath, verifyKey); err != nil {
+		t.Errorf("verify signed file: %v", err)
+	}
+	if err := verifyFileSignature(filePath, wrongKey); err == nil {
+		t.Error("verify with wrong key succeeded, want error")
+	}
+	if err := os.WriteFile(filePath, []byte(`{"projects": [{}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyFileSignature(filePath, verifyKey); err == nil {
+		t.Error("verify of modified file succeeded, want error")
+	}
+}
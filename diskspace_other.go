@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+// freeDiskSpace -- сколько байт доступно для записи на файловой системе с директорией dir
+func freeDiskSpace(dir string) (int64, error) {
+	return 0, errDiskSpaceUnknown
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Статусы экспорта проекта (GET /projects/:id/export)
+const (
+	exportStatusNone         = "none"
+	exportStatusQueued       = "queued"
+	exportStatusStarted      = "started"
+	exportStatusFinished     = "finished"
+	exportStatusFailed       = "failed"
+	exportStatusRegeneration = "regeneration_in_progress"
+)
+
+const (
+	exportDefaultConcurrency = 1
+	// Ограничение Gitlab по умолчанию: 6 запросов экспорта в минуту на пользователя
+	exportDefaultRateLimit = 6
+	exportRetries          = 2 // Сколько раз экспорт перезапускается после статуса failed
+	// Статус none допускается не дольше operationTimeouts.Export / exportNoneShare (10 минут из часа), прежде чем
+	// считать экспорт несостоявшимся
+	exportNoneShare = 6
+)
+
+// Периоды проверки статуса экспорта: начиная с exportCheckPeriod, пока статус не меняется, период
+// растет вдвое до exportMaxCheckPeriod. exportRateWindow -- окно, в котором считается exportRateLimit.
+// Переменные, а не константы, чтобы тесты не ждали
+var (
+	exportCheckPeriod    = 5 * time.Second
+	exportMaxCheckPeriod = time.Minute
+	exportRateWindow     = time.Minute
+)
+
+// errExportFailed -- Gitlab-source не смог экспортировать проект (статус failed)
+var errExportFailed = errors.New("export failed on Gitlab-source")
+
+// exportScheduler запускает экспорты проектов на Gitlab-source: не больше concurrency одновременно
+// и не больше rateLimit запросов экспорта за exportRateWindow
+type exportScheduler struct {
+	url           string
+	token         string
+	rateLimit     int
+	generalLogger *log.Logger
+	slots         chan struct{}
+
+	mu     sync.Mutex
+	starts []time.Time // Время запросов экспорта за последние exportRateWindow
+	jobs   map[int]*exportJob
+}
+
+// exportJob -- экспорт одного проекта. err доступна после закрытия done
+type exportJob struct {
+	done chan struct{}
+	err  error
+}
+
+// newExportScheduler создает планировщик экспортов по настройкам exportConcurrency и exportRateLimit
+func newExportScheduler(config Config, generalLogger *log.Logger) *exportScheduler {
+	concurrency := config.ExportConcurrency
+	if concurrency <= 0 {
+		concurrency = exportDefaultConcurrency
+	}
+	rateLimit := config.ExportRateLimit
+	if rateLimit <= 0 {
+		rateLimit = exportDefaultRateLimit
+	}
+	return &exportScheduler{
+		url:           config.GitlabURLSource,
+		token:         config.PrivateTokenSource,
+		rateLimit:     rateLimit,
+		generalLogger: generalLogger,
+		slots:         make(chan struct{}, concurrency),
+		jobs:          make(map[int]*exportJob),
+	}
+}
+
+// prefetch заранее запускает экспорты проектов, чтобы они шли параллельно с переносом предыдущих
+// проектов. Результат забирает wait. С exportConcurrency 1 ничего не делает: экспорт идет по очереди
+func (s *exportScheduler) prefetch(ctx context.Context, projectIDs []int) {
+	if cap(s.slots) <= 1 {
+		return
+	}
+	for _, projectID := range projectIDs {
+		s.start(ctx, projectID)
+	}
+}
+
+// wait запускает экспорт проекта, если он еще не запущен prefetch, и ждет его окончания
+func (s *exportScheduler) wait(ctx context.Context, projectID int) error {
+	job := s.start(ctx, projectID)
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// Следующий перенос того же проекта экспортирует его заново
+	s.mu.Lock()
+	delete(s.jobs, projectID)
+	s.mu.Unlock()
+	return job.err
+}
+
+// start возвращает экспорт проекта, запуская его, если он еще не запущен
+func (s *exportScheduler) start(ctx context.Context, projectID int) *exportJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[projectID]; ok {
+		return job
+	}
+	job := &exportJob{done: make(chan struct{})}
+	s.jobs[projectID] = job
+	go func() {
+		defer close(job.done)
+		job.err = s.run(ctx, projectID)
+	}()
+	return job
+}
+
+// run занимает слот и экспортирует проект, перезапуская экспорт после статуса failed
+func (s *exportScheduler) run(ctx context.Context, projectID int) error {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.slots }()
+	ctx, cancel := context.WithTimeout(ctx, operationTimeouts.Export)
+	defer cancel()
+	for attempt := 0; ; attempt++ {
+		if err := s.schedule(ctx, projectID); err != nil {
+			return err
+		}
+		err := s.poll(ctx, projectID)
+		if !errors.Is(err, errExportFailed) || attempt >= exportRetries {
+			return err
+		}
+		fmt.Printf("[WARNING] Export of project %d failed, retrying (%d of %d)\n", projectID, attempt+1, exportRetries)
+		s.generalLogger.Printf("[WARNING] Export of project %d failed, retrying (%d of %d)\n", projectID, attempt+1, exportRetries)
+	}
+}
+
+// schedule ставит экспорт в очередь Gitlab-source, не превышая rateLimit запросов за exportRateWindow.
+// Если Gitlab все равно ответил 429, запрос повторяется после exportRateWindow
+func (s *exportScheduler) schedule(ctx context.Context, projectID int) error {
+	for {
+		if err := s.reserve(ctx); err != nil {
+			return err
+		}
+		err := exportProject(ctx, s.url, s.token, projectID)
+		if !errors.Is(err, errRateLimited) {
+			return err
+		}
+		fmt.Printf("[WARNING] Export rate limit exceeded, retrying project %d in %v\n", projectID, exportRateWindow)
+		s.generalLogger.Printf("[WARNING] Export rate limit exceeded, retrying project %d in %v\n", projectID, exportRateWindow)
+		if err := sleepContext(ctx, exportRateWindow); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve ждет, пока за последние exportRateWindow станет меньше rateLimit запросов экспорта, и учитывает новый
+func (s *exportScheduler) reserve(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		recent := s.starts[:0]
+		for _, start := range s.starts {
+			if now.Sub(start) < exportRateWindow {
+				recent = append(recent, start)
+			}
+		}
+		s.starts = recent
+		if len(s.starts) < s.rateLimit {
+			s.starts = append(s.starts, now)
+			s.mu.Unlock()
+			return nil
+		}
+		delay := s.starts[0].Add(exportRateWindow).Sub(now)
+		s.mu.Unlock()
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// poll ждет окончания поставленного в очередь экспорта. Статус none допускается только первую долю
+// operationTimeouts.Export (см. exportNoneShare) -- пока Gitlab не поставил экспорт в очередь.
+// regeneration_in_progress -- Gitlab пересоздает готовый архив, его тоже нужно дождаться
+func (s *exportScheduler) poll(ctx context.Context, projectID int) error {
+	period := exportCheckPeriod
+	previous := ""
+	started := time.Now()
+	for {
+		status, err := getExportStatus(ctx, s.url, s.token, projectID)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case exportStatusFinished:
+			return nil
+		case exportStatusFailed:
+			return errExportFailed
+		case exportStatusNone:
+			if time.Since(started) >= operationTimeouts.Export/exportNoneShare {
+				return errors.New("export was not started on Gitlab-source")
+			}
+		case exportStatusQueued, exportStatusStarted, exportStatusRegeneration:
+		default:
+			return fmt.Errorf("unknown export status %q", status)
+		}
+		// Пока статус не меняется, проверяем все реже
+		if status == previous {
+			period = min(2*period, exportMaxCheckPeriod)
+		} else {
+			period = exportCheckPeriod
+		}
+		previous = status
+		if err := sleepContext(ctx, period); err != nil {
+			return fmt.Errorf("export was not finished on Gitlab-source: %w", err)
+		}
+	}
+}
+
+// archiveProjectIDs возвращает проекты, которые будут перенесены через экспорт архива, -- их экспорт
+// можно запустить заранее (prefetch)
+func archiveProjectIDs(config Config, projects []Project) []int {
+	var ids []int
+	for _, project := range projects {
+		if transferModeFor(config, project.PathWithNamespace) == transferModeArchive && checkArchiveMode(config, project.PathWithNamespace) == nil && checkProjectSize(config, project) == nil {
+			ids = append(ids, project.ID)
+		}
+	}
+	return ids
+}
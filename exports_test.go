@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shortenExportPeriods сокращает периоды проверки экспорта, окно ограничения запросов и ожидание экспорта
+// на время теста
+func shortenExportPeriods(t *testing.T, rateWindow time.Duration) {
+	t.Helper()
+	checkPeriod, maxCheckPeriod, window, timeouts := exportCheckPeriod, exportMaxCheckPeriod, exportRateWindow, operationTimeouts
+	exportCheckPeriod, exportMaxCheckPeriod, exportRateWindow = time.Millisecond, 4*time.Millisecond, rateWindow
+	operationTimeouts.Export = 3 * time.Second
+	t.Cleanup(func() {
+		exportCheckPeriod, exportMaxCheckPeriod, exportRateWindow, operationTimeouts = checkPeriod, maxCheckPeriod, window, timeouts
+	})
+}
+
+func TestExportSchedulerStatuses(t *testing.T) {
+	shortenExportPeriods(t, 10*time.Millisecond)
+	tests := []struct {
+		name      string
+		statuses  []string
+		responses []int
+		wantErr   bool
+		wantPosts int
+	}{
+		{name: "queued and started", statuses: []string{"none", "queued", "started", "started", "finished"}, wantPosts: 1},
+		{name: "regeneration", statuses: []string{"regeneration_in_progress", "finished"}, wantPosts: 1},
+		{name: "failed then retried", statuses: []string{"started", "failed", "queued", "finished"}, wantPosts: 2},
+		{name: "always failed", statuses: []string{"failed"}, wantErr: true, wantPosts: exportRetries + 1},
+		{name: "never started", statuses: []string{"none"}, wantErr: true, wantPosts: 1},
+		{name: "unknown status", statuses: []string{"archived"}, wantErr: true, wantPosts: 1},
+		{name: "rate limited", responses: []int{http.StatusTooManyRequests}, wantPosts: 2},
+		{name: "not accepted", responses: []int{http.StatusForbidden}, wantErr: true, wantPosts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newFakeGitLab(t, "token")
+			project := source.addProject(source.addGroup("group", "group", nil), "app", "app", nil, nil, nil)
+			project.ExportStatuses = tt.statuses
+			project.ExportResponses = tt.responses
+			exports := newExportScheduler(Config{GitlabURLSource: source.URL(), PrivateTokenSource: source.token}, log.New(io.Discard, "", 0))
+
+			err := exports.wait(context.Background(), project.ID)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("wait error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := len(project.exportRequests); got != tt.wantPosts {
+				t.Errorf("export requests = %d, want %d", got, tt.wantPosts)
+			}
+			// Экспорт, который Gitlab так и не начал, не ждем все время operationTimeouts.Export
+			if tt.name == "never started" && (err == nil || !strings.Contains(err.Error(), "was not started")) {
+				t.Errorf("wait error = %v, want export not started", err)
+			}
+			if tt.name == "not accepted" && project.exportChecks != 0 {
+				t.Errorf("export status was checked %d times after rejected export request", project.exportChecks)
+			}
+		})
+	}
+}
+
+// TestExportSchedulerLimits проверяет, что экспорты идут параллельно, но не больше exportConcurrency
+// одновременно и не больше exportRateLimit запросов за exportRateWindow
+func TestExportSchedulerLimits(t *testing.T) {
+	const rateWindow = 300 * time.Millisecond
+	shortenExportPeriods(t, rateWindow)
+	source := newFakeGitLab(t, "token")
+	group := source.addGroup("group", "group", nil)
+	var projects []*fakeProject
+	var ids []int
+	for _, name := range []string{"a", "b", "c", "d"} {
+		project := source.addProject(group, name, name, nil, nil, nil)
+		project.ExportStatuses = []string{"queued", "started", "started", "finished"}
+		projects = append(projects, project)
+		ids = append(ids, project.ID)
+	}
+	exports := newExportScheduler(Config{
+		GitlabURLSource:    source.URL(),
+		PrivateTokenSource: source.token,
+		ExportConcurrency:  2,
+		ExportRateLimit:    3,
+	}, log.New(io.Discard, "", 0))
+
+	exports.prefetch(context.Background(), ids)
+	for _, id := range ids {
+		if err := exports.wait(context.Background(), id); err != nil {
+			t.Fatalf("wait %d: %v", id, err)
+		}
+	}
+
+	if source.maxActiveExports != 2 {
+		t.Errorf("max concurrent exports = %d, want 2", source.maxActiveExports)
+	}
+	var requests []time.Time
+	for _, project := range projects {
+		if len(project.exportRequests) != 1 {
+			t.Fatalf("project %s exported %d times, want once", project.Path, len(project.exportRequests))
+		}
+		requests = append(requests, project.exportRequests[0])
+	}
+	first, last := requests[0], requests[0]
+	for _, request := range requests {
+		if request.Before(first) {
+			first = request
+		}
+		if request.After(last) {
+			last = request
+		}
+	}
+	// Время запросов сервер записывает позже, чем планировщик их учитывает, поэтому с запасом
+	if last.Sub(first) < rateWindow*3/4 {
+		t.Errorf("4 export requests within %v, want rate limit of 3 per %v", last.Sub(first), rateWindow)
+	}
+}
+
+func TestExportSchedulerCanceled(t *testing.T) {
+	shortenExportPeriods(t, 10*time.Millisecond)
+	source := newFakeGitLab(t, "token")
+	project := source.addProject(source.addGroup("group", "group", nil), "app", "app", nil, nil, nil)
+	project.ExportStatuses = []string{"started"}
+	exports := newExportScheduler(Config{GitlabURLSource: source.URL(), PrivateTokenSource: source.token}, log.New(io.Discard, "", 0))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := exports.wait(ctx, project.ID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// Экспорт тоже прерывается: дождемся его, прежде чем вернуть периоды проверки
+	<-exports.start(ctx, project.ID).done
+}
@@ -0,0 +1,1215 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGroup -- группа фейкового Gitlab
+type fakeGroup struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	FullPath string `json:"full_path"`
+	ParentID int    `json:"parent_id"`
+}
+
+// fakeBadge -- бейдж группы фейкового Gitlab
+type fakeBadge struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	LinkURL  string `json:"link_url"`
+	ImageURL string `json:"image_url"`
+}
+
+// fakeUser -- пользователь фейкового Gitlab. Email отдается всегда, как администратору
+type fakeUser struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+	PublicEmail string `json:"public_email"`
+}
+
+// fakeMember -- участник группы или проекта фейкового Gitlab
+type fakeMember struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	State       string `json:"state"`
+	AccessLevel int    `json:"access_level"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+}
+
+// fakeRelease -- релиз проекта фейкового Gitlab
+type fakeRelease struct {
+	TagName     string
+	Name        string
+	Description string
+	Milestones  []string
+	Links       []ReleaseLink
+}
+
+// fakeProject -- проект фейкового Gitlab. Репозиторий лежит в fakeGitLab.repoRoot/<полный путь>.git
+type fakeProject struct {
+	ID        int
+	Name      string
+	Path      string
+	Namespace *fakeGroup
+	// RepositorySize -- размер в статистике проекта (0 -- размер репозитория на диске)
+	RepositorySize int64
+	// ExportStatuses -- статусы экспорта, которые отдаются по очереди на каждую проверку (последний
+	// повторяется, пусто -- finished). ExportResponses -- коды ответов на запросы экспорта по очереди (пусто -- 202)
+	ExportStatuses  []string
+	ExportResponses []int
+	// RegistryTags -- теги образов в реестре контейнеров проекта (репозиторий с путем проекта)
+	RegistryTags []string
+	// Packages -- пакеты generic "<имя>/<версия>" с файлами (имя файла -> содержимое). PackagesWithoutSHA --
+	// не отдавать file_sha256, как Gitlab без контрольных сумм у файлов. PackageUploads -- сколько файлов
+	// пакетов загружено в проект
+	Packages           map[string]map[string]string
+	PackagesWithoutSHA bool
+	PackageUploads     int
+	LFSObjects         map[string]bool // LFS объекты в хранилище проекта (oid)
+	// Releases -- релизы проекта, Milestones -- названия milestones. Релиз ссылается только на существующие milestones
+	Releases       []*fakeRelease
+	Milestones     []string
+	exportRequests []time.Time // Время запросов экспорта
+	exportChecks   int
+	exporting      bool
+	importFailed   bool // Импорт архива в этот проект завершился статусом failed
+}
+
+// fullPath -- полный путь проекта
+func (p *fakeProject) fullPath() string {
+	return p.Namespace.FullPath + "/" + p.Path
+}
+
+// fakeGitLab -- Gitlab в памяти: API v4 (только то, чем пользуется программа) и git по smart HTTP
+// через git http-backend. Пуш в несуществующий проект создает его, как и настоящий Gitlab
+type fakeGitLab struct {
+	t        *testing.T
+	server   *httptest.Server
+	token    string
+	repoRoot string
+
+	mu       sync.Mutex
+	nextID   int
+	groups   map[int]*fakeGroup
+	projects map[int]*fakeProject
+	badges   map[int][]fakeBadge
+	users    []*fakeUser
+	// Участники групп и проектов: "groups/<ID>" или "projects/<ID>" -> ID пользователя -> участник
+	members map[string]map[int]*fakeMember
+	// Журнал запросов к API: "<метод> <путь>"
+	requests []string
+	// Сколько экспортов идет сейчас и сколько шло одновременно максимум
+	activeExports    int
+	maxActiveExports int
+	// Сколько следующих импортов архивов завершатся статусом failed
+	importFailures int
+}
+
+// gitRepoPath разбирает адрес git по smart HTTP: /<полный путь проекта>.git/<служебный путь>
+var gitRepoPath = regexp.MustCompile(`^/(.+?)\.git(/.*)$`)
+
+// newFakeGitLab запускает фейковый Gitlab, который принимает только токен token
+func newFakeGitLab(t *testing.T, token string) *fakeGitLab {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	fake := &fakeGitLab{
+		t:        t,
+		token:    token,
+		repoRoot: t.TempDir(),
+		nextID:   1,
+		groups:   make(map[int]*fakeGroup),
+		projects: make(map[int]*fakeProject),
+		badges:   make(map[int][]fakeBadge),
+		members:  make(map[string]map[int]*fakeMember),
+	}
+	// HTTPS, как у настоящего Gitlab: токены git получает от credential helper'ов только для https адресов
+	fake.server = httptest.NewTLSServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+// URL -- адрес фейкового Gitlab (как gitlabURLSource/gitlabURLDest в конфигурации)
+func (f *fakeGitLab) URL() string {
+	return f.server.URL
+}
+
+// newID выдает следующий ID (общий для групп, проектов и бейджей, как удобно для отладки)
+func (f *fakeGitLab) newID() int {
+	id := f.nextID
+	f.nextID++
+	return id
+}
+
+// addGroup создает группу. parent == nil -- корневая группа
+func (f *fakeGitLab) addGroup(name, groupPath string, parent *fakeGroup) *fakeGroup {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.createGroupLocked(name, groupPath, parent)
+}
+
+func (f *fakeGitLab) createGroupLocked(name, groupPath string, parent *fakeGroup) *fakeGroup {
+	group := &fakeGroup{ID: f.newID(), Name: name, Path: groupPath, FullPath: groupPath}
+	if parent != nil {
+		group.ParentID = parent.ID
+		group.FullPath = parent.FullPath + "/" + groupPath
+	}
+	f.groups[group.ID] = group
+	return group
+}
+
+// addBadge добавляет группе бейдж
+func (f *fakeGitLab) addBadge(group *fakeGroup, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.badges[group.ID] = append(f.badges[group.ID], fakeBadge{ID: f.newID(), Name: name})
+}
+
+// addProject создает проект с репозиторием: по коммиту на каждый элемент commits (путь файла -> содержимое),
+// ветки branches от последнего коммита и аннотированные теги tags
+func (f *fakeGitLab) addProject(group *fakeGroup, name, projectPath string, commits []map[string]string, branches, tags []string) *fakeProject {
+	f.t.Helper()
+	f.mu.Lock()
+	project := &fakeProject{ID: f.newID(), Name: name, Path: projectPath, Namespace: group}
+	f.projects[project.ID] = project
+	f.mu.Unlock()
+	repoDir := f.repoDir(project)
+	f.git("", "init", "--quiet", "--bare", "--initial-branch=main", repoDir)
+	f.git(repoDir, "config", "http.receivepack", "true")
+	if len(commits) == 0 {
+		return project
+	}
+	workDir := f.t.TempDir()
+	f.git("", "init", "--quiet", "--initial-branch=main", workDir)
+	for i, files := range commits {
+		for file, content := range files {
+			filePath := filepath.Join(workDir, filepath.FromSlash(file))
+			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+				f.t.Fatal(err)
+			}
+			if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+				f.t.Fatal(err)
+			}
+		}
+		f.git(workDir, "add", "--all")
+		f.git(workDir, "commit", "--quiet", "-m", fmt.Sprintf("commit %d", i+1))
+	}
+	for _, branch := range branches {
+		f.git(workDir, "branch", branch)
+	}
+	for _, tag := range tags {
+		f.git(workDir, "tag", "-a", tag, "-m", tag)
+	}
+	f.git(workDir, "push", "--quiet", repoDir, "refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*")
+	return project
+}
+
+// protectRef ставит в репозиторий проекта pre-receive хук, который, как Gitlab для защищенной ветки,
+// отклоняет пуш целиком, если в нем есть ссылка ref
+func (f *fakeGitLab) protectRef(project *fakeProject, ref string) {
+	f.t.Helper()
+	hook := "#!/bin/sh\n" +
+		"while read old new ref; do\n" +
+		"  if [ \"$ref\" = \"" + ref + "\" ]; then\n" +
+		"    echo \"GitLab: You are not allowed to push code to protected branches on this project.\" >&2\n" +
+		"    exit 1\n" +
+		"  fi\n" +
+		"done\n"
+	if err := os.WriteFile(filepath.Join(f.repoDir(project), "hooks", "pre-receive"), []byte(hook), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// removeProject удаляет проект вместе с репозиторием
+func (f *fakeGitLab) removeProject(fullPath string) {
+	f.t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	project := f.projectByPathLocked(fullPath)
+	if project == nil {
+		f.t.Fatalf("fake gitlab: project %s not found", fullPath)
+	}
+	if err := os.RemoveAll(f.repoDir(project)); err != nil {
+		f.t.Fatal(err)
+	}
+	delete(f.projects, project.ID)
+}
+
+// failImports задает, сколько следующих импортов архивов завершатся ошибкой (проект при этом создается)
+func (f *fakeGitLab) failImports(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.importFailures = n
+}
+
+// addUser создает пользователя
+func (f *fakeGitLab) addUser(username, email string) *fakeUser {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user := &fakeUser{ID: f.newID(), Username: username, Email: email}
+	f.users = append(f.users, user)
+	return user
+}
+
+// addMember делает пользователя участником группы или проекта (kind -- "groups" или "projects")
+func (f *fakeGitLab) addMember(kind string, id int, user *fakeUser, accessLevel int, state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := fmt.Sprintf("%s/%d", kind, id)
+	if f.members[key] == nil {
+		f.members[key] = make(map[int]*fakeMember)
+	}
+	f.members[key][user.ID] = &fakeMember{ID: user.ID, Username: user.Username, State: state, AccessLevel: accessLevel}
+}
+
+// memberAccess возвращает участников группы или проекта с полным путем fullPath: username -> уровень доступа
+func (f *fakeGitLab) memberAccess(kind, fullPath string) map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var id int
+	if kind == "groups" {
+		if group := f.groupByRefLocked(fullPath); group != nil {
+			id = group.ID
+		}
+	} else if project := f.projectByPathLocked(fullPath); project != nil {
+		id = project.ID
+	}
+	access := make(map[string]int)
+	for _, member := range f.members[fmt.Sprintf("%s/%d", kind, id)] {
+		access[member.Username] = member.AccessLevel
+	}
+	return access
+}
+
+// repoDir -- путь к bare репозиторию проекта
+func (f *fakeGitLab) repoDir(project *fakeProject) string {
+	return filepath.Join(f.repoRoot, filepath.FromSlash(project.fullPath())+".git")
+}
+
+// git выполняет git с детерминированным автором и датой
+func (f *fakeGitLab) git(dir string, args ...string) string {
+	f.t.Helper()
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Test Author", "GIT_AUTHOR_EMAIL=author@example.com", "GIT_AUTHOR_DATE=2024-01-01T00:00:00Z",
+		"GIT_COMMITTER_NAME=Test Author", "GIT_COMMITTER_EMAIL=author@example.com", "GIT_COMMITTER_DATE=2024-01-01T00:00:00Z",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		f.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return string(output)
+}
+
+// refs возвращает ссылки проекта по полному пути из пространств prefixes (по умолчанию ветки и теги):
+// ссылка -> SHA. nil -- проекта нет
+func (f *fakeGitLab) refs(fullPath string, prefixes ...string) map[string]string {
+	f.t.Helper()
+	project := f.projectByPath(fullPath)
+	if project == nil {
+		return nil
+	}
+	return f.projectRefs(project, prefixes...)
+}
+
+// projectRefs -- refs для найденного проекта, без блокировки f.mu
+func (f *fakeGitLab) projectRefs(project *fakeProject, prefixes ...string) map[string]string {
+	f.t.Helper()
+	if len(prefixes) == 0 {
+		prefixes = []string{"refs/heads/", "refs/tags/"}
+	}
+	refs := make(map[string]string)
+	output := f.git(f.repoDir(project), append([]string{"for-each-ref", "--format=%(refname) %(objectname)"}, prefixes...)...)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if ref, sha, found := strings.Cut(line, " "); found {
+			refs[ref] = sha
+		}
+	}
+	return refs
+}
+
+// tree возвращает отсортированные полные пути всех групп ("group/") и проектов
+func (f *fakeGitLab) tree() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var paths []string
+	for _, group := range f.groups {
+		paths = append(paths, group.FullPath+"/")
+	}
+	for _, project := range f.projects {
+		paths = append(paths, project.fullPath())
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// dump описывает дерево целиком для golden файлов: группы с бейджами и проекты с ветками и тегами
+func (f *fakeGitLab) dump() string {
+	f.t.Helper()
+	var dump strings.Builder
+	for _, entry := range f.tree() {
+		if fullPath, isGroup := strings.CutSuffix(entry, "/"); isGroup {
+			fmt.Fprintf(&dump, "group   %s badges=%s\n", entry, strings.Join(f.badgeNames(fullPath), ","))
+			continue
+		}
+		var refs []string
+		for ref := range f.refs(entry) {
+			refs = append(refs, ref)
+		}
+		sort.Strings(refs)
+		fmt.Fprintf(&dump, "project %s refs=%s\n", entry, strings.Join(refs, ","))
+	}
+	return dump.String()
+}
+
+// badgeNames возвращает бейджи группы по полному пути
+func (f *fakeGitLab) badgeNames(fullPath string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, group := range f.groups {
+		if group.FullPath == fullPath {
+			for _, badge := range f.badges[group.ID] {
+				names = append(names, badge.Name)
+			}
+		}
+	}
+	return names
+}
+
+// projectByPath ищет проект по полному пути (без учета регистра, как Gitlab)
+func (f *fakeGitLab) projectByPath(fullPath string) *fakeProject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.projectByPathLocked(fullPath)
+}
+
+func (f *fakeGitLab) projectByPathLocked(fullPath string) *fakeProject {
+	for _, project := range f.projects {
+		if strings.EqualFold(project.fullPath(), fullPath) {
+			return project
+		}
+	}
+	return nil
+}
+
+// groupByRefLocked ищет группу по ID или полному пути, как это делает API
+func (f *fakeGitLab) groupByRefLocked(ref string) *fakeGroup {
+	if id, err := strconv.Atoi(ref); err == nil {
+		return f.groups[id]
+	}
+	for _, group := range f.groups {
+		if strings.EqualFold(group.FullPath, ref) {
+			return group
+		}
+	}
+	return nil
+}
+
+// projectByRefLocked ищет проект по ID или полному пути
+func (f *fakeGitLab) projectByRefLocked(ref string) *fakeProject {
+	if id, err := strconv.Atoi(ref); err == nil {
+		return f.projects[id]
+	}
+	return f.projectByPathLocked(ref)
+}
+
+// projectJSON -- проект в формате API
+func (f *fakeGitLab) projectJSON(project *fakeProject) map[string]interface{} {
+	defaultBranch := ""
+	output, err := exec.Command("git", "-C", f.repoDir(project), "for-each-ref", "--format=%(refname:short)", "refs/heads/").Output()
+	branches := strings.Fields(string(output))
+	if err == nil && len(branches) != 0 {
+		defaultBranch = branches[0]
+		for _, branch := range branches {
+			if branch == "main" || branch == "master" {
+				defaultBranch = branch
+			}
+		}
+	}
+	repositorySize := project.RepositorySize
+	if repositorySize == 0 {
+		repositorySize, _ = dirSize(f.repoDir(project))
+	}
+	return map[string]interface{}{
+		"id":                  project.ID,
+		"name":                project.Name,
+		"path":                project.Path,
+		"path_with_namespace": project.fullPath(),
+		"default_branch":      defaultBranch,
+		"http_url_to_repo":    f.URL() + "/" + project.fullPath() + ".git",
+		"archived":            false,
+		"visibility":          "private",
+		"empty_repo":          defaultBranch == "",
+		"last_activity_at":    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		"statistics":          map[string]int64{"repository_size": repositorySize, "lfs_objects_size": 0},
+	}
+}
+
+// serveHTTP разделяет запросы к API и к git
+func (f *fakeGitLab) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/v4/") {
+		if r.Header.Get("PRIVATE-TOKEN") != f.token {
+			http.Error(w, `{"message":"401 Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+		f.mu.Unlock()
+		f.serveAPI(w, r)
+		return
+	}
+	f.serveGit(w, r)
+}
+
+// writeJSON отвечает JSON с кодом status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// serveAPI обрабатывает запросы /api/v4/...
+func (f *fakeGitLab) serveAPI(w http.ResponseWriter, r *http.Request) {
+	var segments []string
+	for _, segment := range strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/api/v4/"), "/") {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		segments = append(segments, unescaped)
+	}
+	route := r.Method + " " + segments[0]
+	if len(segments) > 2 {
+		route += "/:id/" + strings.Join(segments[2:], "/")
+	} else if len(segments) == 2 {
+		route += "/:id"
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case route == "GET groups":
+		var groups []*fakeGroup
+		for _, group := range f.groups {
+			groups = append(groups, group)
+		}
+		sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+		writeJSON(w, http.StatusOK, groups)
+	case route == "POST groups":
+		var request struct {
+			Name     string `json:"name"`
+			Path     string `json:"path"`
+			ParentID int    `json:"parent_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		parent := f.groups[request.ParentID]
+		if request.ParentID != 0 && parent == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Parent Not Found"})
+			return
+		}
+		fullPath := request.Path
+		if parent != nil {
+			fullPath = parent.FullPath + "/" + request.Path
+		}
+		if f.groupByRefLocked(fullPath) != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Failed to save group {:path=>[\"has already been taken\"]}"})
+			return
+		}
+		writeJSON(w, http.StatusCreated, f.createGroupLocked(request.Name, request.Path, parent))
+	case route == "GET users":
+		f.listUsers(w, r)
+	case route == "GET users/:id":
+		id, _ := strconv.Atoi(segments[1])
+		for _, user := range f.users {
+			if user.ID == id {
+				writeJSON(w, http.StatusOK, user)
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 User Not Found"})
+	case strings.HasPrefix(route, "GET groups/:id") || strings.HasPrefix(route, "POST groups/:id") || strings.HasPrefix(route, "PUT groups/:id") || strings.HasPrefix(route, "DELETE groups/:id"):
+		group := f.groupByRefLocked(segments[1])
+		if group == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Group Not Found"})
+			return
+		}
+		f.serveGroup(w, r, strings.TrimPrefix(route, r.Method+" groups/:id"), group, segments)
+	case route == "POST projects/:id" && segments[1] == "import":
+		f.importProject(w, r)
+	case strings.HasPrefix(route, "GET projects/:id") || strings.HasPrefix(route, "POST projects/:id") || strings.HasPrefix(route, "PUT projects/:id") || strings.HasPrefix(route, "DELETE projects/:id"):
+		project := f.projectByRefLocked(segments[1])
+		if project == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Project Not Found"})
+			return
+		}
+		f.serveProject(w, r, strings.TrimPrefix(route, r.Method+" projects/:id"), project, segments)
+	default:
+		f.t.Errorf("fake gitlab: unexpected request %s %s", r.Method, r.URL.Path)
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+	}
+}
+
+// serveGroup обрабатывает запросы /groups/:id/...
+func (f *fakeGitLab) serveGroup(w http.ResponseWriter, r *http.Request, rest string, group *fakeGroup, segments []string) {
+	switch r.Method + " " + rest {
+	case "GET ":
+		writeJSON(w, http.StatusOK, group)
+	case "GET /subgroups":
+		subgroups := []*fakeGroup{}
+		for _, subgroup := range f.groups {
+			if subgroup.ParentID == group.ID {
+				subgroups = append(subgroups, subgroup)
+			}
+		}
+		sort.Slice(subgroups, func(i, j int) bool { return subgroups[i].ID < subgroups[j].ID })
+		writeJSON(w, http.StatusOK, subgroups)
+	case "GET /projects":
+		projects := []map[string]interface{}{}
+		if page := r.URL.Query().Get("page"); page == "" || page == "1" {
+			var ids []int
+			for id, project := range f.projects {
+				if project.Namespace == group {
+					ids = append(ids, id)
+				}
+			}
+			sort.Ints(ids)
+			for _, id := range ids {
+				projects = append(projects, f.projectJSON(f.projects[id]))
+			}
+		}
+		writeJSON(w, http.StatusOK, projects)
+	case "GET /badges":
+		badges := f.badges[group.ID]
+		if badges == nil {
+			badges = []fakeBadge{}
+		}
+		writeJSON(w, http.StatusOK, badges)
+	case "POST /badges":
+		var badge fakeBadge
+		if err := json.NewDecoder(r.Body).Decode(&badge); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		badge.ID = f.newID()
+		f.badges[group.ID] = append(f.badges[group.ID], badge)
+		writeJSON(w, http.StatusCreated, badge)
+	case "GET /members", "POST /members":
+		f.serveMembers(w, r, fmt.Sprintf("groups/%d", group.ID), segments)
+	default:
+		if r.Method == http.MethodPut && len(segments) == 4 && segments[2] == "members" {
+			f.serveMembers(w, r, fmt.Sprintf("groups/%d", group.ID), segments)
+			return
+		}
+		if r.Method == http.MethodDelete && len(segments) == 4 && segments[2] == "badges" {
+			badgeID, _ := strconv.Atoi(segments[3])
+			badges := f.badges[group.ID]
+			for i, badge := range badges {
+				if badge.ID == badgeID {
+					f.badges[group.ID] = append(badges[:i:i], badges[i+1:]...)
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Badge Not Found"})
+			return
+		}
+		f.t.Errorf("fake gitlab: unexpected request %s %s", r.Method, r.URL.Path)
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+	}
+}
+
+// serveProject обрабатывает запросы /projects/:id/...
+func (f *fakeGitLab) serveProject(w http.ResponseWriter, r *http.Request, rest string, project *fakeProject, segments []string) {
+	switch r.Method + " " + rest {
+	case "GET ":
+		writeJSON(w, http.StatusOK, f.projectJSON(project))
+	case "POST /export":
+		f.scheduleExport(w, project)
+	case "GET /export":
+		f.exportStatus(w, project)
+	case "GET /export/download":
+		f.exportProject(w, project)
+	case "GET /import":
+		f.importStatus(w, project)
+	case "GET /members", "POST /members":
+		f.serveMembers(w, r, fmt.Sprintf("projects/%d", project.ID), segments)
+	case "GET /repository/tags":
+		tags := []map[string]string{}
+		if page := r.URL.Query().Get("page"); page == "" || page == "1" {
+			for ref := range f.projectRefs(project, "refs/tags/") {
+				tags = append(tags, map[string]string{"name": strings.TrimPrefix(ref, "refs/tags/")})
+			}
+		}
+		writeJSON(w, http.StatusOK, tags)
+	case "GET /milestones":
+		milestones := []map[string]interface{}{}
+		for i, title := range project.Milestones {
+			if title == r.URL.Query().Get("title") || !r.URL.Query().Has("title") {
+				milestones = append(milestones, map[string]interface{}{"id": i + 1, "title": title})
+			}
+		}
+		writeJSON(w, http.StatusOK, milestones)
+	case "POST /milestones":
+		var request struct {
+			Title string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		project.Milestones = append(project.Milestones, request.Title)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": len(project.Milestones), "title": request.Title})
+	case "GET /releases", "POST /releases":
+		f.serveReleases(w, r, project, segments)
+	case "GET /registry/repositories":
+		repositories := []map[string]interface{}{}
+		if len(project.RegistryTags) != 0 {
+			repositories = append(repositories, map[string]interface{}{"id": project.ID, "name": "", "path": project.fullPath()})
+		}
+		writeJSON(w, http.StatusOK, repositories)
+	case "GET /packages":
+		packages := []map[string]interface{}{}
+		for i, name := range project.packageNames() {
+			packageName, version, _ := strings.Cut(name, "/")
+			packages = append(packages, map[string]interface{}{"id": i + 1, "name": packageName, "version": version, "package_type": "generic"})
+		}
+		writeJSON(w, http.StatusOK, packages)
+	default:
+		if len(segments) == 5 && segments[2] == "packages" && segments[4] == "package_files" && r.Method == http.MethodGet {
+			f.packageFiles(w, project, segments[3])
+			return
+		}
+		if len(segments) == 7 && segments[2] == "packages" && segments[3] == "generic" {
+			f.packageFile(w, r, project, segments[4]+"/"+segments[5], segments[6])
+			return
+		}
+		if r.Method == http.MethodGet && len(segments) == 6 && segments[2] == "registry" && segments[5] == "tags" {
+			tags := []map[string]string{}
+			for _, tag := range project.RegistryTags {
+				tags = append(tags, map[string]string{"name": tag})
+			}
+			writeJSON(w, http.StatusOK, tags)
+			return
+		}
+		if r.Method == http.MethodPut && len(segments) == 4 && segments[2] == "members" {
+			f.serveMembers(w, r, fmt.Sprintf("projects/%d", project.ID), segments)
+			return
+		}
+		if len(segments) >= 4 && segments[2] == "releases" {
+			f.serveReleases(w, r, project, segments)
+			return
+		}
+		if r.Method == http.MethodDelete && len(segments) == 4 && segments[2] == "protected_branches" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		f.t.Errorf("fake gitlab: unexpected request %s %s", r.Method, r.URL.Path)
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+	}
+}
+
+// listUsers ищет пользователей по username (точное совпадение) или search (подстрока username и email)
+func (f *fakeGitLab) listUsers(w http.ResponseWriter, r *http.Request) {
+	users := []*fakeUser{}
+	query := r.URL.Query()
+	if page := query.Get("page"); page == "" || page == "1" {
+		for _, user := range f.users {
+			switch {
+			case query.Has("username"):
+				if user.Username == query.Get("username") {
+					users = append(users, user)
+				}
+			case query.Has("search"):
+				search := strings.ToLower(query.Get("search"))
+				if strings.Contains(strings.ToLower(user.Username), search) || strings.Contains(strings.ToLower(user.Email), search) {
+					users = append(users, user)
+				}
+			default:
+				users = append(users, user)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+// serveMembers обрабатывает запросы к участникам группы или проекта key ("groups/<ID>", "projects/<ID>"):
+// список, добавление (409, если участник уже есть) и изменение
+func (f *fakeGitLab) serveMembers(w http.ResponseWriter, r *http.Request, key string, segments []string) {
+	members := f.members[key]
+	if members == nil {
+		members = make(map[int]*fakeMember)
+		f.members[key] = members
+	}
+	if r.Method == http.MethodGet {
+		list := []*fakeMember{}
+		if page := r.URL.Query().Get("page"); page == "" || page == "1" {
+			for _, member := range members {
+				list = append(list, member)
+			}
+			sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	var request struct {
+		UserID      int    `json:"user_id"`
+		AccessLevel int    `json:"access_level"`
+		ExpiresAt   string `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPut {
+		request.UserID, _ = strconv.Atoi(segments[3])
+		member := members[request.UserID]
+		if member == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Member Not Found"})
+			return
+		}
+		member.AccessLevel, member.ExpiresAt = request.AccessLevel, request.ExpiresAt
+		writeJSON(w, http.StatusOK, member)
+		return
+	}
+	if members[request.UserID] != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"message": "Member already exists"})
+		return
+	}
+	for _, user := range f.users {
+		if user.ID == request.UserID {
+			members[user.ID] = &fakeMember{ID: user.ID, Username: user.Username, State: "active", AccessLevel: request.AccessLevel, ExpiresAt: request.ExpiresAt}
+			writeJSON(w, http.StatusCreated, members[user.ID])
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 User Not Found"})
+}
+
+// serveReleases обрабатывает запросы к релизам проекта: список, создание (409, если релиз уже есть), изменение
+// и ссылки релиза (/releases/:tag/assets/links)
+func (f *fakeGitLab) serveReleases(w http.ResponseWriter, r *http.Request, project *fakeProject, segments []string) {
+	var release *fakeRelease
+	if len(segments) >= 4 {
+		for _, existing := range project.Releases {
+			if existing.TagName == segments[3] {
+				release = existing
+			}
+		}
+		if release == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Release Not Found"})
+			return
+		}
+	}
+	var request struct {
+		TagName     string   `json:"tag_name"`
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Milestones  []string `json:"milestones"`
+		Assets      struct {
+			Links []ReleaseLink `json:"links"`
+		} `json:"assets"`
+	}
+	var link ReleaseLink
+	if r.Method != http.MethodGet {
+		data, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(data, &request)
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &link)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, title := range request.Milestones {
+		found := false
+		for _, milestone := range project.Milestones {
+			found = found || milestone == title
+		}
+		if !found {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Milestone(s) not found: " + title})
+			return
+		}
+	}
+	switch {
+	case r.Method == http.MethodGet && len(segments) == 3:
+		releases := []map[string]interface{}{}
+		if page := r.URL.Query().Get("page"); page == "" || page == "1" {
+			for _, release := range project.Releases {
+				milestones := []map[string]string{}
+				for _, title := range release.Milestones {
+					milestones = append(milestones, map[string]string{"title": title})
+				}
+				releases = append(releases, map[string]interface{}{
+					"tag_name":    release.TagName,
+					"name":        release.Name,
+					"description": release.Description,
+					"milestones":  milestones,
+					"assets":      map[string]interface{}{"links": release.Links},
+				})
+			}
+		}
+		writeJSON(w, http.StatusOK, releases)
+	case r.Method == http.MethodPost && len(segments) == 3:
+		for _, existing := range project.Releases {
+			if existing.TagName == request.TagName {
+				writeJSON(w, http.StatusConflict, map[string]string{"message": "Release already exists"})
+				return
+			}
+		}
+		release := &fakeRelease{TagName: request.TagName, Name: request.Name, Description: request.Description, Milestones: request.Milestones, Links: request.Assets.Links}
+		project.Releases = append(project.Releases, release)
+		writeJSON(w, http.StatusCreated, map[string]string{"tag_name": release.TagName})
+	case r.Method == http.MethodPut && len(segments) == 4:
+		release.Name, release.Description, release.Milestones = request.Name, request.Description, request.Milestones
+		writeJSON(w, http.StatusOK, map[string]string{"tag_name": release.TagName})
+	case r.Method == http.MethodGet && len(segments) == 6 && segments[4] == "assets" && segments[5] == "links":
+		links := []ReleaseLink{}
+		if page := r.URL.Query().Get("page"); page == "" || page == "1" {
+			links = append(links, release.Links...)
+		}
+		writeJSON(w, http.StatusOK, links)
+	case r.Method == http.MethodPost && len(segments) == 6 && segments[4] == "assets" && segments[5] == "links":
+		for _, existing := range release.Links {
+			if existing.Name == link.Name || existing.URL == link.URL {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Link already exists"})
+				return
+			}
+		}
+		release.Links = append(release.Links, link)
+		writeJSON(w, http.StatusCreated, link)
+	default:
+		f.t.Errorf("fake gitlab: unexpected request %s %s", r.Method, r.URL.Path)
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+	}
+}
+
+// packageNames -- пакеты проекта "<имя>/<версия>" по порядку, ID пакета -- номер в этом списке с 1
+func (p *fakeProject) packageNames() []string {
+	var names []string
+	for name := range p.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// packageFiles отдает файлы пакета (GET /projects/:id/packages/:package_id/package_files)
+func (f *fakeGitLab) packageFiles(w http.ResponseWriter, project *fakeProject, packageID string) {
+	id, _ := strconv.Atoi(packageID)
+	names := project.packageNames()
+	if id < 1 || id > len(names) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Package Not Found"})
+		return
+	}
+	var fileNames []string
+	for fileName := range project.Packages[names[id-1]] {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+	files := []map[string]interface{}{}
+	for i, fileName := range fileNames {
+		content := project.Packages[names[id-1]][fileName]
+		file := map[string]interface{}{"id": i + 1, "file_name": fileName, "size": len(content)}
+		if !project.PackagesWithoutSHA {
+			sum := sha256.Sum256([]byte(content))
+			file["file_sha256"] = hex.EncodeToString(sum[:])
+		}
+		files = append(files, file)
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// packageFile скачивает (GET) и загружает (PUT) файл пакета generic
+// (/projects/:id/packages/generic/:name/:version/:file_name)
+func (f *fakeGitLab) packageFile(w http.ResponseWriter, r *http.Request, project *fakeProject, packageName, fileName string) {
+	switch r.Method {
+	case http.MethodGet:
+		content, ok := project.Packages[packageName][fileName]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+			return
+		}
+		io.WriteString(w, content)
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if project.Packages == nil {
+			project.Packages = make(map[string]map[string]string)
+		}
+		if project.Packages[packageName] == nil {
+			project.Packages[packageName] = make(map[string]string)
+		}
+		project.Packages[packageName][fileName] = string(data)
+		project.PackageUploads++
+		writeJSON(w, http.StatusCreated, map[string]string{"message": "201 Created"})
+	default:
+		f.t.Errorf("fake gitlab: unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// scheduleExport ставит экспорт проекта в очередь (POST /projects/:id/export)
+func (f *fakeGitLab) scheduleExport(w http.ResponseWriter, project *fakeProject) {
+	code := http.StatusAccepted
+	if n := len(project.exportRequests); n < len(project.ExportResponses) {
+		code = project.ExportResponses[n]
+	}
+	project.exportRequests = append(project.exportRequests, time.Now())
+	if code != http.StatusAccepted {
+		writeJSON(w, code, map[string]string{"message": http.StatusText(code)})
+		return
+	}
+	if !project.exporting {
+		project.exporting = true
+		f.activeExports++
+		f.maxActiveExports = max(f.maxActiveExports, f.activeExports)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"message": "202 Accepted"})
+}
+
+// exportStatus отдает очередной статус экспорта из ExportStatuses (GET /projects/:id/export)
+func (f *fakeGitLab) exportStatus(w http.ResponseWriter, project *fakeProject) {
+	status := "finished"
+	if len(project.ExportStatuses) != 0 {
+		status = project.ExportStatuses[min(project.exportChecks, len(project.ExportStatuses)-1)]
+	}
+	project.exportChecks++
+	if project.exporting && (status == "finished" || status == "failed") {
+		project.exporting = false
+		f.activeExports--
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"export_status": status})
+}
+
+// exportProject отдает архив экспорта: tar.gz с project.bundle, как в настоящем экспорте Gitlab
+func (f *fakeGitLab) exportProject(w http.ResponseWriter, project *fakeProject) {
+	bundlePath := filepath.Join(f.t.TempDir(), "project.bundle")
+	if output, err := exec.Command("git", "-C", f.repoDir(project), "bundle", "create", bundlePath, "--all").CombinedOutput(); err != nil {
+		http.Error(w, string(output), http.StatusInternalServerError)
+		return
+	}
+	data, err := os.ReadFile(bundlePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "project.bundle", Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+	tw.Write(data)
+	tw.Close()
+	gz.Close()
+}
+
+// importProject создает проект из архива экспорта (POST /projects/import)
+func (f *fakeGitLab) importProject(w http.ResponseWriter, r *http.Request) {
+	namespace := f.groupByRefLocked(r.URL.Query().Get("namespace"))
+	projectPath := r.URL.Query().Get("path")
+	if namespace == nil || projectPath == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "namespace not found"})
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bundlePath := filepath.Join(f.t.TempDir(), "project.bundle")
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			http.Error(w, "project.bundle not found in archive", http.StatusBadRequest)
+			return
+		}
+		if header.Name == "project.bundle" {
+			out, err := os.Create(bundlePath)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			io.Copy(out, tr)
+			out.Close()
+			break
+		}
+	}
+	project := f.projectByPathLocked(namespace.FullPath + "/" + projectPath)
+	if project == nil {
+		project = &fakeProject{ID: f.newID(), Name: projectPath, Path: projectPath, Namespace: namespace}
+		f.projects[project.ID] = project
+	}
+	repoDir := f.repoDir(project)
+	os.RemoveAll(repoDir)
+	if output, err := exec.Command("git", "clone", "--quiet", "--mirror", bundlePath, repoDir).CombinedOutput(); err != nil {
+		http.Error(w, string(output), http.StatusInternalServerError)
+		return
+	}
+	exec.Command("git", "-C", repoDir, "remote", "remove", "origin").Run()
+	exec.Command("git", "-C", repoDir, "config", "http.receivepack", "true").Run()
+	project.importFailed = f.importFailures > 0
+	if project.importFailed {
+		f.importFailures--
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": project.ID, "import_status": "scheduled"})
+}
+
+// importStatus отдает итог импорта архива (GET /projects/:id/import)
+func (f *fakeGitLab) importStatus(w http.ResponseWriter, project *fakeProject) {
+	if !project.importFailed {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"import_status": "finished", "failed_relations": []interface{}{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"import_status": "failed",
+		"import_error":  "Error importing repository into group/project - No space left on device",
+		"failed_relations": []map[string]interface{}{{
+			"relation_name":     "merge_requests",
+			"exception_class":   "ActiveRecord::RecordInvalid",
+			"exception_message": "Validation failed: Source branch can't be blank",
+			"source":            "process_relation_item!",
+			"line_number":       0,
+		}},
+	})
+}
+
+// serveGit отдает репозитории по smart HTTP. Пуш в несуществующий проект создает его в существующей группе
+func (f *fakeGitLab) serveGit(w http.ResponseWriter, r *http.Request) {
+	// Как Gitlab: git авторизуется логином oauth2 и токеном, без них -- 401 и запрос к credential helper'у
+	if username, password, ok := r.BasicAuth(); !ok || username != "oauth2" || password != f.token {
+		w.Header().Set("WWW-Authenticate", `Basic realm="GitLab"`)
+		http.Error(w, "HTTP Basic: Access denied", http.StatusUnauthorized)
+		return
+	}
+	match := gitRepoPath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		f.t.Errorf("fake gitlab: unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	fullPath := match[1]
+	receive := r.URL.Query().Get("service") == "git-receive-pack" || match[2] == "/git-receive-pack"
+	f.mu.Lock()
+	project := f.projectByPathLocked(fullPath)
+	if project == nil && receive {
+		namespace := f.groupByRefLocked(path.Dir(fullPath))
+		if namespace != nil {
+			project = &fakeProject{ID: f.newID(), Name: path.Base(fullPath), Path: path.Base(fullPath), Namespace: namespace}
+			f.projects[project.ID] = project
+			repoDir := f.repoDir(project)
+			exec.Command("git", "init", "--quiet", "--bare", "--initial-branch=main", repoDir).Run()
+			exec.Command("git", "-C", repoDir, "config", "http.receivepack", "true").Run()
+		}
+	}
+	f.mu.Unlock()
+	if project == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if match[2] == "/info/lfs/objects/batch" {
+		f.lfsBatch(w, r, project)
+		return
+	}
+	gitPath, _ := exec.LookPath("git")
+	handler := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + f.repoRoot, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	// Адрес в git http-backend должен совпадать с путем на диске (регистр мог отличаться)
+	r.URL.Path = "/" + project.fullPath() + ".git" + match[2]
+	handler.ServeHTTP(w, r)
+}
+
+// addLFSObjects кладет LFS объекты в хранилище проекта, как git lfs push
+func (f *fakeGitLab) addLFSObjects(fullPath string, oids ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	project := f.projectByPathLocked(fullPath)
+	if project == nil {
+		f.t.Errorf("fake gitlab: project %s does not exist", fullPath)
+		return
+	}
+	if project.LFSObjects == nil {
+		project.LFSObjects = make(map[string]bool)
+	}
+	for _, oid := range oids {
+		project.LFSObjects[oid] = true
+	}
+}
+
+// lfsBatch отвечает на запрос LFS batch API: объекты, которых нет в хранилище, нужно загрузить (upload)
+func (f *fakeGitLab) lfsBatch(w http.ResponseWriter, r *http.Request, project *fakeProject) {
+	if r.Method != http.MethodPost || r.Header.Get("Accept") != "application/vnd.git-lfs+json" {
+		http.Error(w, "unexpected LFS batch request", http.StatusNotAcceptable)
+		return
+	}
+	var request struct {
+		Operation string `json:"operation"`
+		Objects   []struct {
+			OID  string `json:"oid"`
+			Size int64  `json:"size"`
+		} `json:"objects"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Operation != "upload" {
+		http.Error(w, "bad LFS batch request", http.StatusUnprocessableEntity)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	objects := make([]map[string]interface{}, 0, len(request.Objects))
+	for _, object := range request.Objects {
+		entry := map[string]interface{}{"oid": object.OID, "size": object.Size}
+		if !project.LFSObjects[object.OID] {
+			entry["actions"] = map[string]interface{}{"upload": map[string]string{
+				"href": fmt.Sprintf("%s/%s.git/gitlab-lfs/objects/%s/%d", f.URL(), project.fullPath(), object.OID, object.Size),
+			}}
+		}
+		objects = append(objects, entry)
+	}
+	w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"transfer": "basic", "objects": objects})
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Реализации операций git
+const (
+	gitBackendExec  = "exec"   // Вызов git и git-lfs (по умолчанию)
+	gitBackendGoGit = "go-git" // Клонирование и пуш на Go. Проекты с LFS объектами не переносятся
+)
+
+// errLFSNotSupported -- LFS объекты можно перенести только через git-lfs
+var errLFSNotSupported = errors.New("LFS is not supported by go-git backend")
+
+// GitRef -- ссылка репозитория и SHA объекта, на который она указывает
+type GitRef struct {
+	Name string
+	SHA  string
+}
+
+// Результаты пуша ссылки
+const (
+	pushStatusOK           = "ok"
+	pushStatusRejected     = "rejected"      // Не fast-forward и прочие отказы git
+	pushStatusProtected    = "protected"     // Защищенная ветка или тег на Gitlab-destination
+	pushStatusHookDeclined = "hook_declined" // Отклонено pre-receive хуком (push rules и т.п.)
+	pushStatusTooLarge     = "too_large"     // Превышен размер пуша или файла
+	pushStatusError        = "error"         // Ошибка соединения или git, ссылка не дошла до сервера
+)
+
+// RefPushResult -- результат пуша одной ссылки
+type RefPushResult struct {
+	Ref    string `json:"ref"`
+	SHA    string `json:"sha,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// porcelainLine -- строка результата git push --porcelain: <флаг>\t<src>:<dst>\t<итог>
+var porcelainLine = regexp.MustCompile(`^(.)\t[^\t]*:([^\t]+)\t(.*)$`)
+
+// goGitCommandError -- отказ сервера по ссылке в ошибке go-git
+var goGitCommandError = regexp.MustCompile(`command error on (\S+): (.*)`)
+
+// parsePorcelain разбирает вывод git push --porcelain: ссылка на Gitlab-destination -> результат.
+// stderr нужен, чтобы уточнить причину отказа по сообщениям Gitlab (remote: ...)
+func parsePorcelain(stdout, stderr string) map[string]RefPushResult {
+	results := make(map[string]RefPushResult)
+	for _, line := range strings.Split(stdout, "\n") {
+		match := porcelainLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		result := RefPushResult{Ref: match[2], Status: pushStatusOK}
+		if match[1] == "!" {
+			result.Reason = match[3]
+			result.Status = classifyPushRejection(match[3], stderr)
+		}
+		results[result.Ref] = result
+	}
+	return results
+}
+
+// protectedRefMessages -- части сообщений Gitlab об отказе пушить в защищенную ветку или тег:
+//
+//	You are not allowed to push code to protected branches on this project.
+//	You are not allowed to force push code to a protected branch on this project.
+//	You are not allowed to delete protected branches from this project.
+//	You are not allowed to create this tag as it is protected.
+//	You are not allowed to change existing tags on this project.
+//	You are not allowed to delete protected tags from this project.
+var protectedRefMessages = []string{
+	"protected branch",
+	"tag as it is protected",
+	"change existing tags",
+	"protected tags",
+}
+
+// classifyPushRejection определяет причину отказа по итогу git push и сообщениям сервера
+func classifyPushRejection(summary, stderr string) string {
+	text := strings.ToLower(summary + "\n" + stderr)
+	for _, message := range protectedRefMessages {
+		if strings.Contains(text, message) {
+			return pushStatusProtected
+		}
+	}
+	switch {
+	case strings.Contains(text, "too large") || strings.Contains(text, "exceeds") || strings.Contains(text, "http 413"):
+		return pushStatusTooLarge
+	case strings.Contains(text, "hook declined"):
+		return pushStatusHookDeclined
+	case strings.Contains(summary, "rejected"):
+		return pushStatusRejected
+	}
+	return pushStatusError
+}
+
+// GitMirror -- операции git, которыми репозитории переносятся между Gitlab. Один экземпляр
+// работает с одним Gitlab: у каждого свой доступ (токен, ssh)
+type GitMirror interface {
+	// Clone создает зеркало репозитория (все ссылки) в repoDir
+	Clone(ctx context.Context, repoURL, repoDir string) error
+	// Fetch обновляет все ссылки зеркала из origin
+	Fetch(ctx context.Context, repoDir string) error
+	// ListRefs возвращает ссылки зеркала, имена которых начинаются с одного из prefixes, по алфавиту
+	ListRefs(ctx context.Context, repoDir string, prefixes ...string) ([]GitRef, error)
+	// FirstParentCommits возвращает SHA коммитов ветки ref по первым родителям, от старых к новым
+	FirstParentCommits(ctx context.Context, repoDir, ref string) ([]string, error)
+	// PushRefs пушит refspec'и (<src>:<dst>, с + -- принудительно) в repoURL. Возвращает результаты по
+	// ссылкам назначения, которые удалось установить, и ошибку, если хоть одна ссылка не запушена
+	PushRefs(ctx context.Context, repoDir, repoURL string, refspecs []string) (map[string]RefPushResult, error)
+	// DeleteRefs удаляет ссылки в repoURL
+	DeleteRefs(ctx context.Context, repoDir, repoURL string, refs []string) error
+	// LFSFetch скачивает все LFS объекты зеркала и возвращает вывод, по которому видно отсутствующие объекты
+	LFSFetch(ctx context.Context, repoDir string) (string, error)
+	// LFSPush загружает LFS объекты oids в repoURL и возвращает вывод git-lfs
+	LFSPush(ctx context.Context, repoDir, repoURL string, oids []string) (string, error)
+}
+
+// newGitMirrors создает операции git для Gitlab-source и Gitlab-destination по конфигурации.
+// С go-git git в системе нужен только для перезаписи истории и поиска секретов: они вызывают его напрямую
+func newGitMirrors(config Config, generalLogger *log.Logger) (GitMirror, GitMirror, error) {
+	switch config.GitBackend {
+	case "", gitBackendExec:
+		if _, err := exec.LookPath("git"); err != nil {
+			return nil, nil, fmt.Errorf("git is required with %s backend: %w", gitBackendExec, err)
+		}
+		return &execMirror{env: config.gitEnvSource, logger: generalLogger}, &execMirror{env: config.gitEnvDest, logger: generalLogger}, nil
+	case gitBackendGoGit:
+		if len(config.HistoryFilters) != 0 || config.IdentityMailmap != "" || config.IdentityHash || config.SecretScanPolicy != "" {
+			if _, err := exec.LookPath("git"); err != nil {
+				return nil, nil, fmt.Errorf("git is required for history filters, identities and secret scanning: %w", err)
+			}
+		}
+		source, err := newGoGitMirror(config, generalLogger, config.GitTransportSource, config.PrivateTokenSource, config.SSHSource)
+		if err != nil {
+			return nil, nil, fmt.Errorf("source: %w", err)
+		}
+		dest, err := newGoGitMirror(config, generalLogger, config.GitTransportDest, config.PrivateTokenDest, config.SSHDest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("destination: %w", err)
+		}
+		return source, dest, nil
+	}
+	return nil, nil, fmt.Errorf("unknown git backend %q", config.GitBackend)
+}
+
+// logGitOutput пишет вывод git в лог построчно
+func logGitOutput(logger *log.Logger, output string) {
+	for _, line := range strings.FieldsFunc(output, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		if line = strings.TrimSpace(line); line != "" {
+			logger.Printf("[GIT] %s\n", line)
+		}
+	}
+}
+
+// gitLogWriter передает прогресс go-git в лог
+type gitLogWriter struct {
+	logger *log.Logger
+}
+
+func (w gitLogWriter) Write(p []byte) (int, error) {
+	logGitOutput(w.logger, string(p))
+	return len(p), nil
+}
+
+// execMirror выполняет операции вызовом git и git-lfs с окружением env (см. gitEnv)
+type execMirror struct {
+	env    []string
+	logger *log.Logger
+}
+
+// run выполняет git в repoDir (пустой -- в текущей директории). Вывод пишется в лог и возвращается,
+// в ошибку попадают последние строки вывода
+func (m *execMirror) run(ctx context.Context, repoDir string, args ...string) (string, error) {
+	if repoDir != "" {
+		args = append([]string{"-C", repoDir}, args...)
+	}
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = m.env
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	logGitOutput(m.logger, output.String())
+	if err != nil {
+		return output.String(), fmt.Errorf("%w: %s", err, lastGitLines(output.String()))
+	}
+	return output.String(), nil
+}
+
+// lastGitLines возвращает последние строки вывода git для сообщения об ошибке
+func lastGitLines(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > 5 {
+		lines = lines[len(lines)-5:]
+	}
+	return strings.Join(lines, "; ")
+}
+
+func (m *execMirror) Clone(ctx context.Context, repoURL, repoDir string) error {
+	_, err := m.run(ctx, "", "clone", "--mirror", repoURL, repoDir)
+	return err
+}
+
+func (m *execMirror) Fetch(ctx context.Context, repoDir string) error {
+	_, err := m.run(ctx, repoDir, "fetch", "--prune", "origin")
+	return err
+}
+
+func (m *execMirror) ListRefs(ctx context.Context, repoDir string, prefixes ...string) ([]GitRef, error) {
+	args := append([]string{"for-each-ref", "--format=%(refname) %(objectname)"}, prefixes...)
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", repoDir}, args...)...)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	var refs []GitRef
+	for _, line := range strings.Split(string(output), "\n") {
+		if name, sha, found := strings.Cut(line, " "); found {
+			refs = append(refs, GitRef{Name: name, SHA: sha})
+		}
+	}
+	return refs, nil
+}
+
+func (m *execMirror) FirstParentCommits(ctx context.Context, repoDir, ref string) ([]string, error) {
+	output, err := exec.CommandContext(ctx, "git", "-C", repoDir, "rev-list", "--first-parent", "--reverse", ref, "--").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits of %s: %w", ref, err)
+	}
+	return strings.Fields(string(output)), nil
+}
+
+func (m *execMirror) PushRefs(ctx context.Context, repoDir, repoURL string, refspecs []string) (map[string]RefPushResult, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", repoDir, "push", "--porcelain", repoURL}, refspecs...)...)
+	cmd.Env = m.env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	logGitOutput(m.logger, stderr.String())
+	logGitOutput(m.logger, stdout.String())
+	if err != nil {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parsePorcelain(stdout.String(), stderr.String()), err
+}
+
+func (m *execMirror) DeleteRefs(ctx context.Context, repoDir, repoURL string, refs []string) error {
+	_, err := m.run(ctx, repoDir, append([]string{"push", "--delete", repoURL}, refs...)...)
+	return err
+}
+
+func (m *execMirror) LFSFetch(ctx context.Context, repoDir string) (string, error) {
+	return m.run(ctx, repoDir, "lfs", "fetch", "--all")
+}
+
+func (m *execMirror) LFSPush(ctx context.Context, repoDir, repoURL string, oids []string) (string, error) {
+	return m.run(ctx, repoDir, append([]string{"lfs", "push", "--object-id", repoURL}, oids...)...)
+}
+
+// goGitMirror выполняет операции через go-git, git в системе не нужен
+type goGitMirror struct {
+	auth     transport.AuthMethod // nil -- ssh-agent для ssh, без авторизации для https
+	insecure bool
+	caBundle []byte
+	logger   *log.Logger
+}
+
+// newGoGitMirror настраивает доступ go-git к одному Gitlab так же, как для git: токен для https,
+// ключ и known_hosts для ssh
+func newGoGitMirror(config Config, generalLogger *log.Logger, gitTransport, token string, ssh SSHConfig) (*goGitMirror, error) {
+	mirror := &goGitMirror{insecure: !config.TLSVerify, logger: generalLogger}
+	if config.TLSCAFile != "" {
+		caBundle, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		mirror.caBundle = caBundle
+	}
+	if gitTransport == gitTransportHTTPS {
+		mirror.auth = &githttp.BasicAuth{Username: "oauth2", Password: token}
+		return mirror, nil
+	}
+	if ssh.ProxyJump != "" {
+		return nil, errors.New("proxyJump is not supported by go-git backend")
+	}
+	var hostKeyCallback gossh.HostKeyCallback
+	if ssh.KnownHostsFile != "" {
+		var err error
+		hostKeyCallback, err = gitssh.NewKnownHostsCallback(ssh.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read known hosts: %w", err)
+		}
+	}
+	if ssh.IdentityFile == "" {
+		if hostKeyCallback == nil {
+			return mirror, nil
+		}
+		// Без ключа go-git берет ключи из ssh-agent, но ключ сервера все равно проверяется по knownHostsFile
+		agent, err := gitssh.NewSSHAgentAuth("git")
+		if err != nil {
+			return nil, fmt.Errorf("failed to use ssh-agent: %w", err)
+		}
+		agent.HostKeyCallback = hostKeyCallback
+		mirror.auth = agent
+		return mirror, nil
+	}
+	keys, err := gitssh.NewPublicKeysFromFile("git", ssh.IdentityFile, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ssh key: %w", err)
+	}
+	keys.HostKeyCallback = hostKeyCallback
+	mirror.auth = keys
+	return mirror, nil
+}
+
+// remote -- безымянный remote для пуша по адресу, которого нет в настройках зеркала
+func (m *goGitMirror) remote(repoDir, repoURL string) (*git.Remote, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	return git.NewRemote(repo.Storer, &gitconfig.RemoteConfig{Name: "anonymous", URLs: []string{repoURL}}), nil
+}
+
+// push пушит refspec'и, отсутствие изменений ошибкой не считается
+func (m *goGitMirror) push(ctx context.Context, repoDir, repoURL string, refspecs []string) error {
+	remote, err := m.remote(repoDir, repoURL)
+	if err != nil {
+		return err
+	}
+	options := &git.PushOptions{
+		RemoteName:      "anonymous",
+		Auth:            m.auth,
+		Progress:        gitLogWriter{m.logger},
+		InsecureSkipTLS: m.insecure,
+		CABundle:        m.caBundle,
+	}
+	for _, refspec := range refspecs {
+		options.RefSpecs = append(options.RefSpecs, gitconfig.RefSpec(refspec))
+	}
+	if err := remote.PushContext(ctx, options); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+	return nil
+}
+
+func (m *goGitMirror) Clone(ctx context.Context, repoURL, repoDir string) error {
+	_, err := git.PlainCloneContext(ctx, repoDir, true, &git.CloneOptions{
+		URL:             repoURL,
+		Auth:            m.auth,
+		Mirror:          true,
+		Progress:        gitLogWriter{m.logger},
+		InsecureSkipTLS: m.insecure,
+		CABundle:        m.caBundle,
+	})
+	return err
+}
+
+func (m *goGitMirror) Fetch(ctx context.Context, repoDir string) error {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return err
+	}
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs:        []gitconfig.RefSpec{"+refs/*:refs/*"},
+		Auth:            m.auth,
+		Progress:        gitLogWriter{m.logger},
+		Force:           true,
+		Prune:           true,
+		InsecureSkipTLS: m.insecure,
+		CABundle:        m.caBundle,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+	return nil
+}
+
+func (m *goGitMirror) ListRefs(ctx context.Context, repoDir string, prefixes ...string) ([]GitRef, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	iter, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	var refs []GitRef
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		name := ref.Name().String()
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				refs = append(refs, GitRef{Name: name, SHA: ref.Hash().String()})
+				break
+			}
+		}
+		if len(prefixes) == 0 {
+			refs = append(refs, GitRef{Name: name, SHA: ref.Hash().String()})
+		}
+		return nil
+	})
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, err
+}
+
+func (m *goGitMirror) FirstParentCommits(ctx context.Context, repoDir, ref string) ([]string, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits of %s: %w", ref, err)
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits of %s: %w", ref, err)
+	}
+	var commits []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		commit, err := repo.CommitObject(*hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list commits of %s: %w", ref, err)
+		}
+		commits = append(commits, commit.Hash.String())
+		if len(commit.ParentHashes) == 0 {
+			break
+		}
+		hash = &commit.ParentHashes[0]
+	}
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	return commits, nil
+}
+
+func (m *goGitMirror) PushRefs(ctx context.Context, repoDir, repoURL string, refspecs []string) (map[string]RefPushResult, error) {
+	err := m.push(ctx, repoDir, repoURL, refspecs)
+	results := make(map[string]RefPushResult)
+	switch {
+	case err == nil:
+		for _, refspec := range refspecs {
+			// Для refspec с * конкретные ссылки неизвестны
+			if spec := gitconfig.RefSpec(refspec); !spec.IsWildcard() {
+				dst := spec.Dst("").String()
+				results[dst] = RefPushResult{Ref: dst, Status: pushStatusOK}
+			}
+		}
+	default:
+		// go-git сообщает только первый отказ сервера, остальные ссылки неизвестны
+		if match := goGitCommandError.FindStringSubmatch(err.Error()); match != nil {
+			results[match[1]] = RefPushResult{Ref: match[1], Status: classifyPushRejection("[remote rejected] "+match[2], ""), Reason: match[2]}
+		}
+	}
+	return results, err
+}
+
+func (m *goGitMirror) DeleteRefs(ctx context.Context, repoDir, repoURL string, refs []string) error {
+	refspecs := make([]string, len(refs))
+	for i, ref := range refs {
+		refspecs[i] = ":" + ref
+	}
+	return m.push(ctx, repoDir, repoURL, refspecs)
+}
+
+func (m *goGitMirror) LFSFetch(ctx context.Context, repoDir string) (string, error) {
+	return "", errLFSNotSupported
+}
+
+func (m *goGitMirror) LFSPush(ctx context.Context, repoDir, repoURL string, oids []string) (string, error) {
+	return "", errLFSNotSupported
+}
+
+// goGitLFSObjects возвращает oid LFS объектов, на указатели которых ссылается репозиторий. git-lfs
+// для этого не нужен: указатели ищутся среди всех небольших blob'ов
+func goGitLFSObjects(repoDir string) ([]string, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := repo.BlobObjects()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var oids []string
+	err = blobs.ForEach(func(blob *object.Blob) error {
+		if blob.Size > lfsPointerMaxSize {
+			return nil
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return err
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+		if match := lfsPointer.FindSubmatch(content); match != nil && !seen[string(match[1])] {
+			seen[string(match[1])] = true
+			oids = append(oids, string(match[1]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list LFS objects: %w", err)
+	}
+	sort.Strings(oids)
+	return oids, nil
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// recordingMirror -- GitMirror для тестов: ссылки задаются заранее, пуши запоминаются
+type recordingMirror struct {
+	refs    []GitRef
+	commits map[string][]string // Ветка -> история по первым родителям, от старых к новым
+	pushes  [][]string
+	deletes [][]string
+	// failPush -- пуш с этой ссылкой не доходит до сервера (ошибка без результатов по ссылкам)
+	failPush string
+	// reject -- ссылка, которую сервер отклоняет; пачка с ней отклоняется целиком, как pre-receive хуком
+	reject string
+}
+
+func (m *recordingMirror) Clone(ctx context.Context, repoURL, repoDir string) error { return nil }
+
+func (m *recordingMirror) Fetch(ctx context.Context, repoDir string) error { return nil }
+
+func (m *recordingMirror) ListRefs(ctx context.Context, repoDir string, prefixes ...string) ([]GitRef, error) {
+	var refs []GitRef
+	for _, ref := range m.refs {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(ref.Name, prefix) {
+				refs = append(refs, ref)
+				break
+			}
+		}
+	}
+	return refs, nil
+}
+
+func (m *recordingMirror) FirstParentCommits(ctx context.Context, repoDir, ref string) ([]string, error) {
+	return m.commits[ref], nil
+}
+
+func (m *recordingMirror) PushRefs(ctx context.Context, repoDir, repoURL string, refspecs []string) (map[string]RefPushResult, error) {
+	m.pushes = append(m.pushes, refspecs)
+	rejected := false
+	for _, refspec := range refspecs {
+		if m.failPush != "" && strings.HasSuffix(refspec, ":"+m.failPush) {
+			return nil, errors.New("fatal: the remote end hung up unexpectedly")
+		}
+		rejected = rejected || strings.HasSuffix(refspec, ":"+m.reject)
+	}
+	if !rejected {
+		return nil, nil
+	}
+	results := make(map[string]RefPushResult)
+	for _, refspec := range refspecs {
+		_, dst, _ := strings.Cut(refspec, ":")
+		results[dst] = RefPushResult{Ref: dst, Status: pushStatusProtected, Reason: "[remote rejected] (pre-receive hook declined)"}
+	}
+	return results, errors.New("exit status 1")
+}
+
+func (m *recordingMirror) DeleteRefs(ctx context.Context, repoDir, repoURL string, refs []string) error {
+	m.deletes = append(m.deletes, refs)
+	return nil
+}
+
+func (m *recordingMirror) LFSFetch(ctx context.Context, repoDir string) (string, error) {
+	return "", nil
+}
+
+func (m *recordingMirror) LFSPush(ctx context.Context, repoDir, repoURL string, oids []string) (string, error) {
+	return "", nil
+}
+
+func TestPushRepoPushesBranchesBeforeTags(t *testing.T) {
+	mirror := &recordingMirror{refs: []GitRef{
+		{Name: "refs/tags/v1", SHA: "3"},
+		{Name: "refs/heads/main", SHA: "1"},
+		{Name: "refs/merge-requests/1/head", SHA: "2"},
+	}}
+	results, err := pushRepo(context.Background(), log.New(io.Discard, "", 0), mirror, "repo.git", "https://example.com/repo.git", nil, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPushes := [][]string{{"+refs/heads/main:refs/heads/main", "+refs/tags/v1:refs/tags/v1"}}
+	if !reflect.DeepEqual(mirror.pushes, wantPushes) {
+		t.Errorf("pushes = %q, want %q", mirror.pushes, wantPushes)
+	}
+	wantResults := []RefPushResult{
+		{Ref: "refs/heads/main", SHA: "1", Status: pushStatusOK},
+		{Ref: "refs/tags/v1", SHA: "3", Status: pushStatusOK},
+	}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("results = %+v, want %+v", results, wantResults)
+	}
+}
+
+func TestPushRepoRefNamespaces(t *testing.T) {
+	mirror := &recordingMirror{refs: []GitRef{
+		{Name: "refs/heads/main", SHA: "1"},
+		{Name: "refs/merge-requests/7/head", SHA: "2"},
+		{Name: "refs/merge-requests/7/merge", SHA: "3"},
+		{Name: "refs/notes/commits", SHA: "4"},
+		{Name: "refs/keep-around/5", SHA: "5"},
+		{Name: "refs/tags/v1", SHA: "6"},
+	}}
+	namespaces, err := compileRefNamespaces([]RefNamespace{
+		{Source: "refs/merge-requests/*/head", Dest: "refs/mr/*"},
+		{Source: "refs/notes/*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pushRepo(context.Background(), log.New(io.Discard, "", 0), mirror, "repo.git", "https://example.com/repo.git", namespaces, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{
+		"+refs/heads/main:refs/heads/main",
+		"+refs/tags/v1:refs/tags/v1",
+		"+refs/merge-requests/7/head:refs/mr/7",
+		"+refs/notes/commits:refs/notes/commits",
+	}}
+	if !reflect.DeepEqual(mirror.pushes, want) {
+		t.Errorf("pushes = %q, want %q", mirror.pushes, want)
+	}
+}
+
+func TestCompileRefNamespacesRejectsInvalid(t *testing.T) {
+	for _, namespace := range []RefNamespace{
+		{Source: "refs/merge-requests/*/head"},
+		{Source: "refs/keep-around/*", Dest: "refs/keep-around/*"},
+		{Source: "notes/*"},
+		{Source: "refs/notes/commits"},
+		{Source: "refs/*/*", Dest: "refs/mirror/*"},
+	} {
+		if _, err := compileRefNamespaces([]RefNamespace{namespace}); err == nil {
+			t.Errorf("compileRefNamespaces(%+v) succeeded, want error", namespace)
+		}
+	}
+}
+
+func TestPushHistoryChunks(t *testing.T) {
+	mirror := &recordingMirror{
+		commits: map[string][]string{
+			"refs/heads/main":    {"c1", "c2", "c3", "c4", "c5", "c6", "c7"},
+			"refs/heads/feature": {"c1", "c2", "c3", "c4", "c5", "f6"},
+			"refs/heads/topic":   {"c1", "c2", "c3", "c4", "c5", "c6", "c7", "t8", "t9", "t10", "t11"},
+		},
+	}
+	refs := []RefMapping{
+		{Source: "refs/heads/main", Dest: "refs/heads/main"},
+		{Source: "refs/heads/feature", Dest: "refs/heads/feature"},
+		{Source: "refs/heads/topic", Dest: "refs/heads/topic"},
+		{Source: "refs/tags/v1", Dest: "refs/tags/v1"},
+	}
+	discard := log.New(io.Discard, "", 0)
+	chunkRefs := pushHistoryChunks(context.Background(), discard, mirror, "repo.git", "https://example.com/repo.git", refs, 3, nil)
+	// Общая история веток пушится один раз во временные ссылки, последний коммит каждой ветки остается
+	// для pushRefsBatched
+	want := [][]string{
+		{"+c3:refs/tmp-chunks/heads/main"},
+		{"+c6:refs/tmp-chunks/heads/main"},
+		{"+t9:refs/tmp-chunks/heads/topic"},
+	}
+	if !reflect.DeepEqual(mirror.pushes, want) {
+		t.Errorf("pushes = %q, want %q", mirror.pushes, want)
+	}
+	if want := []string{"refs/tmp-chunks/heads/main", "refs/tmp-chunks/heads/topic"}; !reflect.DeepEqual(chunkRefs, want) {
+		t.Errorf("chunk refs = %q, want %q", chunkRefs, want)
+	}
+
+	// Повторный запуск: история уже на Gitlab-destination, частями пушится только новое
+	mirror.pushes = nil
+	pushed := map[string]string{"refs/heads/main": "c7", "refs/heads/feature": "f6", "refs/heads/topic": "t11", "refs/tags/v1": "c5"}
+	if chunkRefs := pushHistoryChunks(context.Background(), discard, mirror, "repo.git", "https://example.com/repo.git", refs, 3, pushed); len(mirror.pushes) != 0 || len(chunkRefs) != 0 {
+		t.Errorf("repeated run pushes = %q, chunk refs %q; want none", mirror.pushes, chunkRefs)
+	}
+	pushed = map[string]string{"refs/heads/main": "c4"}
+	pushHistoryChunks(context.Background(), discard, mirror, "repo.git", "https://example.com/repo.git", refs, 3, pushed)
+	if want := [][]string{{"+c7:refs/tmp-chunks/heads/topic"}, {"+t10:refs/tmp-chunks/heads/topic"}}; !reflect.DeepEqual(mirror.pushes, want) {
+		t.Errorf("pushes after previous run = %q, want %q", mirror.pushes, want)
+	}
+
+	// После неудачной части ветка дальше по частям не пушится
+	mirror.pushes, mirror.failPush = nil, "refs/tmp-chunks/heads/main"
+	pushHistoryChunks(context.Background(), discard, mirror, "repo.git", "https://example.com/repo.git", refs[:1], 3, nil)
+	if want := [][]string{{"+c3:refs/tmp-chunks/heads/main"}}; !reflect.DeepEqual(mirror.pushes, want) {
+		t.Errorf("pushes after failure = %q, want %q", mirror.pushes, want)
+	}
+}
+
+// TestPushRepoChunksKeepBranches проверяет, что при пуше частями ветки пушатся только один раз, на свои
+// коммиты, а временные ссылки удаляются после них
+func TestPushRepoChunksKeepBranches(t *testing.T) {
+	mirror := &recordingMirror{
+		refs:    []GitRef{{Name: "refs/heads/main", SHA: "c7"}},
+		commits: map[string][]string{"refs/heads/main": {"c1", "c2", "c3", "c4", "c5", "c6", "c7"}},
+	}
+	if _, err := pushRepo(context.Background(), log.New(io.Discard, "", 0), mirror, "repo.git", "https://example.com/repo.git", nil, 0, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, push := range mirror.pushes {
+		for _, refspec := range push {
+			if strings.HasSuffix(refspec, ":refs/heads/main") && refspec != "+refs/heads/main:refs/heads/main" {
+				t.Errorf("branch was moved to an intermediate commit: %s", refspec)
+			}
+		}
+	}
+	if want := [][]string{{"refs/tmp-chunks/heads/main"}}; !reflect.DeepEqual(mirror.deletes, want) {
+		t.Errorf("deleted refs = %q, want %q", mirror.deletes, want)
+	}
+}
+
+func TestPushRefsBatched(t *testing.T) {
+	var refs []RefMapping
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		refs = append(refs, RefMapping{Source: "refs/heads/" + name, Dest: "refs/heads/" + name, SHA: name})
+	}
+	spec := func(name string) string { return "+refs/heads/" + name + ":refs/heads/" + name }
+	tests := []struct {
+		name       string
+		mirror     *recordingMirror
+		wantPushes [][]string
+		// Статусы всех ссылок по порядку
+		wantStatuses []string
+	}{
+		{
+			name:         "all accepted",
+			mirror:       &recordingMirror{},
+			wantPushes:   [][]string{{spec("a"), spec("b")}, {spec("c"), spec("d")}, {spec("e")}},
+			wantStatuses: []string{pushStatusOK, pushStatusOK, pushStatusOK, pushStatusOK, pushStatusOK},
+		},
+		{
+			// Пачка, которая не дошла до сервера, пушится по одной ссылке, остальные пачки пушатся как обычно
+			name:         "batch failed",
+			mirror:       &recordingMirror{failPush: "refs/heads/d"},
+			wantPushes:   [][]string{{spec("a"), spec("b")}, {spec("c"), spec("d")}, {spec("c")}, {spec("d")}, {spec("e")}},
+			wantStatuses: []string{pushStatusOK, pushStatusOK, pushStatusOK, pushStatusError, pushStatusOK},
+		},
+		{
+			// Хук отклоняет пачку целиком, по одной ссылке видно, какая из них отклонена на самом деле
+			name:         "batch rejected by hook",
+			mirror:       &recordingMirror{reject: "refs/heads/c"},
+			wantPushes:   [][]string{{spec("a"), spec("b")}, {spec("c"), spec("d")}, {spec("c")}, {spec("d")}, {spec("e")}},
+			wantStatuses: []string{pushStatusOK, pushStatusOK, pushStatusProtected, pushStatusOK, pushStatusOK},
+		},
+		{
+			name:         "single ref rejected",
+			mirror:       &recordingMirror{reject: "refs/heads/e"},
+			wantPushes:   [][]string{{spec("a"), spec("b")}, {spec("c"), spec("d")}, {spec("e")}},
+			wantStatuses: []string{pushStatusOK, pushStatusOK, pushStatusOK, pushStatusOK, pushStatusProtected},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := pushRefsBatched(context.Background(), log.New(io.Discard, "", 0), tt.mirror, "repo.git", "https://example.com/repo.git", refs, 2)
+			if !reflect.DeepEqual(tt.mirror.pushes, tt.wantPushes) {
+				t.Errorf("pushes = %q, want %q", tt.mirror.pushes, tt.wantPushes)
+			}
+			var statuses []string
+			for i, result := range results {
+				statuses = append(statuses, result.Status)
+				if result.Ref != refs[i].Dest || result.SHA != refs[i].SHA {
+					t.Errorf("result %d = %+v, want ref %s", i, result, refs[i].Dest)
+				}
+			}
+			if !reflect.DeepEqual(statuses, tt.wantStatuses) {
+				t.Errorf("statuses = %q, want %q", statuses, tt.wantStatuses)
+			}
+		})
+	}
+}
+
+func TestParsePorcelain(t *testing.T) {
+	stdout := "To https://gitlab.example.com/group/app.git\n" +
+		"=\trefs/heads/main:refs/heads/main\t[up to date]\n" +
+		"*\trefs/tags/v1:refs/tags/v1\t[new tag]\n" +
+		"+\trefs/heads/dev:refs/heads/dev\t1111111...2222222 (forced update)\n" +
+		"!\trefs/heads/stable:refs/heads/stable\t[remote rejected] (pre-receive hook declined)\n" +
+		"!\trefs/heads/old:refs/heads/old\t[rejected] (non-fast-forward)\n" +
+		"Done\n"
+	got := parsePorcelain(stdout, "")
+	want := map[string]RefPushResult{
+		"refs/heads/main":   {Ref: "refs/heads/main", Status: pushStatusOK},
+		"refs/tags/v1":      {Ref: "refs/tags/v1", Status: pushStatusOK},
+		"refs/heads/dev":    {Ref: "refs/heads/dev", Status: pushStatusOK},
+		"refs/heads/stable": {Ref: "refs/heads/stable", Status: pushStatusHookDeclined, Reason: "[remote rejected] (pre-receive hook declined)"},
+		"refs/heads/old":    {Ref: "refs/heads/old", Status: pushStatusRejected, Reason: "[rejected] (non-fast-forward)"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePorcelain = %+v, want %+v", got, want)
+	}
+}
+
+func TestClassifyPushRejection(t *testing.T) {
+	tests := []struct {
+		summary, stderr, want string
+	}{
+		{"[remote rejected] (pre-receive hook declined)", "remote: GitLab: You are not allowed to force push code to a protected branch on this project.", pushStatusProtected},
+		{"[remote rejected] (pre-receive hook declined)", "remote: GitLab: You are not allowed to push code to protected branches on this project.", pushStatusProtected},
+		{"[remote rejected] (pre-receive hook declined)", "remote: GitLab: You are not allowed to create this tag as it is protected.", pushStatusProtected},
+		{"[remote rejected] (pre-receive hook declined)", "remote: GitLab: You are not allowed to change existing tags on this project.", pushStatusProtected},
+		{"[remote rejected] (pre-receive hook declined)", "remote: GitLab: You are not allowed to delete protected tags from this project.", pushStatusProtected},
+		{"[remote rejected] (pre-receive hook declined)", "remote: GitLab: Commit message does not follow the pattern", pushStatusHookDeclined},
+		{"[remote rejected] (unpacker error)", "remote: fatal: pack exceeds maximum allowed size", pushStatusTooLarge},
+		{"", "error: RPC failed; HTTP 413 curl 22 The requested URL returned error: 413", pushStatusTooLarge},
+		{"[rejected] (fetch first)", "", pushStatusRejected},
+		{"", "fatal: unable to access: Could not resolve host", pushStatusError},
+	}
+	for _, tt := range tests {
+		if got := classifyPushRejection(tt.summary, tt.stderr); got != tt.want {
+			t.Errorf("classifyPushRejection(%q, %q) = %q, want %q", tt.summary, tt.stderr, got, tt.want)
+		}
+	}
+}
+
+// TestGitMirrorBackends проверяет, что обе реализации одинаково клонируют, пушат и удаляют ссылки
+func TestGitMirrorBackends(t *testing.T) {
+	source := newFakeGitLab(t, "token")
+	group := source.addGroup("group", "group", nil)
+	source.addProject(group, "app", "app", []map[string]string{{"a.txt": "a\n"}, {"b.txt": "b\n"}}, []string{"feature"}, []string{"v1"})
+	sourceURL := source.URL() + "/group/app.git"
+	for _, backend := range []string{gitBackendExec, gitBackendGoGit} {
+		t.Run(backend, func(t *testing.T) {
+			var output bytes.Buffer
+			config := Config{
+				GitBackend:         backend,
+				GitlabURLSource:    source.URL(),
+				PrivateTokenSource: source.token,
+				GitlabURLDest:      source.URL(),
+				PrivateTokenDest:   source.token,
+				GitTransportSource: gitTransportHTTPS,
+				GitTransportDest:   gitTransportHTTPS,
+			}
+			setUpTestGitTransport(t, config)
+			mirror, _, err := newGitMirrors(config, log.New(&output, "", 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			mirrorDir := filepath.Join(t.TempDir(), "app.git")
+			if err := mirror.Clone(ctx, sourceURL, mirrorDir); err != nil {
+				t.Fatalf("Clone: %v", err)
+			}
+			if err := mirror.Fetch(ctx, mirrorDir); err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			refs, err := mirror.ListRefs(ctx, mirrorDir, "refs/heads/", "refs/tags/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := source.refs("group/app")
+			got := make(map[string]string)
+			for _, ref := range refs {
+				got[ref.Name] = ref.SHA
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ListRefs = %v, want %v", got, want)
+			}
+
+			destGroup := source.addGroup("dest-"+backend, "dest-"+backend, nil)
+			destURL := source.URL() + "/" + destGroup.FullPath + "/app.git"
+			if _, err := mirror.PushRefs(ctx, mirrorDir, destURL, []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}); err != nil {
+				t.Fatalf("PushRefs: %v", err)
+			}
+			if got := source.refs(destGroup.FullPath + "/app"); !reflect.DeepEqual(got, want) {
+				t.Errorf("pushed refs = %v, want %v", got, want)
+			}
+			if err := mirror.DeleteRefs(ctx, mirrorDir, destURL, []string{"refs/heads/feature"}); err != nil {
+				t.Fatalf("DeleteRefs: %v", err)
+			}
+			if _, ok := source.refs(destGroup.FullPath + "/app")["refs/heads/feature"]; ok {
+				t.Error("refs/heads/feature was not deleted")
+			}
+
+			if backend == gitBackendGoGit {
+				if _, err := mirror.LFSFetch(ctx, mirrorDir); err != errLFSNotSupported {
+					t.Errorf("LFSFetch error = %v, want %v", err, errLFSNotSupported)
+				}
+			}
+			if backend == gitBackendExec && !strings.Contains(output.String(), "[GIT] ") {
+				t.Errorf("git output was not logged: %q", output.String())
+			}
+		})
+	}
+}
+
+// TestGoGitMirrorKnownHosts проверяет, что knownHostsFile действует и без identityFile, когда ключи
+// берутся из ssh-agent
+func TestGoGitMirrorKnownHosts(t *testing.T) {
+	hostKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownKey, err := gossh.NewPublicKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownKey, err := gossh.NewPublicKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	knownHosts := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(knownHosts, []byte(knownhosts.Line([]string{"gitlab.example.com"}, knownKey)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{GitBackend: gitBackendGoGit}
+	ssh := SSHConfig{KnownHostsFile: knownHosts}
+
+	// Без ssh-agent и без ключа проверять ключ сервера нечем -- это ошибка, а не отключенная проверка
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, err := newGoGitMirror(config, log.New(io.Discard, "", 0), gitTransportSSH, "", ssh); err == nil {
+		t.Error("newGoGitMirror without ssh-agent succeeded, want error")
+	}
+
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(agent.NewKeyring(), conn)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	mirror, err := newGoGitMirror(config, log.New(io.Discard, "", 0), gitTransportSSH, "", ssh)
+	if err != nil {
+		t.Fatalf("newGoGitMirror: %v", err)
+	}
+	auth, ok := mirror.auth.(*gitssh.PublicKeysCallback)
+	if !ok || auth.HostKeyCallback == nil {
+		t.Fatalf("auth = %#v, want ssh-agent with known hosts callback", mirror.auth)
+	}
+	address := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	if err := auth.HostKeyCallback("gitlab.example.com:22", address, knownKey); err != nil {
+		t.Errorf("known host key rejected: %v", err)
+	}
+	if err := auth.HostKeyCallback("gitlab.example.com:22", address, unknownKey); err == nil {
+		t.Error("unknown host key accepted")
+	}
+}
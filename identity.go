@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// identityDefaultDomain -- домен почты для обезличенных авторов, если не задан в конфигурации
+const identityDefaultDomain = "users.noreply.invalid"
+
+// identityLine -- строка author/committer/tagger в потоке fast-export: <тип> <имя> <<почта>> <время>
+var identityLine = regexp.MustCompile(`^(author|committer|tagger) (.*?) ?<([^>]*)> (.*\n)$`)
+
+// mailmapEntry -- правило из файла в формате .mailmap
+type mailmapEntry struct {
+	properName  string
+	properEmail string
+	commitName  string
+	commitEmail string
+}
+
+// identityMapper переписывает авторов коммитов по mailmap или обезличивает их хешем.
+// Результаты запоминаются в состоянии синхронизации, чтобы SHA коммитов не менялись между запусками,
+// даже если mailmap или настройки хеширования поменяются
+type identityMapper struct {
+	mailmap []mailmapEntry
+	hash    bool
+	salt    string
+	domain  string
+	state   *SyncState
+}
+
+// newIdentityMapper создает обработчик авторов по конфигурации. Если переписывать авторов не нужно -- nil
+func newIdentityMapper(config Config) (*identityMapper, error) {
+	if config.IdentityMailmap == "" && !config.IdentityHash {
+		return nil, nil
+	}
+	mapper := &identityMapper{
+		hash:   config.IdentityHash,
+		salt:   config.IdentityHashSalt,
+		domain: config.IdentityHashDomain,
+		state:  config.state,
+	}
+	if mapper.domain == "" {
+		mapper.domain = identityDefaultDomain
+	}
+	if config.IdentityMailmap != "" {
+		mailmap, err := loadMailmap(config.IdentityMailmap)
+		if err != nil {
+			return nil, err
+		}
+		mapper.mailmap = mailmap
+	}
+	return mapper, nil
+}
+
+// loadMailmap читает файл в формате .mailmap:
+//
+//	Proper Name <proper@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func loadMailmap(path string) ([]mailmapEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mailmap: %w", err)
+	}
+	defer file.Close()
+	var entries []mailmapEntry
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		// Разобьем строку на пары "имя <почта>"
+		var names, emails []string
+		for rest := line; strings.Contains(rest, "<"); {
+			open := strings.Index(rest, "<")
+			closing := strings.Index(rest, ">")
+			if closing < open {
+				return nil, fmt.Errorf("invalid mailmap line %d: %s", lineNumber, line)
+			}
+			names = append(names, strings.TrimSpace(rest[:open]))
+			emails = append(emails, rest[open+1:closing])
+			rest = rest[closing+1:]
+		}
+		switch len(emails) {
+		case 1:
+			// Proper Name <commit@email> -- меняется только имя
+			entries = append(entries, mailmapEntry{properName: names[0], commitEmail: emails[0]})
+		case 2:
+			entries = append(entries, mailmapEntry{properName: names[0], properEmail: emails[0], commitName: names[1], commitEmail: emails[1]})
+		default:
+			return nil, fmt.Errorf("invalid mailmap line %d: %s", lineNumber, line)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// lookupMailmap ищет правило: сначала по имени и почте, затем только по почте
+func (m *identityMapper) lookupMailmap(name, email string) (string, string, bool) {
+	var found *mailmapEntry
+	for i := range m.mailmap {
+		entry := &m.mailmap[i]
+		if !strings.EqualFold(entry.commitEmail, email) {
+			continue
+		}
+		if entry.commitName == name {
+			found = entry
+			break
+		}
+		if entry.commitName == "" && found == nil {
+			found = entry
+		}
+	}
+	if found == nil {
+		return "", "", false
+	}
+	if found.properName != "" {
+		name = found.properName
+	}
+	if found.properEmail != "" {
+		email = found.properEmail
+	}
+	return name, email, true
+}
+
+// hashIdentity обезличивает автора: одна и та же почта всегда дает одно и то же имя и почту
+func (m *identityMapper) hashIdentity(email string) (string, string) {
+	mac := hmac.New(sha256.New, []byte(m.salt))
+	mac.Write([]byte(strings.ToLower(email)))
+	id := hex.EncodeToString(mac.Sum(nil))[:12]
+	return "user-" + id, id + "@" + m.domain
+}
+
+// rewrite возвращает нового автора. Авторы без правила в mailmap обезличиваются, если включено
+// хеширование, иначе остаются как есть
+func (m *identityMapper) rewrite(name, email string) (string, string) {
+	key := fmt.Sprintf("%s <%s>", name, email)
+	if cached, ok := m.state.identity(key); ok {
+		if open := strings.LastIndex(cached, " <"); open >= 0 && strings.HasSuffix(cached, ">") {
+			return cached[:open], cached[open+2 : len(cached)-1]
+		}
+	}
+	newName, newEmail, ok := m.lookupMailmap(name, email)
+	if !ok {
+		if !m.hash {
+			return name, email
+		}
+		newName, newEmail = m.hashIdentity(email)
+	}
+	m.state.rememberIdentity(key, fmt.Sprintf("%s <%s>", newName, newEmail))
+	return newName, newEmail
+}
+
+// rewriteLine переписывает строку author/committer/tagger потока fast-export
+func (m *identityMapper) rewriteLine(line string) string {
+	match := identityLine.FindStringSubmatch(line)
+	if match == nil {
+		return line
+	}
+	name, email := m.rewrite(match[2], match[3])
+	if name == "" {
+		return fmt.Sprintf("%s <%s> %s", match[1], email, match[4])
+	}
+	return fmt.Sprintf("%s %s <%s> %s", match[1], name, email, match[4])
+}
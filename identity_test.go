@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeMailmap записывает mailmap во временный файл и возвращает его путь
+func writeMailmap(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mailmap")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestIdentityMapper создает обработчик авторов с пустым состоянием во временной директории
+func newTestIdentityMapper(t *testing.T, config Config) *identityMapper {
+	t.Helper()
+	state, err := loadSyncState(filepath.Join(t.TempDir(), stateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.state = state
+	mapper, err := newIdentityMapper(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mapper
+}
+
+func TestLoadMailmap(t *testing.T) {
+	path := writeMailmap(t, `# Комментарий
+Proper Name <commit@example.com>
+<proper@example.com> <old@example.com>
+Jane Doe <jane@example.com> <jd@example.com> # после правила
+Jane Doe <jane@example.com> J. Doe <jd@example.com>
+
+`)
+
+	entries, err := loadMailmap(path)
+	if err != nil {
+		t.Fatalf("loadMailmap: %v", err)
+	}
+	want := []mailmapEntry{
+		{properName: "Proper Name", commitEmail: "commit@example.com"},
+		{properEmail: "proper@example.com", commitEmail: "old@example.com"},
+		{properName: "Jane Doe", properEmail: "jane@example.com", commitEmail: "jd@example.com"},
+		{properName: "Jane Doe", properEmail: "jane@example.com", commitName: "J. Doe", commitEmail: "jd@example.com"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %+v, want %+v", entries, want)
+	}
+
+	for _, invalid := range []string{"Name without email\n", "A <a@x> B <b@x> C <c@x>\n", "Broken >a@x<\n"} {
+		if _, err := loadMailmap(writeMailmap(t, invalid)); err == nil {
+			t.Errorf("loadMailmap(%q) succeeded, want error", invalid)
+		}
+	}
+	if _, err := loadMailmap(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadMailmap of missing file succeeded, want error")
+	}
+}
+
+func TestIdentityRewriteLine(t *testing.T) {
+	mailmap := writeMailmap(t, "Jane Doe <jane@example.com> <jd@example.com>\nJane Doe <jane@example.com> Bot <jd@example.com>\n<new@example.com> <old@example.com>\n")
+	tests := []struct {
+		name   string
+		config Config
+		line   string
+		want   string
+	}{
+		{
+			name:   "mailmap by email",
+			config: Config{IdentityMailmap: mailmap},
+			line:   "author J D <JD@example.com> 1700000000 +0300\n",
+			want:   "author Jane Doe <jane@example.com> 1700000000 +0300\n",
+		},
+		{
+			name:   "mailmap by name and email",
+			config: Config{IdentityMailmap: mailmap},
+			line:   "committer Bot <jd@example.com> 1700000000 +0000\n",
+			want:   "committer Jane Doe <jane@example.com> 1700000000 +0000\n",
+		},
+		{
+			name:   "mailmap email only",
+			config: Config{IdentityMailmap: mailmap},
+			line:   "tagger Old Name <old@example.com> 1700000000 +0000\n",
+			want:   "tagger Old Name <new@example.com> 1700000000 +0000\n",
+		},
+		{
+			name:   "not in mailmap",
+			config: Config{IdentityMailmap: mailmap},
+			line:   "author Someone <someone@example.com> 1700000000 +0000\n",
+			want:   "author Someone <someone@example.com> 1700000000 +0000\n",
+		},
+		{
+			name:   "hashed",
+			config: Config{IdentityHash: true, IdentityHashSalt: "salt", IdentityHashDomain: "example.invalid"},
+			line:   "author Someone <someone@example.com> 1700000000 +0000\n",
+			want:   "author user-26e870d16fd2 <26e870d16fd2@example.invalid> 1700000000 +0000\n",
+		},
+		{
+			name:   "mailmap before hash",
+			config: Config{IdentityMailmap: mailmap, IdentityHash: true},
+			line:   "author J D <jd@example.com> 1700000000 +0000\n",
+			want:   "author Jane Doe <jane@example.com> 1700000000 +0000\n",
+		},
+		{
+			name:   "not an identity",
+			config: Config{IdentityHash: true},
+			line:   "author without email\n",
+			want:   "author without email\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestIdentityMapper(t, tt.config)
+			if got := mapper.rewriteLine(tt.line); got != tt.want {
+				t.Errorf("rewriteLine(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIdentityHashStable проверяет, что обезличенный автор одинаков между запусками: без состояния --
+// за счет HMAC, с состоянием -- даже если соль поменялась
+func TestIdentityHashStable(t *testing.T) {
+	config := Config{IdentityHash: true, IdentityHashSalt: "salt"}
+	line := "author Someone <Someone@Example.com> 1700000000 +0000\n"
+	first := newTestIdentityMapper(t, config).rewriteLine(line)
+	second := newTestIdentityMapper(t, config).rewriteLine(line)
+	if first != second {
+		t.Errorf("rewriteLine in second run = %q, want %q", second, first)
+	}
+	if !strings.Contains(first, "@"+identityDefaultDomain+">") {
+		t.Errorf("rewriteLine = %q, want email in default domain %s", first, identityDefaultDomain)
+	}
+	// Регистр почты на хеш не влияет
+	if lower := newTestIdentityMapper(t, config).rewriteLine("author Someone <someone@example.com> 1700000000 +0000\n"); lower != first {
+		t.Errorf("rewriteLine with lowercase email = %q, want %q", lower, first)
+	}
+
+	// Сохраненная перезапись используется, даже если соль поменялась
+	mapper := newTestIdentityMapper(t, config)
+	mapper.rewriteLine(line)
+	if err := mapper.state.persist(); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadSyncState(mapper.state.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := newIdentityMapper(Config{IdentityHash: true, IdentityHashSalt: "other", state: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.rewriteLine(line); got != first {
+		t.Errorf("rewriteLine after salt change = %q, want remembered %q", got, first)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Параметры этапа LFS
+const (
+	lfsDefaultRetries = 3
+	lfsRetryPeriod    = 5 * time.Second
+	lfsPushChunkSize  = 100  // Сколько объектов передаем в одном вызове git lfs push --object-id
+	lfsPointerMaxSize = 1024 // Указатель LFS не бывает больше
+	lfsMediaType      = "application/vnd.git-lfs+json"
+)
+
+// lfsPointer -- содержимое файла-указателя на LFS объект
+var lfsPointer = regexp.MustCompile(`^version https://git-lfs\.github\.com/spec/v1\noid sha256:([0-9a-f]{64})\nsize \d+\n`)
+
+// lfsMissingObject находит в выводе git lfs fetch объекты, которых нет на сервере (404)
+var lfsMissingObject = regexp.MustCompile(`\[([0-9a-f]{64})\][^\n]*(?:\[404\]|does not exist)`)
+
+// LFSStats -- статистика LFS по проекту для отчета
+type LFSStats struct {
+	Objects         int      `json:"objects"`
+	Fetched         int      `json:"fetched"`
+	MissingOnSource []string `json:"missing_on_source,omitempty"` // Объекты, которых нет на Gitlab-source (404)
+	TransportErrors []string `json:"transport_errors,omitempty"`  // Объекты, которые не удалось скачать по другим причинам
+	Pushed          int      `json:"pushed"`                      // Объекты, действительно загруженные на Gitlab-destination
+	Error           string   `json:"error,omitempty"`
+}
+
+// listLFSObjects возвращает oid всех LFS объектов, на которые ссылаются ветки и теги репозитория
+func listLFSObjects(repoDir string) ([]string, error) {
+	output, err := exec.Command("git", "-C", repoDir, "lfs", "ls-files", "--all", "--long").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list LFS objects: %w", err)
+	}
+	seen := make(map[string]bool)
+	var oids []string
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		oids = append(oids, fields[0])
+	}
+	return oids, nil
+}
+
+// lfsObjectPath -- путь к LFS объекту в репозитории, созданном git clone --mirror
+func lfsObjectPath(repoDir, oid string) string {
+	return filepath.Join(repoDir, "lfs", "objects", oid[0:2], oid[2:4], oid)
+}
+
+// lfsObjectExists проверяет, скачан ли LFS объект в репозиторий
+func lfsObjectExists(repoDir, oid string) bool {
+	_, err := os.Stat(lfsObjectPath(repoDir, oid))
+	return err == nil
+}
+
+// checkLFSSupported отказывает в переносе проекта с LFS объектами, если их нечем перенести (go-git)
+func checkLFSSupported(config Config, repoDir string) error {
+	if config.GitBackend != gitBackendGoGit {
+		return nil
+	}
+	oids, err := goGitLFSObjects(repoDir)
+	if err != nil {
+		return err
+	}
+	if len(oids) != 0 {
+		return fmt.Errorf("repository has %d LFS objects: %w", len(oids), errLFSNotSupported)
+	}
+	return nil
+}
+
+// fetchLFS скачивает все LFS объекты репозитория с повторами. Объекты, которые так и не удалось
+// скачать, делятся на отсутствующие на Gitlab-source и на ошибки передачи
+func fetchLFS(ctx context.Context, config Config, generalLogger, corruptedLogger *log.Logger, repoDir, repoURL string) *LFSStats {
+	stats := &LFSStats{}
+	// С go-git проекты с LFS объектами отклоняются раньше (checkLFSSupported)
+	if config.GitBackend == gitBackendGoGit {
+		return stats
+	}
+	oids, err := listLFSObjects(repoDir)
+	if err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+	stats.Objects = len(oids)
+	if len(oids) == 0 {
+		return stats
+	}
+	retries := config.LFSRetries
+	if retries <= 0 {
+		retries = lfsDefaultRetries
+	}
+	missingOnSource := make(map[string]bool)
+	for try := 1; try <= retries; try++ {
+		fetchCtx, cancel := context.WithTimeout(ctx, operationTimeouts.Clone)
+		output, err := config.gitSource.LFSFetch(fetchCtx, repoDir)
+		cancel()
+		for _, match := range lfsMissingObject.FindAllStringSubmatch(output, -1) {
+			missingOnSource[match[1]] = true
+		}
+		if err == nil {
+			break
+		}
+		// Повторять имеет смысл, только если не скачались объекты, которые на сервере есть
+		pending := 0
+		for _, oid := range oids {
+			if !missingOnSource[oid] && !lfsObjectExists(repoDir, oid) {
+				pending++
+			}
+		}
+		if pending == 0 || ctx.Err() != nil {
+			break
+		}
+		fmt.Printf("[WARNING] Failed to fetch %d LFS objects (try %d of %d): %v\n", pending, try, retries, err)
+		generalLogger.Printf("[WARNING] Failed to fetch %d LFS objects (try %d of %d): %v\n", pending, try, retries, err)
+		if try < retries && sleepContext(ctx, lfsRetryPeriod) != nil {
+			break
+		}
+	}
+	for _, oid := range oids {
+		switch {
+		case lfsObjectExists(repoDir, oid):
+			stats.Fetched++
+		case missingOnSource[oid]:
+			stats.MissingOnSource = append(stats.MissingOnSource, oid)
+			corruptedLogger.Printf("LFS object missing on source, URL: %s OID: %s\n", repoURL, oid)
+		default:
+			stats.TransportErrors = append(stats.TransportErrors, oid)
+		}
+	}
+	if len(stats.TransportErrors) != 0 {
+		corruptedLogger.Printf("LFS fetch failed for %d objects, URL: %s\n", len(stats.TransportErrors), repoURL)
+	}
+	fmt.Printf("[DEBUG] fetchLFS<- LFS objects: %d, fetched: %d, missing on source: %d, transport errors: %d\n", stats.Objects, stats.Fetched, len(stats.MissingOnSource), len(stats.TransportErrors))
+	generalLogger.Printf("[DEBUG] fetchLFS<- LFS objects: %d, fetched: %d, missing on source: %d, transport errors: %d\n", stats.Objects, stats.Fetched, len(stats.MissingOnSource), len(stats.TransportErrors))
+	return stats
+}
+
+// lfsBatchObject -- объект в запросе и ответе LFS batch API
+type lfsBatchObject struct {
+	OID     string                     `json:"oid"`
+	Size    int64                      `json:"size"`
+	Actions map[string]json.RawMessage `json:"actions,omitempty"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// lfsMissingOnDest спрашивает у Gitlab-destination через LFS batch API, каких объектов из oids в проекте
+// destPath нет: для них сервер возвращает действие upload
+func lfsMissingOnDest(ctx context.Context, config Config, destPath, repoDir string, oids []string) ([]string, error) {
+	request := struct {
+		Operation string           `json:"operation"`
+		Transfers []string         `json:"transfers"`
+		Objects   []lfsBatchObject `json:"objects"`
+	}{Operation: "upload", Transfers: []string{"basic"}}
+	for _, oid := range oids {
+		info, err := os.Stat(lfsObjectPath(repoDir, oid))
+		if err != nil {
+			return nil, err
+		}
+		request.Objects = append(request.Objects, lfsBatchObject{OID: oid, Size: info.Size()})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	// LFS API доступен по https при любом транспорте git
+	batchURL := fmt.Sprintf("%s/%s.git/info/lfs/objects/batch", strings.TrimSuffix(config.GitlabURLDest, "/"), destPath)
+	batchCtx, cancel := context.WithTimeout(ctx, operationTimeouts.API)
+	defer cancel()
+	req, err := http.NewRequestWithContext(batchCtx, http.MethodPost, batchURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth("oauth2", config.PrivateTokenDest)
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform LFS batch request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("LFS batch request failed with status %d", resp.StatusCode)
+	}
+	var response struct {
+		Objects []lfsBatchObject `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode LFS batch response: %w", err)
+	}
+	var missing []string
+	for _, object := range response.Objects {
+		if object.Error != nil {
+			return nil, fmt.Errorf("LFS object %s rejected: %d %s", object.OID, object.Error.Code, object.Error.Message)
+		}
+		if _, ok := object.Actions["upload"]; ok {
+			missing = append(missing, object.OID)
+		}
+	}
+	return missing, nil
+}
+
+// pushLFS загружает скачанные LFS объекты в проект destPath на Gitlab-destination. Загружаются только объекты,
+// которых там нет по ответу batch API, и только они попадают в stats.Pushed
+func pushLFS(ctx context.Context, config Config, generalLogger *log.Logger, repoDir, newRepoURL, destPath string, stats *LFSStats) error {
+	var oids []string
+	objects, err := listLFSObjects(repoDir)
+	if err != nil {
+		return err
+	}
+	for _, oid := range objects {
+		if lfsObjectExists(repoDir, oid) {
+			oids = append(oids, oid)
+		}
+	}
+	for start := 0; start < len(oids); start += lfsPushChunkSize {
+		end := start + lfsPushChunkSize
+		if end > len(oids) {
+			end = len(oids)
+		}
+		missing, err := lfsMissingOnDest(ctx, config, destPath, repoDir, oids[start:end])
+		if err == nil && len(missing) != 0 {
+			pushCtx, cancel := context.WithTimeout(ctx, operationTimeouts.Push)
+			_, err = config.gitDest.LFSPush(pushCtx, repoDir, newRepoURL, missing)
+			cancel()
+		}
+		if err != nil {
+			fmt.Printf("[WARNING] Failed to push lfs: %v\n", err)
+			generalLogger.Printf("[WARNING] Failed to push lfs: %v\n", err)
+			return fmt.Errorf("failed to push LFS objects: %w", err)
+		}
+		stats.Pushed += len(missing)
+	}
+	return nil
+}
+
+// lfsIncomplete возвращает ошибку, если LFS объекты проекта перенесены не все: часть не скачалась
+// с Gitlab-source или не загрузилась на Gitlab-destination (pushErr)
+func lfsIncomplete(stats *LFSStats, pushErr error) error {
+	if pushErr != nil {
+		return pushErr
+	}
+	if len(stats.TransportErrors) != 0 {
+		return fmt.Errorf("failed to fetch %d LFS objects from source", len(stats.TransportErrors))
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lfsStubMirror -- GitMirror, у которого заданы только операции LFS
+type lfsStubMirror struct {
+	GitMirror
+	fetch func(repoDir string) (string, error)
+	push  func(oids []string) (string, error)
+}
+
+func (m *lfsStubMirror) LFSFetch(ctx context.Context, repoDir string) (string, error) {
+	return m.fetch(repoDir)
+}
+
+func (m *lfsStubMirror) LFSPush(ctx context.Context, repoDir, repoURL string, oids []string) (string, error) {
+	return m.push(oids)
+}
+
+// useFakeGitLFS кладет в начало PATH git-lfs, который на git lfs ls-files выводит объекты oids
+func useFakeGitLFS(t *testing.T, oids ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\ntest \"$1\" = ls-files || exit 1\ncat <<'EOF'\n"
+	for i, oid := range oids {
+		script += oid + " * file" + strings.Repeat("x", i) + ".bin\n"
+	}
+	script += "EOF\n"
+	if err := os.WriteFile(filepath.Join(dir, "git-lfs"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// writeLFSObject кладет LFS объект в репозиторий, как git lfs fetch
+func writeLFSObject(t *testing.T, repoDir, oid string) {
+	t.Helper()
+	objectPath := lfsObjectPath(repoDir, oid)
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(objectPath, []byte(oid), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFetchLFSClassifiesFailures проверяет, что объекты, на которые сервер ответил 404, считаются
+// отсутствующими на Gitlab-source, а остальные не скачанные -- ошибками передачи
+func TestFetchLFSClassifiesFailures(t *testing.T) {
+	fetched, missing, missingText, refused, forbidden := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64), strings.Repeat("d", 64), strings.Repeat("e", 64)
+	useFakeGitLFS(t, fetched, missing, missingText, refused, forbidden)
+	repoDir := t.TempDir()
+	tries := 0
+	mirror := &lfsStubMirror{fetch: func(repoDir string) (string, error) {
+		tries++
+		writeLFSObject(t, repoDir, fetched)
+		return strings.Join([]string{
+			"Fetching all references...",
+			"[" + missing + "] Object does not exist on the server: [404] Object does not exist on the server",
+			"[" + missingText + "] Object does not exist on the server",
+			"[" + refused + `] Post "https://gitlab.example.com/group/app.git/info/lfs/objects/batch": dial tcp 10.0.0.1:443: connect: connection refused`,
+			"[" + forbidden + "] Authorization error: [403] Access denied",
+			"error: failed to fetch some objects from 'https://gitlab.example.com/group/app.git/info/lfs'",
+		}, "\n"), errors.New("exit status 2")
+	}}
+	discard := log.New(io.Discard, "", 0)
+	config := Config{LFSRetries: 1, gitSource: mirror}
+
+	stats := fetchLFS(context.Background(), config, discard, discard, repoDir, "https://gitlab.example.com/group/app.git")
+
+	want := &LFSStats{
+		Objects:         5,
+		Fetched:         1,
+		MissingOnSource: []string{missing, missingText},
+		TransportErrors: []string{refused, forbidden},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("fetchLFS stats = %+v, want %+v", stats, want)
+	}
+	if tries != 1 {
+		t.Errorf("LFS fetch tries = %d, want 1", tries)
+	}
+}
+
+// TestPushLFSCountsUploaded проверяет, что на Gitlab-destination загружаются и попадают в отчет только объекты,
+// которых там нет по ответу batch API, а не все локальные
+func TestPushLFSCountsUploaded(t *testing.T) {
+	first, second, third, notFetched := strings.Repeat("1", 64), strings.Repeat("2", 64), strings.Repeat("3", 64), strings.Repeat("4", 64)
+	useFakeGitLFS(t, first, second, third, notFetched)
+	repoDir := t.TempDir()
+	for _, oid := range []string{first, second, third} {
+		writeLFSObject(t, repoDir, oid)
+	}
+	dest := newFakeGitLab(t, "dest-token")
+	group := dest.addGroup("group", "group", nil)
+	dest.addProject(group, "app", "app", nil, nil, nil)
+	// Два объекта из трех уже есть на сервере
+	dest.addLFSObjects("group/app", first, third)
+	var pushed []string
+	mirror := &lfsStubMirror{push: func(oids []string) (string, error) {
+		pushed = append(pushed, oids...)
+		dest.addLFSObjects("group/app", oids...)
+		return "", nil
+	}}
+	config := Config{GitlabURLDest: dest.URL(), PrivateTokenDest: dest.token, gitDest: mirror}
+	setUpTestGitTransport(t, config)
+	stats := &LFSStats{}
+
+	for run := 1; run <= 2; run++ {
+		if err := pushLFS(context.Background(), config, log.New(io.Discard, "", 0), repoDir, dest.URL()+"/group/app.git", "group/app", stats); err != nil {
+			t.Fatalf("pushLFS (run %d): %v", run, err)
+		}
+	}
+
+	if want := []string{second}; !reflect.DeepEqual(pushed, want) {
+		t.Errorf("objects passed to git lfs push = %v, want objects missing on destination %v", pushed, want)
+	}
+	if stats.Pushed != 1 {
+		t.Errorf("pushed = %d, want 1 uploaded object", stats.Pushed)
+	}
+}
+
+// TestSyncLFSIncomplete проверяет, что проект, LFS объекты которого не скачались с Gitlab-source
+// или не загрузились на Gitlab-destination, помечается неудачным, как и при unbundle
+func TestSyncLFSIncomplete(t *testing.T) {
+	fetched, refused := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	tests := []struct {
+		name    string
+		fetch   func(repoDir string) (string, error)
+		push    func(oids []string) (string, error)
+		wantErr string
+	}{
+		{
+			name: "transport errors",
+			fetch: func(repoDir string) (string, error) {
+				writeLFSObject(t, repoDir, fetched)
+				return "[" + refused + "] dial tcp 10.0.0.1:443: connect: connection refused", errors.New("exit status 2")
+			},
+			push:    func(oids []string) (string, error) { return "", nil },
+			wantErr: "failed to fetch 1 LFS objects",
+		},
+		{
+			name: "push failure",
+			fetch: func(repoDir string) (string, error) {
+				writeLFSObject(t, repoDir, fetched)
+				writeLFSObject(t, repoDir, refused)
+				return "", nil
+			},
+			push:    func(oids []string) (string, error) { return "", errors.New("exit status 2") },
+			wantErr: "failed to push LFS objects",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setUpTestWorkspace(t)
+			useFakeGitLFS(t, fetched, refused)
+			source := newFakeGitLab(t, "source-token")
+			root := source.addGroup("xxxxx", "xxxxx", nil)
+			source.addProject(root, "App", "app", []map[string]string{{"README.md": "app\n"}}, nil, nil)
+			dest := newFakeGitLab(t, "dest-token")
+			config := newTestConfig(t, source, dest, func(config *Config) {
+				config.LFSRetries = 1
+			})
+			config.gitSource = &lfsStubMirror{GitMirror: config.gitSource, fetch: tt.fetch}
+			config.gitDest = &lfsStubMirror{GitMirror: config.gitDest, push: tt.push}
+
+			runSync(t, config)
+
+			if len(config.report.Projects) != 1 {
+				t.Fatalf("report projects = %+v, want one", config.report.Projects)
+			}
+			project := config.report.Projects[0]
+			if project.Status != statusFailed || !strings.Contains(project.Error, tt.wantErr) {
+				t.Errorf("status = %q, error = %q, want failed with %q", project.Status, project.Error, tt.wantErr)
+			}
+			// Ветки пушатся и без LFS объектов: повторный запуск дольет объекты
+			if got, want := dest.refs("mock-sync/xxxxx/app"), source.refs("xxxxx/app"); !reflect.DeepEqual(got, want) {
+				t.Errorf("refs = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestSyncGoGitRefusesLFS проверяет, что с go-git проект с LFS объектами не переносится без них,
+// а помечается неудачным
+func TestSyncGoGitRefusesLFS(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newFakeGitLab(t, "source-token")
+	root := source.addGroup("xxxxx", "xxxxx", nil)
+	oid := strings.Repeat("f", 64)
+	source.addProject(root, "Assets", "assets", []map[string]string{{
+		".gitattributes": "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"model.bin":      "version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\nsize 12\n",
+	}}, nil, nil)
+	source.addProject(root, "App", "app", []map[string]string{{"README.md": "app\n"}}, nil, nil)
+	dest := newFakeGitLab(t, "dest-token")
+	config := newTestConfig(t, source, dest, func(config *Config) {
+		config.GitBackend = gitBackendGoGit
+	})
+
+	runSync(t, config)
+
+	wantStatuses := map[string]string{"xxxxx/assets": statusFailed, "xxxxx/app": statusSuccess}
+	if got := reportStatuses(config); !reflect.DeepEqual(got, wantStatuses) {
+		t.Errorf("report statuses = %v, want %v", got, wantStatuses)
+	}
+	for _, project := range config.report.Projects {
+		if project.Project == "xxxxx/assets" && !strings.Contains(project.Error, errLFSNotSupported.Error()) {
+			t.Errorf("LFS project error = %q, want %q", project.Error, errLFSNotSupported)
+		}
+	}
+	if refs := dest.refs("mock-sync/xxxxx/assets"); len(refs) != 0 {
+		t.Errorf("LFS project refs were pushed without LFS objects: %v", refs)
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,10 +11,14 @@ import (
 	"log"
 	"mime/multipart"
 	"net/http"
+	neturl "net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -35,9 +39,19 @@ type Group struct {
 
 // Project отображает скрутуру проектов
 type Project struct {
-	ID            int    `json:"id"`
-	Name          string `json:"name"`
-	DefaultBranch string `json:"default_branch"`
+	ID                int       `json:"id"`
+	Name              string    `json:"name"`
+	Path              string    `json:"path"`
+	PathWithNamespace string    `json:"path_with_namespace"`
+	DefaultBranch     string    `json:"default_branch"`
+	SSHURLToRepo      string    `json:"ssh_url_to_repo"`
+	HTTPURLToRepo     string    `json:"http_url_to_repo"`
+	Archived          bool      `json:"archived"`
+	Visibility        string    `json:"visibility"`
+	EmptyRepo         bool      `json:"empty_repo"`
+	LastActivityAt    time.Time `json:"last_activity_at"`
+	// Заполняется только в списке проектов группы (getProjectsFromGroup)
+	Statistics ProjectStatistics `json:"statistics"`
 }
 
 // Allower
@@ -53,22 +67,137 @@ type Config struct {
 	PrivateTokenSource string `json:"privateTokenSource"`
 	GitlabURLDest      string `json:"gitlabURLDest"`
 	PrivateTokenDest   string `json:"privateTokenDest"`
+	// Перенос участников групп и проектов
+	MembersSync           bool   `json:"membersSync"`
+	MembersMapFile        string `json:"membersMapFile"`        // Файл сопоставления пользователей source -> destination
+	MembersMaxAccessLevel int    `json:"membersMaxAccessLevel"` // Максимальный уровень доступа на Gitlab-destination (0 -- без ограничения)
+	// Перенос релизов
+	ReleasesSync       bool `json:"releasesSync"`
+	ReleasesCopyAssets bool `json:"releasesCopyAssets"` // Перезагружать файлы generic пакетов, на которые ссылаются релизы
+	// Перенос реестра пакетов
+	PackagesSync bool `json:"packagesSync"`
+	// Перенос образов реестра контейнеров
+	RegistrySync       bool     `json:"registrySync"`
+	RegistryURLSource  string   `json:"registryURLSource"`
+	RegistryUserSource string   `json:"registryUserSource"`
+	RegistryURLDest    string   `json:"registryURLDest"`
+	RegistryUserDest   string   `json:"registryUserDest"`
+	RegistryTagInclude []string `json:"registryTagInclude"` // Регулярные выражения тегов для переноса (пусто -- все)
+	RegistryTagExclude []string `json:"registryTagExclude"` // Регулярные выражения тегов, которые не переносим
+
+	// Способ переноса проектов: clone (по умолчанию) или archive
+	TransferMode  string            `json:"transferMode"`
+	TransferModes map[string]string `json:"transferModes"` // Полный путь группы или проекта на Gitlab-source -> способ переноса
+	// Количество попыток скачать LFS объекты (по умолчанию 3)
+	LFSRetries int `json:"lfsRetries"`
+	// Ключи подписи и шифрования выгрузки (bundle/unbundle), создаются командой keygen
+	BundleSigningKey    string `json:"bundleSigningKey"`    // Закрытый ключ ed25519 для подписи манифеста
+	BundleVerifyKey     string `json:"bundleVerifyKey"`     // Открытый ключ ed25519: без валидной подписи выгрузка не загружается
+	BundleEncryptionKey string `json:"bundleEncryptionKey"` // Открытый ключ X25519 получателя для шифрования файлов
+	BundleDecryptionKey string `json:"bundleDecryptionKey"` // Закрытый ключ X25519 для расшифровки файлов
+	// Проверка репозиториев на секреты перед пушем: report, quarantine или block (пусто -- не проверять)
+	SecretScanPolicy string       `json:"secretScanPolicy"`
+	SecretScanRules  []SecretRule `json:"secretScanRules"` // Дополнительные правила к встроенным
+	// Фильтры истории: полный путь группы или проекта на Gitlab-source -> что удалить из истории перед пушем
+	HistoryFilters map[string]HistoryFilter `json:"historyFilters"`
+	// Перезапись авторов и коммитеров перед пушем: по файлу в формате .mailmap и/или хешем почты
+	IdentityMailmap    string `json:"identityMailmap"`
+	IdentityHash       bool   `json:"identityHash"`       // Обезличивать авторов, которых нет в mailmap
+	IdentityHashSalt   string `json:"identityHashSalt"`   // Секрет HMAC, чтобы по хешу нельзя было подобрать почту
+	IdentityHashDomain string `json:"identityHashDomain"` // Домен почты обезличенных авторов
+	// Переименование путей на Gitlab-destination: JSON файл полный путь source -> полный путь destination и правила
+	PathMapFile string     `json:"pathMapFile"`
+	PathRules   []PathRule `json:"pathRules"`
+	// Способ доступа git к Gitlab-source и Gitlab-destination: ssh (по умолчанию) или https с токеном
+	GitTransportSource string `json:"gitTransportSource"`
+	GitTransportDest   string `json:"gitTransportDest"`
+	// Настройки TLS для API и git: без tlsVerify сертификаты не проверяются
+	TLSVerify bool   `json:"tlsVerify"`
+	TLSCAFile string `json:"tlsCAFile"` // Дополнительный корневой сертификат (PEM)
+	// Настройки ssh для Gitlab-source и Gitlab-destination
+	SSHSource SSHConfig `json:"sshSource"`
+	SSHDest   SSHConfig `json:"sshDest"`
+	// Реализация операций git: exec (git и git-lfs, по умолчанию) или go-git
+	GitBackend string `json:"gitBackend"`
+	// Сколько ссылок пушится одним git push (по умолчанию pushDefaultBatchSize)
+	PushBatchSize int `json:"pushBatchSize"`
+	// Пространства ссылок, которые пушатся вместе с ветками и тегами (merge requests, notes и т.п.)
+	RefNamespaces []RefNamespace `json:"refNamespaces"`
+	// Большие репозитории: размер по статистике Gitlab-source, больше которого проект пропускается (0 -- без
+	// ограничения), и сколько места должно остаться свободным в рабочей директории после клонирования
+	MaxRepositorySizeMB int64 `json:"maxRepositorySizeMB"`
+	MinFreeDiskMB       int64 `json:"minFreeDiskMB"`
+	// Зеркала больше pushChunkThresholdMB пушатся частями по pushChunkCommits коммитов (0 -- целиком)
+	PushChunkThresholdMB int64 `json:"pushChunkThresholdMB"`
+	PushChunkCommits     int   `json:"pushChunkCommits"`
+	// Ограничения времени запросов к API и операций git
+	Timeouts TimeoutConfig `json:"timeouts"`
+	// Экспорт проектов (archive): сколько экспортов идет одновременно и сколько запросов экспорта
+	// в минуту допускает Gitlab-source
+	ExportConcurrency int `json:"exportConcurrency"`
+	ExportRateLimit   int `json:"exportRateLimit"`
+	// Неудачный импорт архива: сколько раз повторить и переносить ли затем проект через clone
+	ImportRetries       int  `json:"importRetries"`
+	ImportFallbackClone bool `json:"importFallbackClone"`
+
+	membersMap map[string]string
+	state      *SyncState
+	report     *RunReport
+
+	registryTagInclude []*regexp.Regexp
+	registryTagExclude []*regexp.Regexp
+	secretRules        []SecretRule
+	identities         *identityMapper
+	paths              *pathMapper
+	gitEnvSource       []string // Окружение git с GIT_SSH_COMMAND для Gitlab-source
+	gitEnvDest         []string // Окружение git с GIT_SSH_COMMAND для Gitlab-destination
+	gitSource          GitMirror
+	gitDest            GitMirror
+	refNamespaces      []RefNamespace
+	exports            *exportScheduler
 }
 
 const (
 	xxxArea            = "mock-sync"
-	exportCheckPeriod  = 5 * time.Second
 	whiteListGroupPath = "mock"
 	tmpDir             = "./cloneProjects"
+	// Сколько ссылок пушится одним git push, если pushBatchSize не задан
+	pushDefaultBatchSize = 500
+)
+
+// Адреса Gitlab, от которых зависят правила переноса групп. Переменные, а не константы, чтобы
+// тесты могли направить их на фейковый Gitlab
+var (
 	reservationAddress = "https://xxx.xxx.xx.xx"  // Адрес резервации для использования black list
 	destAddress        = "https://git.ixample.ru" // Конечный адрес для использования black list
 )
 
+// Способы переноса проекта
+const (
+	transferModeClone   = "clone"   // git clone --mirror и push
+	transferModeArchive = "archive" // экспорт/импорт архива проекта через API
+)
+
 // Black листа два. 1. На синхронизацию с резервацией 2. На синхронизацию резеровации с xxxxx
 // От xxxxx полностью изолировать группы проектов искра, art отдел, группу проектов xxxx и группу проектов xxxx и группу проектов xxxx
 func main() {
 	// Установим счетчик времени
 	currentTime := time.Now()
+	// Разберем команду (bundle/unbundle) до смены рабочей директории, чтобы пути остались относительными текущей
+	command, err := parseCommand(os.Args[1:])
+	if err != nil {
+		fmt.Printf("[ERROR] %v\n", err)
+		os.Exit(2)
+	}
+	// Создание ключей не требует ни конфигурации, ни доступа к Gitlab
+	if command.Name == commandKeygen {
+		if err := generateKeys(command.Path); err != nil {
+			fmt.Printf("[ERROR] Failed to generate keys: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("[SUCCESS] Keys generated in: ", command.Path)
+		os.Exit(0)
+	}
 	// Настроим рабочую директорию
 	if err := setUpWorkspace(); err != nil {
 		fmt.Printf("[ERROR] Failed to set up working space: %v\n", err)
@@ -81,6 +210,13 @@ func main() {
 		return
 	}
 	defer corruptedFile.Close()
+	// Создадим файл для находок проверки на секреты
+	findingsFile, err := os.OpenFile(findingsLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to open findings log file: %v\n", err)
+		return
+	}
+	defer findingsFile.Close()
 	// Создадим файл для общего логирования
 	generalFile, err := os.OpenFile("general.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
@@ -91,9 +227,11 @@ func main() {
 	// Устанавливаем логгеры для различных типов логов
 	corruptedLogger := log.New(corruptedFile, "CORRUPTED: ", log.Ldate|log.Ltime|log.Lshortfile)
 	generalLogger := log.New(generalFile, "GENERAL: ", log.Ldate|log.Ltime|log.Lshortfile)
+	findingsLogger := log.New(findingsFile, "FINDING: ", log.Ldate|log.Ltime)
 	// Отделим логи от предыдущего вызова программы
 	generalLogger.Printf("------------ %s ------------\n", currentTime)
 	corruptedLogger.Printf("------------ %s ------------\n", currentTime)
+	findingsLogger.Printf("------------ %s ------------\n", currentTime)
 	fmt.Printf("[START] Gitlab importer start now: %s\n", currentTime)
 	generalLogger.Printf("[START] Gitlab importer start now: %s\n", currentTime)
 	// Чтение содержимого файла конфигурации
@@ -113,180 +251,354 @@ func main() {
 		generalLogger.Printf("[ERROR] Failed to parse config file: %v\n", err)
 		os.Exit(1)
 	}
-	// Получим корневые группы
-	rootGroups, err := getRootGroups(generalLogger, config.GitlabURLSource, config.PrivateTokenSource)
+	// Настроим TLS и доступ git к обоим Gitlab
+	for _, transport := range []string{config.GitTransportSource, config.GitTransportDest} {
+		if transport != "" && transport != gitTransportSSH && transport != gitTransportHTTPS {
+			fmt.Printf("[ERROR] Unknown git transport %q\n", transport)
+			generalLogger.Printf("[ERROR] Unknown git transport %q\n", transport)
+			os.Exit(1)
+		}
+	}
+	if err := setUpTimeouts(config.Timeouts); err != nil {
+		fmt.Printf("[ERROR] Failed to parse timeouts: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to parse timeouts: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setUpTLS(config); err != nil {
+		fmt.Printf("[ERROR] Failed to set up TLS: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to set up TLS: %v\n", err)
+		os.Exit(1)
+	}
+	err = setUpGitTransport(config)
+	if err == nil {
+		config.gitEnvSource, err = gitEnv(config.SSHSource)
+	}
+	if err == nil {
+		config.gitEnvDest, err = gitEnv(config.SSHDest)
+	}
+	if err == nil {
+		config.gitSource, config.gitDest, err = newGitMirrors(config, generalLogger)
+	}
 	if err != nil {
-		fmt.Println("[ERROR] Error fetching root groups with parent_(id=0):", err)
-		generalLogger.Println("[ERROR] Error fetching root groups with parent_(id=0):", err)
+		fmt.Printf("[ERROR] Failed to set up git transport: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to set up git transport: %v\n", err)
+		os.Exit(1)
+	}
+	// Прочитаем файл сопоставления пользователей, если он задан
+	if config.MembersMapFile != "" {
+		config.membersMap, err = loadMembersMap(config.MembersMapFile)
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to load members map: %v\n", err)
+			generalLogger.Printf("[ERROR] Failed to load members map: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	// Проверим настройки переноса реестра контейнеров
+	if config.RegistrySync {
+		if config.RegistryURLSource == "" || config.RegistryURLDest == "" {
+			fmt.Println("[ERROR] registryURLSource and registryURLDest must be set to sync container registry")
+			generalLogger.Println("[ERROR] registryURLSource and registryURLDest must be set to sync container registry")
+			os.Exit(1)
+		}
+		if config.registryTagInclude, err = compilePatterns(config.RegistryTagInclude); err == nil {
+			config.registryTagExclude, err = compilePatterns(config.RegistryTagExclude)
+		}
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to parse registry tag patterns: %v\n", err)
+			generalLogger.Printf("[ERROR] Failed to parse registry tag patterns: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	// Проверим способы переноса
+	for path, mode := range config.TransferModes {
+		if mode != transferModeClone && mode != transferModeArchive {
+			fmt.Printf("[ERROR] Unknown transfer mode %q for %s\n", mode, path)
+			generalLogger.Printf("[ERROR] Unknown transfer mode %q for %s\n", mode, path)
+			os.Exit(1)
+		}
+	}
+	if config.TransferMode != "" && config.TransferMode != transferModeClone && config.TransferMode != transferModeArchive {
+		fmt.Printf("[ERROR] Unknown transfer mode %q\n", config.TransferMode)
+		generalLogger.Printf("[ERROR] Unknown transfer mode %q\n", config.TransferMode)
 		os.Exit(1)
 	}
+	// Проверим настройки проверки на секреты
+	if config.SecretScanPolicy != "" {
+		if config.SecretScanPolicy != secretPolicyReport && config.SecretScanPolicy != secretPolicyQuarantine && config.SecretScanPolicy != secretPolicyBlock {
+			fmt.Printf("[ERROR] Unknown secret scan policy %q\n", config.SecretScanPolicy)
+			generalLogger.Printf("[ERROR] Unknown secret scan policy %q\n", config.SecretScanPolicy)
+			os.Exit(1)
+		}
+		config.secretRules, err = compileSecretRules(config.SecretScanRules)
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to parse secret scan rules: %v\n", err)
+			generalLogger.Printf("[ERROR] Failed to parse secret scan rules: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	// Проверим пространства ссылок для пуша
+	config.refNamespaces, err = compileRefNamespaces(config.RefNamespaces)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to parse ref namespaces: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to parse ref namespaces: %v\n", err)
+		os.Exit(1)
+	}
+	// Прочитаем правила переименования путей
+	config.paths, err = newPathMapper(config)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to load path mapping: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to load path mapping: %v\n", err)
+		os.Exit(1)
+	}
+	config.report = newRunReport(currentTime)
+	config.exports = newExportScheduler(config, generalLogger)
+	// Прочитаем состояние предыдущих запусков для инкрементальной синхронизации
+	config.state, err = loadSyncState(stateFile)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to load sync state: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to load sync state: %v\n", err)
+		os.Exit(1)
+	}
+	// Настроим перезапись авторов (использует состояние для стабильности SHA между запусками)
+	config.identities, err = newIdentityMapper(config)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to set up identity rewrite: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to set up identity rewrite: %v\n", err)
+		os.Exit(1)
+	}
+	if checkpoint := config.state.Checkpoint; checkpoint != nil {
+		fmt.Printf("[WARNING] Previous run was interrupted at %s while transferring %s\n", checkpoint.InterruptedAt.Format(time.RFC3339), checkpoint.Project)
+		generalLogger.Printf("[WARNING] Previous run was interrupted at %s while transferring %s\n", checkpoint.InterruptedAt.Format(time.RFC3339), checkpoint.Project)
+	}
+	// Ctrl-C и SIGTERM прерывают текущую операцию, после чего сохраняется отметка о прерванном запуске
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Перенос через файлы выгрузки вместо прямой синхронизации
+	if command.Name != "" {
+		switch command.Name {
+		case commandBundle:
+			err = runBundle(ctx, config, generalLogger, corruptedLogger, findingsLogger, command)
+		case commandUnbundle:
+			err = runUnbundle(ctx, config, generalLogger, command)
+		}
+		if ctx.Err() != nil {
+			writeCheckpoint(config, generalLogger)
+			os.Exit(130)
+		}
+		saveReport(config, generalLogger)
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to %s: %v\n", command.Name, err)
+			generalLogger.Printf("[ERROR] Failed to %s: %v\n", command.Name, err)
+			os.Exit(1)
+		}
+		endTime := time.Since(currentTime)
+		fmt.Printf("[END] Program complete at: %v\n", endTime)
+		generalLogger.Printf("[END] Program complete at: %v\n", endTime)
+		os.Exit(0)
+	}
+	// Перенесем группы и проекты
+	err = syncGroups(ctx, config, generalLogger, corruptedLogger, findingsLogger)
+	if ctx.Err() != nil {
+		writeCheckpoint(config, generalLogger)
+		os.Exit(130)
+	}
+	if err != nil {
+		fmt.Printf("[ERROR] %v\n", err)
+		generalLogger.Printf("[ERROR] %v\n", err)
+		saveReport(config, generalLogger)
+		os.Exit(1)
+	}
+	// Сохраним отчет о запуске и снимем отметку о прерванном запуске
+	saveReport(config, generalLogger)
+	if err := config.state.setCheckpoint(nil); err != nil {
+		fmt.Printf("[ERROR] Failed to save sync state: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to save sync state: %v\n", err)
+	}
+	// Выводим время выполнения программы и завершаем её
+	endTime := time.Since(currentTime)
+	fmt.Printf("[END] Program complete at: %v\n", endTime)
+	generalLogger.Printf("[END] Program complete at: %v\n", endTime)
+	os.Exit(0)
+}
+
+// syncGroups переносит разрешенные корневые группы Gitlab-source со всеми подгруппами и проектами
+// в Gitlab-destination и в конце снимает бейдж с корневой группы xxxArea
+func syncGroups(ctx context.Context, config Config, generalLogger, corruptedLogger, findingsLogger *log.Logger) error {
+	// Получим корневые группы
+	rootGroups, err := getRootGroups(ctx, generalLogger, config.GitlabURLSource, config.PrivateTokenSource)
+	if err != nil {
+		return fmt.Errorf("error fetching root groups with parent_(id=0): %v", err)
+	}
 	// Создадим группу xxxxx-sync, в которую будут записываться проекты и группы на удаленном Gitlab-destination
 	// если группа существует, то просто получим её ID
-	xxxAreaGroupID := createGroup(generalLogger, config.GitlabURLDest, config.PrivateTokenDest, Group{Name: xxxArea, Path: xxxArea, FullPath: xxxArea}, 0, true)
+	xxxAreaGroupID := createGroup(ctx, generalLogger, config.GitlabURLDest, config.PrivateTokenDest, Group{Name: xxxArea, Path: xxxArea, FullPath: xxxArea}, 0, true)
 	// blackList :=
 	// Пройдемся по всем КОРНЕВЫМ группам в родном Gitlab-source
 	for _, group := range rootGroups {
-		// Выставим ограничение на загрузку только xxx-dep и xxx в резервацию
-		if !(config.GitlabURLDest == destAddress) {
-			if !(group.FullPath == "xxxxx") && !(group.FullPath == "xxxxx-dep") {
-				continue
-			}
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-
-		// Следующая проверка позволяет загрузить в xxxxx Gitlab исключительно проекты из группы xxx-sync,
-		// но также позволяет загружать проекты из всех групп, если это не xxxxx (т.е. если это резервация)
-		if config.GitlabURLDest == destAddress {
-			if !strings.HasPrefix(group.FullPath, xxxArea) {
-				continue
-			}
+		if !rootGroupAllowed(config, group) {
+			continue
 		}
 
 		// if group.FullPath == "xxxxx" {
 
-		importProjectClone(config, group, generalLogger, corruptedLogger, xxxAreaGroupID)
+		importProjectClone(ctx, config, group, generalLogger, corruptedLogger, findingsLogger, xxxAreaGroupID)
 
 		// }
-		// Вызовем объединенную функцию импортирования проектов
-		// importProcessArchive(group, config, xxxAreaGroupID)
 	}
 	// Удаляем бейдж private c корневой директории xxxxx-sync в резервации
-	_, xxxArexxxAreaGroupBadgeID := getBadge(config.GitlabURLDest, config.PrivateTokenDest, xxxAreaGroupID)
+	_, xxxArexxxAreaGroupBadgeID := getBadge(ctx, config.GitlabURLDest, config.PrivateTokenDest, xxxAreaGroupID)
 	if xxxArexxxAreaGroupBadgeID != 0 {
-		err = removeBadge(config.GitlabURLDest, config.PrivateTokenDest, xxxAreaGroupID, xxxArexxxAreaGroupBadgeID)
+		err = removeBadge(ctx, config.GitlabURLDest, config.PrivateTokenDest, xxxAreaGroupID, xxxArexxxAreaGroupBadgeID)
 		if err != nil {
-			fmt.Printf("[ERROR] Failed to remove badge for group %s: %v\n", xxxArea, err)
-			generalLogger.Printf("[ERROR] Failed to remove badge for group %s: %v\n", xxxArea, err)
-			os.Exit(1)
+			return fmt.Errorf("failed to remove badge for group %s: %v", xxxArea, err)
 		}
 	}
-	// Выводим время выполнения программы и завершаем её
-	endTime := time.Since(currentTime)
-	fmt.Printf("[END] Program complete at: %v\n", endTime)
-	generalLogger.Printf("[END] Program complete at: %v\n", endTime)
-	os.Exit(0)
+	return nil
 }
 
-// Проверка на "экспортирован ли проект?" и возвращает статус экспорта
-func isExportFinished(url, token string, projectID int) (bool, string) {
-	fmt.Println("[DEBUG] isExportFinished-> Check export status id: ", projectID)
-	fmt.Println("[DEBUG] isExportFinished-> Check export status id: ", projectID)
-	// СОздаем запрос
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v4/projects/%d/export", url, projectID), nil)
-	if err != nil {
-		fmt.Println("[ERROR] Error creating request:", err)
-		os.Exit(1)
+// rootGroupAllowed проверяет, переносится ли корневая группа на Gitlab-destination
+func rootGroupAllowed(config Config, group Group) bool {
+	// Выставим ограничение на загрузку только xxx-dep и xxx в резервацию
+	if !(config.GitlabURLDest == destAddress) {
+		if !(group.FullPath == "xxxxx") && !(group.FullPath == "xxxxx-dep") {
+			return false
+		}
 	}
-	req.Header.Set("PRIVATE-TOKEN", token)
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+
+	// Следующая проверка позволяет загрузить в xxxxx Gitlab исключительно проекты из группы xxx-sync,
+	// но также позволяет загружать проекты из всех групп, если это не xxxxx (т.е. если это резервация)
+	if config.GitlabURLDest == destAddress {
+		if !strings.HasPrefix(group.FullPath, xxxArea) {
+			return false
+		}
 	}
+	return true
+}
 
-	// Создание HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
-	// Выполняем и сохраняем ответ
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("[ERROR] Error checking export status:", err)
-		os.Exit(1)
+// destGroupPath возвращает полный путь группы на Gitlab-destination. В резервации все группы
+// лежат в xxxArea, а на xxxxx Gitlab переносится уже сама xxxArea
+func destGroupPath(config Config, groupFullPath string) string {
+	if config.GitlabURLDest == destAddress {
+		return groupFullPath
 	}
-	defer resp.Body.Close()
+	return xxxArea + "/" + groupFullPath
+}
 
-	var result map[string]interface{}
-	// Сохраняем тело ответа в структуру
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		fmt.Println("[ERROR] Error decoding response:", err)
-		os.Exit(1)
+// buildSourceRepoURL возвращает адрес репозитория на Gitlab-source. Адрес берется из API
+// (ssh_url_to_repo или http_url_to_repo), и только если его там нет -- строится по полному пути проекта
+func buildSourceRepoURL(config Config, project Project) string {
+	if config.GitTransportSource == gitTransportHTTPS {
+		if project.HTTPURLToRepo != "" {
+			return project.HTTPURLToRepo
+		}
+		return fmt.Sprintf("%s/%s.git", strings.TrimSuffix(config.GitlabURLSource, "/"), project.PathWithNamespace)
+	}
+	// Явно заданный порт важнее порта из адреса в API
+	if project.SSHURLToRepo != "" && config.SSHSource.Port == 0 {
+		return project.SSHURLToRepo
 	}
-	// Сохраняем статус экспорта (none, started, finished, failed)
-	status, ok := result["export_status"].(string)
+	sourceSSHPort := 2222
+	if config.SSHSource.Port != 0 {
+		sourceSSHPort = config.SSHSource.Port
+	}
+	modifiedGitlabURLSource := strings.TrimPrefix(config.GitlabURLSource, "https://")
+	return fmt.Sprintf("ssh://git@%s:%d/%s.git", modifiedGitlabURLSource, sourceSSHPort, project.PathWithNamespace)
+}
 
-	fmt.Println("[DEBUG] isExportFinished<- export status is: ", status)
-	return ok && status == "finished", status
+// buildDestRepoURL строит адрес репозитория на Gitlab-destination
+func buildDestRepoURL(config Config, groupDest, projectName string) string {
+	if config.GitTransportDest == gitTransportHTTPS {
+		return fmt.Sprintf("%s/%s/%s.git", strings.TrimSuffix(config.GitlabURLDest, "/"), groupDest, projectName)
+	}
+	// Устанавливаем удаленный порт в зависимости от получателя (у xxx это 22, а резервация -- 2222)
+	destSSHPortPostfix := "2222"
+	if config.GitlabURLDest == destAddress {
+		destSSHPortPostfix = "22"
+	}
+	if config.SSHDest.Port != 0 {
+		destSSHPortPostfix = strconv.Itoa(config.SSHDest.Port)
+	}
+	modifiedGitlabURLDest := strings.TrimPrefix(config.GitlabURLDest, "https://")
+	return fmt.Sprintf("ssh://git@%s:%s/%s/%s.git", modifiedGitlabURLDest, destSSHPortPostfix, groupDest, projectName)
 }
 
-// Загрузка файла из на локальную машину
-func downloadProject(url, token string, projectID int, projectName string) error {
-	fmt.Println("[DEBUG] downloadProject-> Start download project to local machine. Project: ", projectName)
-	// Создадим запрос на загрузку файла на локальную машину
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v4/projects/%d/export/download", url, projectID), nil)
-	if err != nil {
-		fmt.Println("[ERROR] Error creating request:", err)
-		os.Exit(1)
+// getExportStatus получает статус экспорта проекта (none, queued, started, finished, failed, regeneration_in_progress)
+func getExportStatus(ctx context.Context, url, token string, projectID int) (string, error) {
+	fmt.Println("[DEBUG] getExportStatus-> Check export status id: ", projectID)
+	var result struct {
+		ExportStatus string `json:"export_status"`
 	}
-	req.Header.Set("PRIVATE-TOKEN", token)
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	if err := getJSON(ctx, fmt.Sprintf("%s/api/v4/projects/%d/export", url, projectID), token, &result); err != nil {
+		return "", fmt.Errorf("failed to check export status: %w", err)
 	}
+	fmt.Println("[DEBUG] getExportStatus<- export status is: ", result.ExportStatus)
+	return result.ExportStatus, nil
+}
 
-	// Создание HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
-	// Выполним запрос и сохраним ответ
-	resp, err := client.Do(req)
+// errRateLimited -- Gitlab ответил 429, запрос нужно повторить позже
+var errRateLimited = errors.New("[WARNING] Network is buisy, retry automatic download")
+
+// Загрузка файла из на локальную машину
+func downloadProject(ctx context.Context, url, token string, projectID int, archivePath string) error {
+	fmt.Println("[DEBUG] downloadProject-> Start download project to local machine. Project ID: ", projectID)
+	// Создадим запрос на загрузку файла на локальную машину
+	resp, err := doRequest(ctx, "GET", fmt.Sprintf("%s/api/v4/projects/%d/export/download", url, projectID), token, nil, "")
 	if err != nil {
-		fmt.Println("[ERROR] Error downloading project:", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to download project: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 429 {
-		return errors.New("[WARNING] Network is buisy, retry automatic download")
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return errRateLimited
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("[ERROR] Failed to download project. Status: %d\n", resp.StatusCode)
-		os.Exit(1)
+		return fmt.Errorf("failed to download project. Status: %d", resp.StatusCode)
 	}
 	// Создадим файл для записи полученных данных с Gitlab-source
-	file, err := os.Create(fmt.Sprintf("%s.tar.gz", projectName))
+	file, err := os.Create(archivePath)
 	if err != nil {
-		fmt.Println("[ERROR] Error creating file:", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to create file: %w", err)
 	}
 	defer file.Close()
 	// Скопируем полученные из сети данные в созданный файл
 	if _, err := io.Copy(file, resp.Body); err != nil {
-		fmt.Println("[ERROR] Error saving file:", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to save file: %w", err)
 	}
-	fmt.Println("[SUCCESS] downloadProject<- Download complete: ", projectName)
+	fmt.Println("[SUCCESS] downloadProject<- Download complete: ", archivePath)
 	return nil
 }
 
 // Экспортируем проект
-func exportProject(url, token string, projectID int) {
+func exportProject(ctx context.Context, url, token string, projectID int) error {
 	fmt.Println("[DEBUG] exportProject-> Exporting project ID: ", projectID)
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v4/projects/%d/export", url, projectID), nil)
+	resp, err := doRequest(ctx, "POST", fmt.Sprintf("%s/api/v4/projects/%d/export", url, projectID), token, nil, "")
 	if err != nil {
-		fmt.Println("[ERROR] Error creating request:", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to export project: %w", err)
 	}
-	req.Header.Set("PRIVATE-TOKEN", token)
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return errRateLimited
 	}
-
-	// Создание HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("[ERROR] Error exporting project:", err)
-		os.Exit(1)
+	// Gitlab принимает экспорт в очередь и отвечает 202
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to schedule export. Status: %d, Response: %s", resp.StatusCode, string(body))
 	}
-	defer resp.Body.Close()
-	fmt.Println("[SUCCESS] exportProject<- Project exported: ", projectID)
+	fmt.Println("[SUCCESS] exportProject<- Project export scheduled: ", projectID)
+	return nil
 }
 
-// Импортирование проекта на Gitlab-destination
-func importProject(url, token string, projectName, groupPath string) {
-	fmt.Printf("[DEBUG] importProject-> Importing project: %s\n                 Path in group: %s\n", projectName, groupPath)
+// Импортирование проекта на Gitlab-destination. Возвращает ID созданного проекта
+func importProject(ctx context.Context, url, token string, archivePath, projectPath, groupPath string) (int, error) {
+	fmt.Printf("[DEBUG] importProject-> Importing project: %s\n                 Path in group: %s\n", projectPath, groupPath)
 	// ЧИтаем файл, который мы хотим импортировать
-	file, err := os.Open(fmt.Sprintf("%s.tar.gz", projectName))
+	file, err := os.Open(archivePath)
 	if err != nil {
-		fmt.Println("[ERROR] Error opening file:", err)
-		os.Exit(1)
+		return 0, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 	// Создадим канал для записи импортируемого файла
@@ -294,73 +606,134 @@ func importProject(url, token string, projectName, groupPath string) {
 	writer := multipart.NewWriter(pw)
 	// СОздадим анонимную горутину для записи файла по частям (ибо на выгрузку файла целиком может не хватить памяти оперативной)
 	go func() {
-		defer pw.Close()
 		part, err := writer.CreateFormFile("file", filepath.Base(file.Name()))
 		if err != nil {
-			fmt.Println("[ERROR] Error creating form file:", err)
-			os.Exit(1)
+			pw.CloseWithError(err)
+			return
 		}
 		if _, err := io.Copy(part, file); err != nil {
-			fmt.Println("[ERROR] Error copying file:", err)
-			os.Exit(1)
+			pw.CloseWithError(err)
+			return
 		}
-		writer.Close()
+		pw.CloseWithError(writer.Close())
 	}()
 	// СОздадим запрос на импорт файла записанного в pr
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v4/projects/import?path=%s&namespace=%s&overwrite=true", url, projectName, groupPath), pr)
+	query := neturl.Values{}
+	query.Set("path", projectPath)
+	query.Set("namespace", groupPath)
+	query.Set("overwrite", "true")
+	resp, err := doTransfer(ctx, "POST", fmt.Sprintf("%s/api/v4/projects/import?%s", url, query.Encode()), token, pr, writer.FormDataContentType())
 	if err != nil {
-		fmt.Println("[ERROR] Error creating request:", err)
-		os.Exit(1)
-	}
-	req.Header.Set("PRIVATE-TOKEN", token)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-
-	// Создание HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
-	// Выполним запрос и сохраним ответ
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("[ERROR] Error importing project:", err)
-		os.Exit(1)
+		return 0, fmt.Errorf("failed to import project: %w", err)
 	}
 	defer resp.Body.Close()
-	// Прочитаем тело ответа и выведем его
+	// Прочитаем тело ответа
 	respBody, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusCreated {
-		fmt.Printf("[ERROR] Failed to import project. Status: %d, Response: %s\n", resp.StatusCode, string(respBody))
-	} else {
-		fmt.Println("[SUCCESS] importProject<- Project imported successfully: ", projectName)
+		return 0, fmt.Errorf("failed to import project. Status: %d, Response: %s", resp.StatusCode, string(respBody))
+	}
+	var imported Project
+	if err := json.Unmarshal(respBody, &imported); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	fmt.Println("[SUCCESS] importProject<- Project import scheduled: ", projectPath)
+	return imported.ID, nil
+}
+
+// ImportResult -- состояние импорта проекта (GET /projects/:id/import)
+type ImportResult struct {
+	ImportStatus    string           `json:"import_status"`
+	ImportError     string           `json:"import_error"`
+	FailedRelations []FailedRelation `json:"failed_relations"`
+}
+
+// FailedRelation -- данные проекта (задачи, merge requests и т.п.), которые Gitlab не смог импортировать
+type FailedRelation struct {
+	RelationName     string `json:"relation_name"`
+	ExceptionClass   string `json:"exception_class"`
+	ExceptionMessage string `json:"exception_message"`
+	Source           string `json:"source,omitempty"`
+	LineNumber       int    `json:"line_number,omitempty"`
+}
+
+// errImportFailed -- Gitlab-destination не смог импортировать проект (статус failed)
+var errImportFailed = errors.New("import failed on Gitlab-destination")
+
+// waitForImport ждет окончания импорта проекта (импорт в gitlab асинхронный) и возвращает его
+// итог: import_error и failed_relations бывают и у завершенного импорта
+func waitForImport(ctx context.Context, url, token string, projectID int) (ImportResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeouts.Import)
+	defer cancel()
+	for {
+		var result ImportResult
+		if err := getJSON(ctx, fmt.Sprintf("%s/api/v4/projects/%d/import", url, projectID), token, &result); err != nil {
+			return result, fmt.Errorf("failed to check import status: %w", err)
+		}
+		fmt.Println("[DEBUG] waitForImport-> import status is: ", result.ImportStatus)
+		switch result.ImportStatus {
+		case "finished":
+			return result, nil
+		case "failed":
+			return result, fmt.Errorf("%w: %s", errImportFailed, result.ImportError)
+		}
+		if err := sleepContext(ctx, exportCheckPeriod); err != nil {
+			return result, fmt.Errorf("import was not finished on Gitlab-destination: %w", err)
+		}
+	}
+}
+
+// importArchive импортирует архив проекта на Gitlab-destination и ждет окончания импорта. Неудачный импорт
+// повторяется importRetries раз: с overwrite Gitlab создает проект заново. Итог последней попытки -- в отчете
+func importArchive(ctx context.Context, config Config, generalLogger *log.Logger, archivePath, projectDestPath, groupDest string, projectReport *ProjectReport) error {
+	for attempt := 0; ; attempt++ {
+		projectReport.ImportAttempts = attempt + 1
+		destProjectID, err := importProject(ctx, config.GitlabURLDest, config.PrivateTokenDest, archivePath, projectDestPath, groupDest)
+		if err != nil {
+			return err
+		}
+		result, err := waitForImport(ctx, config.GitlabURLDest, config.PrivateTokenDest, destProjectID)
+		projectReport.ImportError = result.ImportError
+		projectReport.FailedRelations = result.FailedRelations
+		if err == nil && len(result.FailedRelations) != 0 {
+			fmt.Printf("[WARNING] Project %s/%s imported without %d relations, see run report\n", groupDest, projectDestPath, len(result.FailedRelations))
+			generalLogger.Printf("[WARNING] Project %s/%s imported without %d relations, see run report\n", groupDest, projectDestPath, len(result.FailedRelations))
+		}
+		if !errors.Is(err, errImportFailed) || attempt >= config.ImportRetries {
+			return err
+		}
+		fmt.Printf("[WARNING] %v, retrying (%d of %d)\n", err, attempt+1, config.ImportRetries)
+		generalLogger.Printf("[WARNING] %v, retrying (%d of %d)\n", err, attempt+1, config.ImportRetries)
 	}
 }
 
 // Получим все проекты в конкретной группе
-func getProjectsFromGroup(generalLogger *log.Logger, url, token string, groupID int) []Project {
+func getProjectsFromGroup(ctx context.Context, generalLogger *log.Logger, url, token string, groupID int) []Project {
 	fmt.Println("[DEBUG] getProjectsFromGroup-> Getting projects from group ID: ", groupID)
 	generalLogger.Println("[DEBUG] getProjectsFromGroup-> Getting projects from group ID: ", groupID)
 	var projects []Project
 	for i := 1; i <= 10; i++ {
 		// Конструируем запрос
-		req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v4/groups/%d/projects?per_page=100&page=%d", url, groupID, i), nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v4/groups/%d/projects?statistics=true&per_page=100&page=%d", url, groupID, i), nil)
 		if err != nil {
 			fmt.Println("[ERROR] Error creating request:", err)
 			generalLogger.Println("[ERROR] Error creating request:", err)
 			os.Exit(1)
 		}
 		req.Header.Set("PRIVATE-TOKEN", token)
-		// Настройка транспорта с отключенной проверкой SSL-сертификатов
+		// Настройка транспорта с общими настройками TLS (см. setUpTLS)
 		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			TLSClientConfig: tlsClientConfig(),
 		}
 
 		// Создание HTTP-клиента с настраиваемым транспортом
-		client := &http.Client{Transport: tr}
+		client := &http.Client{Transport: tr, Timeout: operationTimeouts.API}
 		// Выполним запрос на получение проектов и сохраним ответ
 		resp, err := client.Do(req)
 		if err != nil {
+			// Запуск прерван -- main сохранит отметку и завершит программу сам
+			if ctx.Err() != nil {
+				return projects
+			}
 			fmt.Println("[ERROR] Error getting projects:", err)
 			generalLogger.Println("[ERROR] Error getting projects:", err)
 			os.Exit(1)
@@ -386,27 +759,31 @@ func getProjectsFromGroup(generalLogger *log.Logger, url, token string, groupID
 }
 
 // Парсим дерево подгрупп и выполняем аналогичные действия, действиям с root группами
-func parseSubgroupTree(config Config, generalLogger, corruptedLogger *log.Logger, IDSrc, parentIDDst int) {
+func parseSubgroupTree(ctx context.Context, config Config, generalLogger, corruptedLogger, findingsLogger *log.Logger, IDSrc, parentIDDst int) {
 	fmt.Println("[DEBUG]-> Subdirectory operations start")
 	// Получаем список подгрупп по ID
-	subgroups, err := getSubgroupsInGroup(generalLogger, fmt.Sprintf("%s/api/v4", config.GitlabURLSource), config.PrivateTokenSource, IDSrc)
+	subgroups, err := getSubgroupsInGroup(ctx, generalLogger, fmt.Sprintf("%s/api/v4", config.GitlabURLSource), config.PrivateTokenSource, IDSrc)
 	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
 		fmt.Println("[ERROR] Error fetching subgroups:", err)
 		generalLogger.Println("[ERROR] Error fetching subgroups:", err)
 		os.Exit(1)
 	}
 	// Пройдемся по каждой подгруппе
 	for _, subgroup := range subgroups {
-		importProjectClone(config, subgroup, generalLogger, corruptedLogger, parentIDDst)
-		// Вызовем объединенную функцию импортирования проектов
-		// importProcessArchive(subgroup, config, parentIDDst)
+		if ctx.Err() != nil {
+			return
+		}
+		importProjectClone(ctx, config, subgroup, generalLogger, corruptedLogger, findingsLogger, parentIDDst)
 	}
 	fmt.Println("[DEBUG]<- Subdirectory operations end")
 	generalLogger.Println("[DEBUG]<- Subdirectory operations end")
 }
 
 // Получим список корневых групп
-func getRootGroups(generalLogger *log.Logger, url, PrivateTokenSource string) ([]Group, error) {
+func getRootGroups(ctx context.Context, generalLogger *log.Logger, url, PrivateTokenSource string) ([]Group, error) {
 	fmt.Println("[DEBUG] getRootGroups-> Getting root groups list from Gitlab-source")
 	generalLogger.Println("[DEBUG] getRootGroups-> Getting root groups list from Gitlab-source")
 	var allGroups []Group
@@ -414,18 +791,18 @@ func getRootGroups(generalLogger *log.Logger, url, PrivateTokenSource string) ([
 	// Достроим URL
 	groupsURL := fmt.Sprintf("%s/api/v4/groups?per_page=100&page=1", url)
 	// Создадим запрос
-	req, err := http.NewRequest("GET", groupsURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", groupsURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("[ERROR] Failed to create request: %v", err)
 	}
 	req.Header.Set("Private-Token", PrivateTokenSource)
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
+	// Настройка транспорта с общими настройками TLS (см. setUpTLS)
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsClientConfig(),
 	}
 
 	// Создание HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: tr, Timeout: operationTimeouts.API}
 	// Выполним запрос
 	resp, err := client.Do(req)
 	if err != nil {
@@ -460,25 +837,25 @@ func getRootGroups(generalLogger *log.Logger, url, PrivateTokenSource string) ([
 }
 
 // Получаем список подгрупп
-func getSubgroupsInGroup(generalLogger *log.Logger, url, PrivateTokenSource string, parentID int) ([]Group, error) {
+func getSubgroupsInGroup(ctx context.Context, generalLogger *log.Logger, url, PrivateTokenSource string, parentID int) ([]Group, error) {
 	fmt.Println("[DEBUG] getSubgroupsInGroup-> Getting subgpoups into group ID=", parentID)
 	generalLogger.Println("[DEBUG] getSubgroupsInGroup-> Getting subgpoups into group ID=", parentID)
 	var subgroups []Group
 	// Конструируем корректный URL
 	subgroupsURL := fmt.Sprintf("%s/groups/%d/subgroups", url, parentID)
 	// Создаем запрос
-	req, err := http.NewRequest("GET", subgroupsURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", subgroupsURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 	req.Header.Set("Private-Token", PrivateTokenSource)
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
+	// Настройка транспорта с общими настройками TLS (см. setUpTLS)
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsClientConfig(),
 	}
 
 	// Создание HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: tr, Timeout: operationTimeouts.API}
 	// Выполним запрос и соххраним ответ
 	resp, err := client.Do(req)
 	if err != nil {
@@ -500,11 +877,11 @@ func getSubgroupsInGroup(generalLogger *log.Logger, url, PrivateTokenSource stri
 }
 
 // Создание группы
-func createGroup(generalLogger *log.Logger, url, token string, group Group, parentID int, parentIsRoot bool) int {
+func createGroup(ctx context.Context, generalLogger *log.Logger, url, token string, group Group, parentID int, parentIsRoot bool) int {
 	fmt.Println("[DEBUG] createGroup-> Creating group in Gitlab-destination: ", group.Name)
 	generalLogger.Println("[DEBUG] createGroup-> Creating group in Gitlab-destination: ", group.Name)
 	// Проверим, существует ли такая группа, если да -- вернем её ID и завершим функцию
-	existingGroup := getGroup(generalLogger, url, token, group.FullPath, parentID, parentIsRoot)
+	existingGroup := getGroup(ctx, generalLogger, url, token, group.FullPath, parentID, parentIsRoot)
 	if existingGroup != nil {
 		return existingGroup.ID
 	}
@@ -527,7 +904,7 @@ func createGroup(generalLogger *log.Logger, url, token string, group Group, pare
 		return parentID
 	}
 	// Построим запрос
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v4/groups", url), bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v4/groups", url), bytes.NewBuffer(jsonData))
 	if err != nil {
 		fmt.Println("[ERROR] Error creating request:", err)
 		generalLogger.Println("[ERROR] Error creating request:", err)
@@ -535,13 +912,13 @@ func createGroup(generalLogger *log.Logger, url, token string, group Group, pare
 	}
 	req.Header.Set("PRIVATE-TOKEN", token)
 	req.Header.Set("Content-Type", "application/json")
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
+	// Настройка транспорта с общими настройками TLS (см. setUpTLS)
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsClientConfig(),
 	}
 
 	// Создание HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: tr, Timeout: operationTimeouts.API}
 	// Выполним запрос на создание группы и сохраним ответ
 	resp, err := client.Do(req)
 	if err != nil {
@@ -571,7 +948,7 @@ func createGroup(generalLogger *log.Logger, url, token string, group Group, pare
 }
 
 // Получаем данные о существующей группы, или возвращаем nil, если таковой не существует
-func getGroup(generalLogger *log.Logger, url, token, fullPath string, parentID int, parentIsRoot bool) *Group {
+func getGroup(ctx context.Context, generalLogger *log.Logger, url, token, fullPath string, parentID int, parentIsRoot bool) *Group {
 	fmt.Println("[DEBUG] getGroup-> Getting existing group info")
 	generalLogger.Println("[DEBUG] getGroup-> Getting existing group info")
 	var err error
@@ -579,9 +956,9 @@ func getGroup(generalLogger *log.Logger, url, token, fullPath string, parentID i
 	client := &http.Client{}
 	// Конструируем разные запросы в зависимости от родительской группы (находится ли в корне или группе?)
 	if parentIsRoot {
-		req, err = http.NewRequest("GET", fmt.Sprintf("%s/api/v4/groups/%s", url, fullPath), nil)
+		req, err = http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v4/groups/%s", url, fullPath), nil)
 	} else {
-		req, err = http.NewRequest("GET", fmt.Sprintf("%s/api/v4/groups/%d/subgroups", url, parentID), nil)
+		req, err = http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v4/groups/%d/subgroups", url, parentID), nil)
 	}
 
 	if err != nil {
@@ -590,13 +967,13 @@ func getGroup(generalLogger *log.Logger, url, token, fullPath string, parentID i
 		return nil
 	}
 	req.Header.Set("PRIVATE-TOKEN", token)
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
+	// Настройка транспорта с общими настройками TLS (см. setUpTLS)
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsClientConfig(),
 	}
 
 	// Создание HTTP-клиента с настраиваемым транспортом
-	client = &http.Client{Transport: tr}
+	client = &http.Client{Transport: tr, Timeout: operationTimeouts.API}
 	// Выполним запрос и получим ответ
 	resp, err := client.Do(req)
 	if err != nil {
@@ -644,81 +1021,97 @@ func getGroup(generalLogger *log.Logger, url, token, fullPath string, parentID i
 	return nil
 }
 
-// Функция занимается полным процессом импорта проекта
-func importProcessArchive(group Group, config Config, corruptedLogger *log.Logger, generalLogger *log.Logger, parentGroupID int) {
-	fmt.Printf("[DEBUG] importProcessArchive-> Start importing group: %s; Path: %s\n", group.Name, group.FullPath)
-	generalLogger.Printf("[DEBUG] importProcessArchive-> Start importing group: %s; Path: %s\n", group.Name, group.FullPath)
-	// Заменим полный путь корневой группы из Gitlab-source, добавив директорию xxx-sync для импорта в Gitlab-destination
-	group.FullPath = fmt.Sprintf("%s/%s", xxxArea, group.FullPath)
-	// Создадим группу в корне
-	parentID := createGroup(generalLogger, config.GitlabURLDest, config.PrivateTokenDest, group, parentGroupID, false)
-	// Получим все проекты в группе из Gitlab-source
-	fmt.Println("[DEBUG] Group name to getting projects: ", group.Name)
-	generalLogger.Println("[DEBUG] Group name to getting projects: ", group.Name)
-	projects := getProjectsFromGroup(generalLogger, config.GitlabURLSource, config.PrivateTokenSource, group.ID)
-	// Пройдемся по всем полученым проектам
-ProjectLoop:
-	for _, project := range projects {
-		// Экспортируем проект (да, без этого мы не сможем его загрузить на локальную машину)
-		fmt.Println("[DEBUG] Project name to export: ", project.Name)
-		generalLogger.Println("[DEBUG] Project name to export: ", project.Name)
-		exportProject(config.GitlabURLSource, config.PrivateTokenSource, project.ID)
-		// Проверим, экспортировался проект или нет, если нет, то подождем 5 сек
-		// если проект по каким-то причинам не может быть экспортирован (покаррапчен, ибо в таком случае
-		// и clone работать не будет), то преррываем этот проект перейдя к следующему
-		// Количество попыток для ошибочного вызова со статусом none
-		try := 0
-		for {
-			finished, status := isExportFinished(config.GitlabURLSource, config.PrivateTokenSource, project.ID)
-			if finished {
-				break
-			}
-			if status == "none" && try > 15 {
-				fmt.Printf("[ERROR] Export not can be finished, observe project: %s ID: %d\n", project.Name, project.ID)
-				// Пишем логи
-				corruptedLogger.Printf("Project currupted: %d;%s\n", project.ID, project.Name)
-				continue ProjectLoop
-			}
-			time.Sleep(exportCheckPeriod)
-			try++
-		}
-		// Далее будет загрузка на локальный пк проекта. Цикл необходим для корректной загрузки во избежании
-		// ошибки http 429 (слишком частные запросы к ресурсу)
-		for {
-			// Загружаем проект на локальную машину и проверяем ошибку http 429
-			warn := downloadProject(config.GitlabURLSource, config.PrivateTokenSource, project.ID, project.Name)
-			if warn == nil {
-				// Если ошибки нет, завершаем загрузку. Если есть -- ждем 5 сек, и после повторяем загрузку
-				break
-			}
-			fmt.Println(warn)
-			time.Sleep(exportCheckPeriod)
+// importProjectArchive переносит проект через экспорт/импорт архива по полному пути destPath на Gitlab-destination
+func importProjectArchive(ctx context.Context, config Config, generalLogger *log.Logger, project Project, destPath string, projectReport *ProjectReport) error {
+	fmt.Printf("[DEBUG] importProjectArchive-> Start importing project: %s; Path: %s\n", project.Name, destPath)
+	generalLogger.Printf("[DEBUG] importProjectArchive-> Start importing project: %s; Path: %s\n", project.Name, destPath)
+	// Экспортируем проект (да, без этого мы не сможем его загрузить на локальную машину) и дождемся окончания
+	// экспорта. Если проект не может быть экспортирован (покаррапчен, ибо в таком случае и clone работать
+	// не будет), то вернем ошибку и перейдем к следующему проекту
+	if err := config.exports.wait(ctx, project.ID); err != nil {
+		return err
+	}
+	// Архив кладем в отдельную директорию, чтобы проекты с одинаковыми именами из разных групп не пересекались
+	archiveDir, err := os.MkdirTemp(tmpDir, fmt.Sprintf("export-%d-", project.ID))
+	if err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	defer os.RemoveAll(archiveDir)
+	archivePath := filepath.Join(archiveDir, project.Path+".tar.gz")
+	// Далее будет загрузка на локальный пк проекта. Цикл необходим для корректной загрузки во избежании
+	// ошибки http 429 (слишком частные запросы к ресурсу)
+	for {
+		err := downloadProject(ctx, config.GitlabURLSource, config.PrivateTokenSource, project.ID, archivePath)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errRateLimited) {
+			return err
+		}
+		fmt.Println(err)
+		if err := sleepContext(ctx, exportCheckPeriod); err != nil {
+			return err
 		}
-		// Импортируем проект (выгружаем его) на Gitlab-destination
-		importProject(config.GitlabURLDest, config.PrivateTokenDest, project.Name, group.FullPath)
-		// На этом этапе с корневыми проектами и группами покончено
-		// необходимость разделять на корневые проекты (точнее проекты находящиеся в группах, лежащих в корне)
-		// появляется из-за разности в запросах на группы в корне (группы) между запросами на группы в группах (подгруппы)
 	}
-	// Создаем дерево подгрупп и импортируем проекты из подгрупп
-	parseSubgroupTree(config, generalLogger, corruptedLogger, group.ID, parentID)
-	fmt.Printf("[SUCCESS] importProcessArchive<- End of importing group: %s; Path: %s\n", group.Name, group.FullPath)
-	generalLogger.Printf("[SUCCESS] importProcessArchive<- End of importing group: %s; Path: %s\n", group.Name, group.FullPath)
+	// Импортируем проект (выгружаем его) на Gitlab-destination и дождемся окончания импорта
+	groupDest, projectDestPath := splitDestPath(destPath)
+	if err := importArchive(ctx, config, generalLogger, archivePath, projectDestPath, groupDest, projectReport); err != nil {
+		return err
+	}
+	fmt.Printf("[SUCCESS] importProjectArchive<- End of importing project: %s; Path: %s\n", project.Name, destPath)
+	generalLogger.Printf("[SUCCESS] importProjectArchive<- End of importing project: %s; Path: %s\n", project.Name, destPath)
+	return nil
+}
+
+// transferModeFor возвращает способ переноса для проекта или группы по полному пути на Gitlab-source:
+// сначала ищется точное совпадение, затем ближайшая родительская группа, иначе -- transferMode из конфигурации
+func transferModeFor(config Config, fullPath string) string {
+	for path := fullPath; path != ""; {
+		if mode, ok := config.TransferModes[path]; ok {
+			return mode
+		}
+		slash := strings.LastIndex(path, "/")
+		if slash < 0 {
+			break
+		}
+		path = path[:slash]
+	}
+	if config.TransferMode != "" {
+		return config.TransferMode
+	}
+	return transferModeClone
+}
+
+// checkArchiveMode проверяет, можно ли перенести проект через экспорт/импорт архива. Архив экспорта
+// не проходит через проверку на секреты и перезапись истории, поэтому с secretScanPolicy block и quarantine,
+// historyFilters и перезаписью авторов такой перенос запрещен. С report проект переносится непроверенным
+// (см. noteNotScanned)
+func checkArchiveMode(config Config, fullPath string) error {
+	if config.SecretScanPolicy != "" && config.SecretScanPolicy != secretPolicyReport {
+		return fmt.Errorf("archive mode is not allowed with secretScanPolicy %s: exported archives are not scanned for secrets", config.SecretScanPolicy)
+	}
+	if _, ok := historyFilterFor(config, fullPath); ok {
+		return errors.New("archive mode is not allowed with historyFilters: history of exported archives is not rewritten")
+	}
+	if config.IdentityMailmap != "" || config.IdentityHash {
+		return errors.New("archive mode is not allowed with identityMailmap or identityHash: authors of exported archives are not rewritten")
+	}
+	return nil
 }
 
 // Функция для удаления группы
-func deleteGitLabGroup(GitlabURLDest, PrivateTokenDest string, groupID int) error {
+func deleteGitLabGroup(ctx context.Context, GitlabURLDest, PrivateTokenDest string, groupID int) error {
 	fmt.Println("[DEBUG] deleteGitLabGroup-> Removing group ID: ", groupID)
 	url := fmt.Sprintf("%s/api/v4/groups/%d", GitlabURLDest, groupID)
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
 		return fmt.Errorf("[ERROR] Error with creating request DELETE: %w", err)
 	}
 
 	req.Header.Set("Private-Token", PrivateTokenDest)
 
-	client := &http.Client{}
+	client := &http.Client{Timeout: operationTimeouts.API}
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("[ERROR] Error with creating request DELETE: %w", err)
@@ -734,107 +1127,115 @@ func deleteGitLabGroup(GitlabURLDest, PrivateTokenDest string, groupID int) erro
 }
 
 // cloneRepo клонирует репозиторий с исходного Gitlab
-func cloneRepo(generalLogger, corruptedLogger *log.Logger, repoURL, destDir string) error {
-	cmd := exec.Command("git", "clone", "--mirror", repoURL, destDir)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+func cloneRepo(ctx context.Context, generalLogger, corruptedLogger *log.Logger, mirror GitMirror, repoURL, destDir string) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeouts.Clone)
+	defer cancel()
+	if err := mirror.Clone(ctx, repoURL, destDir); err != nil {
 		fmt.Printf("[ERROR] Failed to clone repository: %v\n", err)
 		generalLogger.Printf("[ERROR] Failed to clone repository: %v\n", err)
 		corruptedLogger.Printf("Cloning currupted, URL: %s\n", repoURL)
 		return err
 	}
-
-	// Стянуть все LFS объекты
-	lfsCmd := exec.Command("git", "-C", destDir, "lfs", "fetch", "--all")
-	lfsCmd.Stdout = os.Stdout
-	lfsCmd.Stderr = os.Stderr
-	if err := lfsCmd.Run(); err != nil {
-		// Временно поставил nil, но нужно что-то с этим придумать
-		corruptedLogger.Println("LFS currupted, URL: ", repoURL)
-		return err
-	}
-
+	// LFS объекты скачиваются отдельным этапом (fetchLFS)
 	return nil
 }
 
-// pushRepo пушит репозиторий на удалённый Gitlab
-func pushRepo(generalLogger *log.Logger, repoDir, newRepoURL string) error {
-	// Создание новой переменной окружения только для текущего процесса
-	// env := os.Environ()
-	// env = append(env, remoteSSHJump)
-	// Пушим все LFS объекты
-	// lfsCmd := exec.Command("git", "-c", "http.sslVerify=false", "-C", repoDir, "lfs", "push", "--all", newRepoURL)
-	lfsCmd := exec.Command("git", "-C", repoDir, "lfs", "push", "--all", newRepoURL)
-	// lfsCmd := exec.Command("bash", "-c", fmt.Sprintf("GIT_SSH_COMMAND='%s' git -C %s lfs push --all %s", remoteSSHJump, repoDir, newRepoURL))
-	// lfsCmd.Env = env
-	lfsCmd.Stdout = os.Stdout
-	lfsCmd.Stderr = os.Stderr
-	if err := lfsCmd.Run(); err != nil {
-		fmt.Printf("[WARNING] Failed to push lfs: %v\n", err)
-		generalLogger.Printf("[WARNING] Failed to push lfs: %v\n", err)
-		// Временная мера
-		return nil
+// pushRepo пушит ссылки репозитория из пространств namespaces (пусто -- ветки и теги) на удалённый Gitlab
+// пачками по batchSize ссылок, а с chunkCommits -- сначала историю веток частями (pushHistoryChunks),
+// пропуская то, что уже запушено прошлыми запусками (pushed: ссылка -> SHA). Пушатся все ссылки, даже
+// если часть отклонена; возвращает результат по каждой и ошибку, если отклонена хоть одна
+func pushRepo(ctx context.Context, generalLogger *log.Logger, mirror GitMirror, repoDir, newRepoURL string, namespaces []RefNamespace, batchSize, chunkCommits int, pushed map[string]string) ([]RefPushResult, error) {
+	// LFS объекты пушатся отдельным этапом (pushLFS)
+	if len(namespaces) == 0 {
+		namespaces = defaultRefNamespaces
 	}
 
-	// Получаем список всех веток
-	branchesCmd := exec.Command("git", "-C", repoDir, "for-each-ref", "--format=%(refname)", "refs/heads/")
-	branchesOutput, err := branchesCmd.Output()
-	if err != nil {
-		fmt.Printf("[ERROR] Failed to get all branches: %v\n", err)
-		generalLogger.Printf("[ERROR] Failed to get all branches: %v\n", err)
-		return err
+	// Получаем список ссылок всех пространств
+	var prefixes []string
+	for _, namespace := range namespaces {
+		prefixes = append(prefixes, namespace.listPrefix())
 	}
-	branches := strings.Fields(string(branchesOutput))
-
-	// Получаем список всех тегов
-	tagsCmd := exec.Command("git", "-C", repoDir, "for-each-ref", "--format=%(refname)", "refs/tags/")
-	tagsOutput, err := tagsCmd.Output()
+	refs, err := mirror.ListRefs(ctx, repoDir, prefixes...)
 	if err != nil {
-		fmt.Printf("[ERROR] Failed get all tags: %v\n", err)
-		generalLogger.Printf("[ERROR] Failed get all tags: %v\n", err)
-		return err
-	}
-	tags := strings.Fields(string(tagsOutput))
-
-	// Пушим все ветки
-	for _, branch := range branches {
-		cmd := exec.Command("git", "-C", repoDir, "push", newRepoURL, branch, "--force")
-		// cmd.Env = env
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			fmt.Printf("[ERROR] Failed to push branch: %s; error: %v\n", branch, err)
-			generalLogger.Printf("[ERROR] Failed to push branch: %s; error: %v\n", branch, err)
-			// Временно
-			return nil
+		fmt.Printf("[ERROR] Failed to get refs: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to get refs: %v\n", err)
+		return nil, err
+	}
+
+	// Ссылки пушатся в порядке пространств, ветки первыми
+	mappings := mapRefs(refs, namespaces)
+	if chunkCommits > 0 {
+		fmt.Printf("[DEBUG] Pushing history in chunks of %d commits\n", chunkCommits)
+		generalLogger.Printf("[DEBUG] Pushing history in chunks of %d commits\n", chunkCommits)
+		chunkRefs := pushHistoryChunks(ctx, generalLogger, mirror, repoDir, newRepoURL, mappings, chunkCommits, pushed)
+		// Временные ссылки удаляются только после пуша веток: пока они есть, git не передает их историю повторно
+		defer deleteChunkRefs(ctx, generalLogger, mirror, repoDir, newRepoURL, chunkRefs)
+	}
+	results := pushRefsBatched(ctx, generalLogger, mirror, repoDir, newRepoURL, mappings, batchSize)
+	failed := 0
+	for _, result := range results {
+		if result.Status != pushStatusOK {
+			failed++
+			fmt.Printf("[ERROR] Failed to push ref: %s; status: %s; reason: %s\n", result.Ref, result.Status, result.Reason)
+			generalLogger.Printf("[ERROR] Failed to push ref: %s; status: %s; reason: %s\n", result.Ref, result.Status, result.Reason)
 		}
 	}
+	if failed != 0 {
+		return results, fmt.Errorf("%d of %d refs were not pushed", failed, len(results))
+	}
+	return results, nil
+}
 
-	// Пушим все теги
-	for _, tag := range tags {
-		cmd := exec.Command("git", "-C", repoDir, "push", newRepoURL, tag, "--force")
-		// cmd := exec.Command("sh", "-c", fmt.Sprintf("GIT_SSH_COMMAND='%s' git -C %s push %s %s", remoteSSHJump, repoDir, newRepoURL, tag))
-		// cmd.Env = env
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			fmt.Printf("[ERROR] Failed to push tag: %s; error: %v\n", tag, err)
-			generalLogger.Printf("[ERROR] Failed to push tag: %s; error: %v\n", tag, err)
-			return err
+// pushRefsBatched принудительно пушит ссылки пачками по batchSize в одном git push. Если пачка отклонена
+// или не дошла (ошибка соединения, размер пуша), её незапушенные ссылки пушатся по одной, чтобы найти отклоненные
+func pushRefsBatched(ctx context.Context, generalLogger *log.Logger, mirror GitMirror, repoDir, newRepoURL string, refs []RefMapping, batchSize int) []RefPushResult {
+	if batchSize <= 0 {
+		batchSize = pushDefaultBatchSize
+	}
+	var results []RefPushResult
+	for start := 0; start < len(refs); start += batchSize {
+		end := start + batchSize
+		if end > len(refs) {
+			end = len(refs)
+		}
+		batch := refs[start:end]
+		refspecs := make([]string, 0, len(batch))
+		for _, ref := range batch {
+			refspecs = append(refspecs, "+"+ref.Source+":"+ref.Dest)
+		}
+		pushCtx, cancel := context.WithTimeout(ctx, operationTimeouts.Push)
+		batchResults, batchErr := mirror.PushRefs(pushCtx, repoDir, newRepoURL, refspecs)
+		cancel()
+		var pending []RefMapping
+		for _, ref := range batch {
+			result, ok := batchResults[ref.Dest]
+			switch {
+			case ok && result.Status == pushStatusOK:
+			case !ok && batchErr == nil:
+				result = RefPushResult{Status: pushStatusOK}
+			case len(batch) == 1:
+				if !ok {
+					result = RefPushResult{Status: classifyPushRejection("", batchErr.Error()), Reason: batchErr.Error()}
+				}
+			default:
+				// pre-receive хук Gitlab отклоняет пуш целиком, поэтому отклоненную в пачке ссылку
+				// проверим отдельно: возможно, она отклонена из-за соседней
+				pending = append(pending, ref)
+				continue
+			}
+			result.Ref, result.SHA = ref.Dest, ref.SHA
+			results = append(results, result)
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		fmt.Printf("[WARNING] Batch push of %d refs rejected, pushing one by one: %v\n", len(pending), batchErr)
+		generalLogger.Printf("[WARNING] Batch push of %d refs rejected, pushing one by one: %v\n", len(pending), batchErr)
+		for _, ref := range pending {
+			results = append(results, pushRefsBatched(ctx, generalLogger, mirror, repoDir, newRepoURL, []RefMapping{ref}, 1)...)
 		}
 	}
-
-	return nil
-	// // Пушим репозиторий
-	// cmd := exec.Command("git", "-C", repoDir, "push", "--mirror", newRepoURL)
-	// cmd.Stdout = os.Stdout
-	// cmd.Stderr = os.Stderr
-	// if err := cmd.Run(); err != nil {
-	// 	return err
-	// }
-
-	// return nil
+	return results
 }
 
 // cleanUp полностью очищает ЛОКАЛЬНЫЙ репозиторий
@@ -848,15 +1249,10 @@ func cleanUp(dir string) error {
 }
 
 // importProjectClone импортирует проекты путём клонирования/пуша
-func importProjectClone(config Config, group Group, generalLogger, corruptedLogger *log.Logger, parentGroupID int) {
+func importProjectClone(ctx context.Context, config Config, group Group, generalLogger, corruptedLogger, findingsLogger *log.Logger, parentGroupID int) {
 	fmt.Printf("[DEBUG] importProjectClone-> Start importing group: %s; Path: %s\n", group.Name, group.FullPath)
-	// Устанавливаем удаленный порт в зависимости от получателя (у xxx это 22, а резервация -- 2222)
-	destSSHPortPostfix := "2222"
-	if config.GitlabURLDest == destAddress {
-		destSSHPortPostfix = "22"
-	}
 	// Фильтруем группы и подгруппы, которые хотим переносить на Gtilab destination
-	badge, _ := getBadge(config.GitlabURLSource, config.PrivateTokenSource, group.ID)
+	badge, _ := getBadge(ctx, config.GitlabURLSource, config.PrivateTokenSource, group.ID)
 	// if config.GitlabURLDest == reservationAddress && badge == "private" {
 	// 	return
 	// } else if config.GitlabURLDest == destAddress && badge != "xxx" {
@@ -867,38 +1263,123 @@ func importProjectClone(config Config, group Group, generalLogger, corruptedLogg
 	}
 	// Создадим группу на удаленном Gitlab, если это не xxx-sync, воизбежании рекурсивного создани директории xxx-sync
 	var parentID int
+	groupDest := config.paths.mapPath(group.FullPath)
 	if group.Path == xxxArea {
 		parentID = parentGroupID
+	} else if groupDest == destGroupPath(config, group.FullPath) {
+		parentID = createGroup(ctx, generalLogger, config.GitlabURLDest, config.PrivateTokenDest, group, parentGroupID, false)
 	} else {
-		parentID = createGroup(generalLogger, config.GitlabURLDest, config.PrivateTokenDest, group, parentGroupID, false)
+		// Группа переименована правилами -- создадим недостающие группы по новому пути
+		var err error
+		parentID, err = ensureGroupPath(ctx, config, generalLogger, groupDest)
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to create group %s: %v\n", groupDest, err)
+			generalLogger.Printf("[ERROR] Failed to create group %s: %v\n", groupDest, err)
+			return
+		}
+	}
+	// Перенесем участников группы
+	if config.MembersSync {
+		if unmatched := syncGroupMembers(ctx, config, generalLogger, group.ID, parentID); len(unmatched) != 0 {
+			config.report.addGroup(&GroupReport{Group: group.FullPath, UnmatchedMembers: unmatched})
+		}
 	}
 	// Применим бэйдж из исходного Gitlab на удаленный
 	if badge != "" && config.GitlabURLDest != destAddress {
 		// проверим установлен ли уже бейдж
-		existingBadge, _ := getBadge(config.GitlabURLDest, config.PrivateTokenDest, parentID)
+		existingBadge, _ := getBadge(ctx, config.GitlabURLDest, config.PrivateTokenDest, parentID)
 		// И если бейдж не установлен, установим
 		if existingBadge == "" {
-			setBadge(config.GitlabURLDest, config.PrivateTokenDest, badge, parentID)
+			setBadge(ctx, config.GitlabURLDest, config.PrivateTokenDest, badge, parentID)
 		}
 	}
 	// Получим все проекты в группе из Gitlab-source
 	fmt.Println("[DEBUG] Group name to getting projects: ", group.Name)
 	generalLogger.Println("[DEBUG] Group name to getting projects: ", group.Name)
-	projects := getProjectsFromGroup(generalLogger, config.GitlabURLSource, config.PrivateTokenSource, group.ID)
+	projects := getProjectsFromGroup(ctx, generalLogger, config.GitlabURLSource, config.PrivateTokenSource, group.ID)
+	// Экспорты проектов группы, переносимых архивом, идут параллельно с переносом остальных
+	config.exports.prefetch(ctx, archiveProjectIDs(config, projects))
 	// Пройдемся по всем полученым проектам
 	for _, project := range projects {
-		// Заменим все пробьелы дефисом в имени проекта
-		project.Name = strings.ReplaceAll(project.Name, " ", "-")
-		// Привдем к раочему виду строки для адресов репозиториев в соответствии с ssh форматом
-		modifiedGitlabURLSource := strings.TrimPrefix(config.GitlabURLSource, "https://")
-		modifiedGitlabURLDest := strings.TrimPrefix(config.GitlabURLDest, "https://")
-		// modifiedGitlabURLDest = strings.TrimPrefix(config.GitlabURLDest, "http://")
-		sourceRepoURL := fmt.Sprintf("ssh://git@%s:2222/%s/%s.git", modifiedGitlabURLSource, group.FullPath, project.Name)
-		groupDest := xxxArea + "/" + group.FullPath
-		if config.GitlabURLDest == destAddress {
-			groupDest = group.FullPath
-		}
-		destRepoURL := fmt.Sprintf("ssh://git@%s:%s/%s/%s.git", modifiedGitlabURLDest, destSSHPortPostfix, groupDest, project.Name)
+		// Прерванный запуск не начинает перенос следующего проекта
+		if ctx.Err() != nil {
+			return
+		}
+		// Адреса строим по пути проекта (path_with_namespace), а не по отображаемому имени
+		sourcePath := project.PathWithNamespace
+		sourceRepoURL := buildSourceRepoURL(config, project)
+		destPath := config.paths.mapPath(sourcePath)
+		projectGroupDest, projectDestPath := splitDestPath(destPath)
+		// Запишем результат переноса проекта в отчет
+		projectReport := &ProjectReport{
+			Project: sourcePath,
+			Mode:    transferModeFor(config, sourcePath),
+			Status:  statusSuccess,
+		}
+		config.report.add(projectReport)
+		// Проверим, что после переименования путь не занят другим проектом, и создадим его группу
+		err := config.paths.claim(ctx, config, sourcePath, destPath)
+		if err == nil && projectGroupDest != groupDest {
+			_, err = ensureGroupPath(ctx, config, generalLogger, projectGroupDest)
+		}
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to map project path: %v\n", err)
+			generalLogger.Printf("[ERROR] Failed to map project path: %v\n", err)
+			projectReport.Status = statusFailed
+			projectReport.Error = err.Error()
+			continue
+		}
+		// Проверим размер репозитория до клонирования или экспорта
+		projectReport.RepositorySize = project.Statistics.RepositorySize
+		if err := checkProjectSize(config, project); errors.Is(err, errRepositoryTooLarge) {
+			fmt.Printf("[WARNING] Skipping project %s: %v\n", sourcePath, err)
+			generalLogger.Printf("[WARNING] Skipping project %s: %v\n", sourcePath, err)
+			projectReport.Status = statusSkipped
+			projectReport.Error = err.Error()
+			continue
+		} else if err != nil {
+			fmt.Printf("[ERROR] Failed to check project size: %v\n", err)
+			generalLogger.Printf("[ERROR] Failed to check project size: %v\n", err)
+			projectReport.Status = statusFailed
+			projectReport.Error = err.Error()
+			continue
+		}
+		// Перенесем проект через экспорт/импорт архива, если так задано для проекта или его группы
+		if projectReport.Mode == transferModeArchive {
+			err := checkArchiveMode(config, sourcePath)
+			if err == nil {
+				noteNotScanned(config, findingsLogger, sourcePath)
+				err = importProjectArchive(ctx, config, generalLogger, project, destPath, projectReport)
+			}
+			if err == nil {
+				syncProjectExtras(ctx, config, generalLogger, project, destPath, projectReport)
+				continue
+			}
+			fmt.Printf("[ERROR] Failed to import project archive: %v\n", err)
+			generalLogger.Printf("[ERROR] Failed to import project archive: %v\n", err)
+			// Через clone переносим только то, что Gitlab-destination не смог импортировать: ошибки экспорта
+			// и проверок конфигурации clone не исправит
+			if !config.ImportFallbackClone || !errors.Is(err, errImportFailed) || ctx.Err() != nil {
+				corruptedLogger.Printf("Project currupted: %d;%s\n", project.ID, project.Name)
+				projectReport.Status = statusFailed
+				projectReport.Error = err.Error()
+				continue
+			}
+			// Перенесем хотя бы репозиторий: через clone переносится только git, без задач и merge requests.
+			// Проект после неудачного импорта уже есть на Gitlab-destination, пушим в него
+			fmt.Printf("[WARNING] Falling back to clone for project %s, pushing into project %s left by failed import\n", sourcePath, destPath)
+			generalLogger.Printf("[WARNING] Falling back to clone for project %s, pushing into project %s left by failed import\n", sourcePath, destPath)
+			projectReport.FallbackClone = true
+			projectReport.ReusedProject = true
+		}
+		// Пустой репозиторий нечего клонировать: проект на Gitlab-destination создается только пушем
+		if project.EmptyRepo {
+			fmt.Printf("[WARNING] Repository is empty, skipping: %s\n", sourcePath)
+			generalLogger.Printf("[WARNING] Repository is empty, skipping: %s\n", sourcePath)
+			projectReport.Status = statusSkipped
+			continue
+		}
+		destRepoURL := buildDestRepoURL(config, projectGroupDest, projectDestPath)
 		repoName := filepath.Base(sourceRepoURL)
 		repoName = repoName[:len(repoName)-len(filepath.Ext(repoName))]
 		//  Зададим имя репозитория
@@ -906,19 +1387,100 @@ func importProjectClone(config Config, group Group, generalLogger, corruptedLogg
 		// Скопируем репозиторий с Gitlab-source
 		fmt.Printf("[DEBUG] Cloning repository from %s...\n", sourceRepoURL)
 		generalLogger.Printf("[DEBUG] Cloning repository from %s...\n", sourceRepoURL)
-		if err := cloneRepo(generalLogger, corruptedLogger, sourceRepoURL, tempRepoDir); err != nil {
+		if err := cloneRepo(ctx, generalLogger, corruptedLogger, config.gitSource, sourceRepoURL, tempRepoDir); err != nil {
 			fmt.Printf("[ERROR] Failed to clone repository: %v\n", err)
 			generalLogger.Printf("[ERROR] Failed to clone repository: %v\n", err)
+			projectReport.Status = statusFailed
+			projectReport.Error = err.Error()
+			continue
+		}
+		// Удалим из истории то, что не должно попасть на Gitlab-destination, и перепишем авторов
+		if filter, ok := historyFilterFor(config, projectReport.Project); ok || config.identities != nil {
+			err := rewriteHistory(generalLogger, newHistoryRewriter(filter, config.identities), tempRepoDir, projectReport.Project)
+			if err == nil && config.identities != nil {
+				err = config.state.persist()
+			}
+			if err != nil {
+				fmt.Printf("[ERROR] Failed to rewrite repository history: %v\n", err)
+				generalLogger.Printf("[ERROR] Failed to rewrite repository history: %v\n", err)
+				projectReport.Status = statusFailed
+				projectReport.Error = err.Error()
+				if err := cleanUp(tmpDir); err != nil {
+					fmt.Printf("[ERROR] Failed to clean up: %v\n", err)
+					generalLogger.Printf("[ERROR] Failed to clean up: %v\n", err)
+				}
+				continue
+			}
+		}
+		// Без LFS объектов проект перенесся бы неполным
+		if err := checkLFSSupported(config, tempRepoDir); err != nil {
+			fmt.Printf("[ERROR] Failed to transfer LFS objects: %v\n", err)
+			generalLogger.Printf("[ERROR] Failed to transfer LFS objects: %v\n", err)
+			projectReport.Status = statusFailed
+			projectReport.Error = err.Error()
+			if err := cleanUp(tmpDir); err != nil {
+				fmt.Printf("[ERROR] Failed to clean up: %v\n", err)
+				generalLogger.Printf("[ERROR] Failed to clean up: %v\n", err)
+			}
+			continue
+		}
+		// Скачаем LFS объекты отдельным этапом: их ошибки не прерывают пуш веток, но проект будет помечен неудачным
+		projectReport.LFS = fetchLFS(ctx, config, generalLogger, corruptedLogger, tempRepoDir, sourceRepoURL)
+		// Проверим историю на секреты до того, как что-либо попадет на Gitlab-destination
+		if !secretGate(config, generalLogger, findingsLogger, tempRepoDir, projectReport.Project, projectReport) {
+			if err := cleanUp(tmpDir); err != nil {
+				fmt.Printf("[ERROR] Failed to clean up: %v\n", err)
+				generalLogger.Printf("[ERROR] Failed to clean up: %v\n", err)
+			}
 			continue
 		}
+		// LFS объекты пушим до веток: gitlab может отклонить ветки, ссылающиеся на отсутствующие объекты
+		var lfsErr error
+		if projectReport.LFS.Objects != 0 {
+			lfsErr = pushLFS(ctx, config, generalLogger, tempRepoDir, destRepoURL, destPath, projectReport.LFS)
+		}
 		// Запушим склонированный репозиторий на удаленный Gitlab-destination
 		fmt.Printf("[DEBUG] Pushing repository to %s...\n", destRepoURL)
 		generalLogger.Printf("[DEBUG] Pushing repository to %s...\n", destRepoURL)
-		if err := pushRepo(generalLogger, tempRepoDir, destRepoURL); err != nil {
+		refResults, pushErr := pushRepo(ctx, generalLogger, config.gitDest, tempRepoDir, destRepoURL, config.refNamespaces, config.PushBatchSize, pushChunkCommits(config, tempRepoDir), config.state.pushedRefs(destPath))
+		projectReport.addRefResults(refResults)
+		if err := config.state.markRefsPushed(destPath, refResults); err != nil {
+			fmt.Printf("[ERROR] Failed to save sync state: %v\n", err)
+			generalLogger.Printf("[ERROR] Failed to save sync state: %v\n", err)
+		}
+		// Новый проект создается только пушем веток, поэтому неудачный push LFS повторим после него
+		if lfsErr != nil {
+			lfsErr = pushLFS(ctx, config, generalLogger, tempRepoDir, destRepoURL, destPath, projectReport.LFS)
+		}
+		if lfsErr != nil {
+			projectReport.LFS.Error = lfsErr.Error()
+		}
+		if err := pushErr; err != nil {
+			// Отклоненные ссылки не мешают остальным проектам: они перечислены в отчете
 			fmt.Printf("[ERROR] Failed to push repository: %v\n", err)
 			generalLogger.Printf("[ERROR] Failed to push repository: %v\n", err)
-			os.Exit(1)
+			projectReport.Status = statusFailed
+			projectReport.Error = err.Error()
+			if err := cleanUp(tmpDir); err != nil {
+				fmt.Printf("[ERROR] Failed to clean up: %v\n", err)
+				generalLogger.Printf("[ERROR] Failed to clean up: %v\n", err)
+			}
+			continue
+		}
+		// Ветки без части LFS объектов уже запушены, но проект перенесен не полностью: повторный запуск дольет объекты
+		if err := lfsIncomplete(projectReport.LFS, lfsErr); err != nil {
+			fmt.Printf("[ERROR] Failed to transfer LFS objects: %v\n", err)
+			generalLogger.Printf("[ERROR] Failed to transfer LFS objects: %v\n", err)
+			projectReport.Status = statusFailed
+			projectReport.Error = err.Error()
+			if err := cleanUp(tmpDir); err != nil {
+				fmt.Printf("[ERROR] Failed to clean up: %v\n", err)
+				generalLogger.Printf("[ERROR] Failed to clean up: %v\n", err)
+			}
+			continue
 		}
+		// Перенесем данные проекта, которые не передаются через git
+		syncProjectExtras(ctx, config, generalLogger, project, destPath, projectReport)
 		// Очистим директорию с локальным репозиторием
 		fmt.Printf("[DEBUG] Cleaning up temporary files...\n")
 		generalLogger.Printf("[DEBUG] Cleaning up temporary files...\n")
@@ -931,14 +1493,14 @@ func importProjectClone(config Config, group Group, generalLogger, corruptedLogg
 		generalLogger.Println("[SUCCESS] Repository transfer complete!")
 	}
 	// А Это мы выставляем разрешение на force push
-	destinationProjects := getProjectsFromGroup(generalLogger, config.GitlabURLDest, config.PrivateTokenDest, parentID)
+	destinationProjects := getProjectsFromGroup(ctx, generalLogger, config.GitlabURLDest, config.PrivateTokenDest, parentID)
 	for _, destProject := range destinationProjects {
-		defaultBranchName, err := getProjectDefaultBranch(config.GitlabURLDest, config.PrivateTokenDest, destProject.ID)
+		defaultBranchName, err := getProjectDefaultBranch(ctx, config.GitlabURLDest, config.PrivateTokenDest, destProject.ID)
 		if err != nil {
 			fmt.Printf("[ERROR] Failed to getting default project branch name: %v\n", err)
 			generalLogger.Printf("[ERROR] Failed to getting default project branch name: %v\n", err)
 		}
-		err = allowForcePush(config.GitlabURLDest, defaultBranchName, config.PrivateTokenDest, destProject.ID)
+		err = allowForcePush(ctx, config.GitlabURLDest, defaultBranchName, config.PrivateTokenDest, destProject.ID)
 		if err != nil {
 			fmt.Printf("[ERROR] Failed to remove force push option: %v\n", err)
 			generalLogger.Printf("[ERROR] Failed to remove force push option: %v\n", err)
@@ -946,11 +1508,67 @@ func importProjectClone(config Config, group Group, generalLogger, corruptedLogg
 	}
 	//
 	// Создаем дерево подгрупп и импортируем проекты из подгрупп
-	parseSubgroupTree(config, generalLogger, corruptedLogger, group.ID, parentID)
+	parseSubgroupTree(ctx, config, generalLogger, corruptedLogger, findingsLogger, group.ID, parentID)
 	fmt.Printf("[SUCCESS] importProjectClone<- End of importing group: %s; Path: %s\n", group.Name, group.FullPath)
 	generalLogger.Printf("[SUCCESS] importProjectClone<- End of importing group: %s; Path: %s\n", group.Name, group.FullPath)
 }
 
+// writeCheckpoint завершает прерванный запуск: удаляет временные зеркала, сохраняет отчет и отмечает
+// в состоянии, на каком проекте запуск прерван. Уже перенесенное остается в состоянии, следующий запуск
+// продолжит инкрементально
+func writeCheckpoint(config Config, generalLogger *log.Logger) {
+	fmt.Println("[WARNING] Interrupted, saving checkpoint")
+	generalLogger.Println("[WARNING] Interrupted, saving checkpoint")
+	if err := cleanUp(tmpDir); err != nil {
+		fmt.Printf("[ERROR] Failed to clean up: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to clean up: %v\n", err)
+	}
+	checkpoint := &SyncCheckpoint{InterruptedAt: time.Now(), Project: config.report.interrupt()}
+	if err := config.state.setCheckpoint(checkpoint); err != nil {
+		fmt.Printf("[ERROR] Failed to save sync state: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to save sync state: %v\n", err)
+	}
+	saveReport(config, generalLogger)
+}
+
+// saveReport сохраняет отчет о запуске в run-report.json
+func saveReport(config Config, generalLogger *log.Logger) {
+	if err := config.report.save(reportFile); err != nil {
+		fmt.Printf("[ERROR] Failed to save run report: %v\n", err)
+		generalLogger.Printf("[ERROR] Failed to save run report: %v\n", err)
+	}
+}
+
+// syncProjectExtras переносит участников, релизы и прочие данные проекта, которые не передаются через git.
+// Несопоставленные участники попадают в отчет проекта
+func syncProjectExtras(ctx context.Context, config Config, generalLogger *log.Logger, project Project, destProjectPath string, projectReport *ProjectReport) {
+	if !config.MembersSync && !config.ReleasesSync && !config.PackagesSync && !config.RegistrySync {
+		return
+	}
+	destProjectID, err := getProjectIDByPath(ctx, config.GitlabURLDest, config.PrivateTokenDest, destProjectPath)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to get destination project %s: %v\n", destProjectPath, err)
+		generalLogger.Printf("[ERROR] Failed to get destination project %s: %v\n", destProjectPath, err)
+		return
+	}
+	// Перенесем участников проекта
+	if config.MembersSync {
+		projectReport.UnmatchedMembers = syncProjectMembers(ctx, config, generalLogger, project.ID, destProjectID)
+	}
+	// Перенесем релизы для перенесенных тегов
+	if config.ReleasesSync {
+		syncReleases(ctx, config, generalLogger, project.ID, destProjectID)
+	}
+	// Перенесем пакеты из реестра пакетов
+	if config.PackagesSync {
+		syncPackages(ctx, config, generalLogger, project.ID, destProjectID)
+	}
+	// Перенесем образы из реестра контейнеров
+	if config.RegistrySync {
+		syncRegistry(ctx, config, generalLogger, project.ID, destProjectPath)
+	}
+}
+
 // setUpWorkspace применяет директорию с исполняемым файлом программы как рабочую директорию
 func setUpWorkspace() error {
 	// Получаем путь к исполняемому файлу
@@ -980,23 +1598,26 @@ func setUpWorkspace() error {
 }
 
 // getBadge получает badge указанной группы
-func getBadge(url, token string, groupID int) (string, int) {
+func getBadge(ctx context.Context, url, token string, groupID int) (string, int) {
 	// Создаем запрос
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v4/groups/%d/badges", url, groupID), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v4/groups/%d/badges", url, groupID), nil)
 	if err != nil {
 		fmt.Println("[ERROR] Error creating request:", err)
 		os.Exit(1)
 	}
 	req.Header.Set("PRIVATE-TOKEN", token)
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
+	// Настройка транспорта с общими настройками TLS (см. setUpTLS)
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsClientConfig(),
 	}
 	// Создаем HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: tr, Timeout: operationTimeouts.API}
 	// Выполняем и сохраняем ответ
 	resp, err := client.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", 0
+		}
 		fmt.Println("[ERROR] Error checking export status:", err)
 		os.Exit(1)
 	}
@@ -1015,7 +1636,7 @@ func getBadge(url, token string, groupID int) (string, int) {
 }
 
 // setBadge устанавливает badge на группу
-func setBadge(url, token, newBadgeName string, groupID int) {
+func setBadge(ctx context.Context, url, token, newBadgeName string, groupID int) {
 	fmt.Println("[DEBUG] setBadge-> start")
 	// Данные для бейджа
 	badgeData := BadgeData{
@@ -1023,12 +1644,12 @@ func setBadge(url, token, newBadgeName string, groupID int) {
 		LinkURL:  "https://example.com",
 		ImageURL: "https://example.com/badge.svg",
 	}
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
+	// Настройка транспорта с общими настройками TLS (см. setUpTLS)
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsClientConfig(),
 	}
 	// Создаем HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: tr, Timeout: operationTimeouts.API}
 
 	body, err := json.Marshal(badgeData)
 	if err != nil {
@@ -1036,7 +1657,7 @@ func setBadge(url, token, newBadgeName string, groupID int) {
 		os.Exit(1)
 	}
 	// Выполняем и сохраняем ответ
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v4/groups/%d/badges", url, groupID), bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v4/groups/%d/badges", url, groupID), bytes.NewBuffer(body))
 	if err != nil {
 		fmt.Printf("[ERROR] Failed to create request: %v\n", err)
 		os.Exit(1)
@@ -1047,6 +1668,9 @@ func setBadge(url, token, newBadgeName string, groupID int) {
 
 	resp, err := client.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
 		fmt.Printf("[ERROR] Failed to send request: %v\n", err)
 		os.Exit(1)
 	}
@@ -1060,20 +1684,20 @@ func setBadge(url, token, newBadgeName string, groupID int) {
 }
 
 // removeBadge удалит бейдж с группы
-func removeBadge(url, token string, groupID, badgeID int) error {
+func removeBadge(ctx context.Context, url, token string, groupID, badgeID int) error {
 	fmt.Println("[DEBUG] removeBadge-> start, group id:", groupID)
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/api/v4/groups/%d/badges/%d", url, groupID, badgeID), nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/api/v4/groups/%d/badges/%d", url, groupID, badgeID), nil)
 	if err != nil {
 		fmt.Println("[ERROR] Error creating request:", err)
 		return err
 	}
 	req.Header.Set("PRIVATE-TOKEN", token)
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
+	// Настройка транспорта с общими настройками TLS (см. setUpTLS)
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsClientConfig(),
 	}
 	// Создаем HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: tr, Timeout: operationTimeouts.API}
 	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Println("[ERROR] Error making request:", err)
@@ -1098,22 +1722,22 @@ func removeBadge(url, token string, groupID, badgeID int) error {
 }
 
 // getProjectDefaultBranch получает имя ветки по умолчанию
-func getProjectDefaultBranch(url, token string, projectID int) (string, error) {
+func getProjectDefaultBranch(ctx context.Context, url, token string, projectID int) (string, error) {
 	fmt.Println("[DEBUG] getProjectDefaultBranch-> getting default branch id: ", projectID)
 	// СОздаем запрос
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v4/projects/%d", url, projectID), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v4/projects/%d", url, projectID), nil)
 	if err != nil {
 		fmt.Println("[ERROR] Error creating request:", err)
 		return "", err
 	}
 	req.Header.Set("PRIVATE-TOKEN", token)
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
+	// Настройка транспорта с общими настройками TLS (см. setUpTLS)
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsClientConfig(),
 	}
 
 	// Создание HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: tr, Timeout: operationTimeouts.API}
 	// Выполняем и сохраняем ответ
 	resp, err := client.Do(req)
 	if err != nil {
@@ -1134,24 +1758,24 @@ func getProjectDefaultBranch(url, token string, projectID int) (string, error) {
 }
 
 // allowForcePush разврешает force push
-func allowForcePush(url, branchName, token string, projectID int) error {
+func allowForcePush(ctx context.Context, url, branchName, token string, projectID int) error {
 	fmt.Println("[DEBUG] allowForcePush-> removing force push for: ", projectID, branchName)
 	constructUrl := fmt.Sprintf("%s/api/v4/projects/%d/protected_branches/%s", url, projectID, branchName)
 
-	req, err := http.NewRequest("DELETE", constructUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", constructUrl, nil)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("PRIVATE-TOKEN", token)
 
-	// Настройка транспорта с отключенной проверкой SSL-сертификатов
+	// Настройка транспорта с общими настройками TLS (см. setUpTLS)
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsClientConfig(),
 	}
 
 	// Создание HTTP-клиента с настраиваемым транспортом
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: tr, Timeout: operationTimeouts.API}
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Member отображает участника группы или проекта в gitlab
+type Member struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	AccessLevel int    `json:"access_level"`
+	ExpiresAt   string `json:"expires_at"`
+}
+
+// User отображает пользователя gitlab. Поле email доступно только администратору
+type User struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+	PublicEmail string `json:"public_email"`
+}
+
+// loadMembersMap читает файл сопоставления пользователей вида {"source-username": "dest-username"}
+func loadMembersMap(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read members map file: %w", err)
+	}
+	membersMap := make(map[string]string)
+	if err := json.Unmarshal(data, &membersMap); err != nil {
+		return nil, fmt.Errorf("failed to parse members map file: %w", err)
+	}
+	return membersMap, nil
+}
+
+// syncGroupMembers переносит прямых участников группы с Gitlab-source на Gitlab-destination и возвращает
+// несопоставленных
+func syncGroupMembers(ctx context.Context, config Config, generalLogger *log.Logger, srcGroupID, destGroupID int) []string {
+	return syncMembers(ctx, config, generalLogger, "groups", srcGroupID, destGroupID)
+}
+
+// syncProjectMembers переносит прямых участников проекта с Gitlab-source на Gitlab-destination и возвращает
+// несопоставленных
+func syncProjectMembers(ctx context.Context, config Config, generalLogger *log.Logger, srcProjectID, destProjectID int) []string {
+	return syncMembers(ctx, config, generalLogger, "projects", srcProjectID, destProjectID)
+}
+
+// syncMembers переносит участников. kind -- "groups" или "projects". Возвращает username участников, для которых
+// не нашлось пользователя на Gitlab-destination
+func syncMembers(ctx context.Context, config Config, generalLogger *log.Logger, kind string, srcID, destID int) []string {
+	fmt.Printf("[DEBUG] syncMembers-> Syncing members of %s ID: %d -> %d\n", kind, srcID, destID)
+	generalLogger.Printf("[DEBUG] syncMembers-> Syncing members of %s ID: %d -> %d\n", kind, srcID, destID)
+	members, err := getAllPages[Member](ctx, fmt.Sprintf("%s/api/v4/%s/%d/members", config.GitlabURLSource, kind, srcID), config.PrivateTokenSource)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to get members of %s ID %d: %v\n", kind, srcID, err)
+		generalLogger.Printf("[ERROR] Failed to get members of %s ID %d: %v\n", kind, srcID, err)
+		return nil
+	}
+	var unmatched []string
+	for _, member := range members {
+		// Заблокированных пользователей не переносим
+		if member.State != "" && member.State != "active" {
+			continue
+		}
+		destUser := findDestUser(ctx, config, member)
+		if destUser == nil {
+			fmt.Printf("[WARNING] Unmatched user: %s (%s ID: %d)\n", member.Username, kind, srcID)
+			generalLogger.Printf("[WARNING] Unmatched user: %s (%s ID: %d)\n", member.Username, kind, srcID)
+			unmatched = append(unmatched, member.Username)
+			continue
+		}
+		accessLevel := member.AccessLevel
+		// Ограничим максимальный уровень доступа на Gitlab-destination
+		if config.MembersMaxAccessLevel > 0 && accessLevel > config.MembersMaxAccessLevel {
+			accessLevel = config.MembersMaxAccessLevel
+		}
+		if err := addMember(ctx, config.GitlabURLDest, config.PrivateTokenDest, kind, destID, destUser.ID, accessLevel, member.ExpiresAt); err != nil {
+			fmt.Printf("[ERROR] Failed to add member %s: %v\n", destUser.Username, err)
+			generalLogger.Printf("[ERROR] Failed to add member %s: %v\n", destUser.Username, err)
+			continue
+		}
+	}
+	fmt.Printf("[SUCCESS] syncMembers<- Members of %s ID %d synced\n", kind, srcID)
+	generalLogger.Printf("[SUCCESS] syncMembers<- Members of %s ID %d synced\n", kind, srcID)
+	return unmatched
+}
+
+// findDestUser ищет пользователя на Gitlab-destination: по файлу сопоставления, затем по username, затем по email
+func findDestUser(ctx context.Context, config Config, member Member) *User {
+	if destUsername, ok := config.membersMap[member.Username]; ok {
+		return getUserByUsername(ctx, config.GitlabURLDest, config.PrivateTokenDest, destUsername)
+	}
+	if user := getUserByUsername(ctx, config.GitlabURLDest, config.PrivateTokenDest, member.Username); user != nil {
+		return user
+	}
+	// Email пользователя на Gitlab-source виден только с токеном администратора
+	var srcUser User
+	if err := getJSON(ctx, fmt.Sprintf("%s/api/v4/users/%d", config.GitlabURLSource, member.ID), config.PrivateTokenSource, &srcUser); err != nil {
+		return nil
+	}
+	email := srcUser.Email
+	if email == "" {
+		email = srcUser.PublicEmail
+	}
+	if email == "" {
+		return nil
+	}
+	users, err := getAllPages[User](ctx, fmt.Sprintf("%s/api/v4/users?search=%s", config.GitlabURLDest, url.QueryEscape(email)), config.PrivateTokenDest)
+	if err != nil {
+		return nil
+	}
+	for _, user := range users {
+		if strings.EqualFold(user.Email, email) || strings.EqualFold(user.PublicEmail, email) {
+			return &user
+		}
+	}
+	return nil
+}
+
+// getUserByUsername возвращает пользователя по username или nil, если такого нет
+func getUserByUsername(ctx context.Context, gitlabURL, token, username string) *User {
+	var users []User
+	if err := getJSON(ctx, fmt.Sprintf("%s/api/v4/users?username=%s", gitlabURL, url.QueryEscape(username)), token, &users); err != nil {
+		return nil
+	}
+	if len(users) == 0 {
+		return nil
+	}
+	return &users[0]
+}
+
+// addMember добавляет участника, а если он уже есть -- обновляет уровень доступа и срок действия
+func addMember(ctx context.Context, url, token, kind string, destID, userID, accessLevel int, expiresAt string) error {
+	data := map[string]interface{}{
+		"user_id":      userID,
+		"access_level": accessLevel,
+	}
+	if expiresAt != "" {
+		data["expires_at"] = expiresAt
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	resp, err := doRequest(ctx, "POST", fmt.Sprintf("%s/api/v4/%s/%d/members", url, kind, destID), token, bytes.NewBuffer(body), "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		return nil
+	}
+	// Участник уже существует -- обновим его
+	if resp.StatusCode == http.StatusConflict {
+		resp, err := doRequest(ctx, "PUT", fmt.Sprintf("%s/api/v4/%s/%d/members/%d", url, kind, destID, userID), token, bytes.NewBuffer(body), "application/json")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to update member. Status: %d, Response: %s", resp.StatusCode, string(respBody))
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("failed to add member. Status: %d, Response: %s", resp.StatusCode, string(respBody))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Уровни доступа Gitlab
+const (
+	testAccessDeveloper  = 30
+	testAccessMaintainer = 40
+	testAccessOwner      = 50
+)
+
+// TestSyncMembers проверяет сопоставление участников по файлу сопоставления, username и email, ограничение
+// уровня доступа, обновление существующих участников и отчет о несопоставленных
+func TestSyncMembers(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newFakeGitLab(t, "source-token")
+	root := source.addGroup("xxxxx", "xxxxx", nil)
+	app := source.addProject(root, "App", "app", []map[string]string{{"README.md": "app\n"}}, nil, nil)
+	alice := source.addUser("alice", "alice@source.example.com")
+	bob := source.addUser("bob", "bob@example.com")
+	carol := source.addUser("carol", "carol@source.example.com")
+	dave := source.addUser("dave", "dave@source.example.com")
+	erin := source.addUser("erin", "erin@source.example.com")
+	source.addMember("groups", root.ID, alice, testAccessMaintainer, "active")
+	source.addMember("groups", root.ID, bob, testAccessOwner, "active")
+	source.addMember("groups", root.ID, carol, testAccessDeveloper, "active")
+	source.addMember("groups", root.ID, dave, testAccessDeveloper, "active")
+	source.addMember("groups", root.ID, erin, testAccessDeveloper, "blocked")
+	source.addMember("projects", app.ID, alice, testAccessDeveloper, "active")
+	source.addMember("projects", app.ID, dave, testAccessDeveloper, "active")
+	dest := newFakeGitLab(t, "dest-token")
+	dest.addUser("alice", "alice@dest.example.com")
+	dest.addUser("robert", "bob@example.com")
+	dest.addUser("caroline", "caroline@dest.example.com")
+	dest.addUser("carol", "carol@dest.example.com")
+	dest.addUser("erin", "erin@dest.example.com")
+	mapFile := filepath.Join(t.TempDir(), "members-map.json")
+	if err := os.WriteFile(mapFile, []byte(`{"carol": "caroline"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := newTestConfig(t, source, dest, func(config *Config) {
+		config.MembersSync = true
+		config.MembersMaxAccessLevel = testAccessMaintainer
+	})
+	var err error
+	if config.membersMap, err = loadMembersMap(mapFile); err != nil {
+		t.Fatal(err)
+	}
+
+	runSync(t, config)
+
+	// alice -- по username, bob -> robert -- по email, carol -> caroline -- по файлу сопоставления, хотя на
+	// Gitlab-destination есть и carol; уровень bob ограничен membersMaxAccessLevel; заблокированная erin пропущена
+	wantGroup := map[string]int{"alice": testAccessMaintainer, "robert": testAccessMaintainer, "caroline": testAccessDeveloper}
+	if got := dest.memberAccess("groups", "mock-sync/xxxxx"); !reflect.DeepEqual(got, wantGroup) {
+		t.Errorf("group members = %v, want %v", got, wantGroup)
+	}
+	wantProject := map[string]int{"alice": testAccessDeveloper}
+	if got := dest.memberAccess("projects", "mock-sync/xxxxx/app"); !reflect.DeepEqual(got, wantProject) {
+		t.Errorf("project members = %v, want %v", got, wantProject)
+	}
+	wantGroups := []*GroupReport{{Group: "xxxxx", UnmatchedMembers: []string{"dave"}}}
+	if !reflect.DeepEqual(config.report.Groups, wantGroups) {
+		t.Errorf("group reports = %+v, want %+v", config.report.Groups, wantGroups)
+	}
+	if len(config.report.Projects) != 1 || !reflect.DeepEqual(config.report.Projects[0].UnmatchedMembers, []string{"dave"}) {
+		t.Errorf("project reports = %+v, want unmatched dave", config.report.Projects)
+	}
+
+	// Существующий участник обновляется (POST -> 409 -> PUT)
+	source.addMember("projects", app.ID, alice, testAccessMaintainer, "active")
+	config = newTestConfig(t, source, dest, func(config *Config) {
+		config.MembersSync = true
+	})
+
+	runSync(t, config)
+
+	if got := dest.memberAccess("projects", "mock-sync/xxxxx/app")["alice"]; got != testAccessMaintainer {
+		t.Errorf("alice access after update = %d, want %d", got, testAccessMaintainer)
+	}
+	updated := false
+	for _, request := range dest.requests {
+		if strings.HasPrefix(request, "PUT /api/v4/projects/") && strings.Contains(request, "/members/") {
+			updated = true
+		}
+	}
+	if !updated {
+		t.Error("existing member was not updated with PUT")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Package отображает пакет в реестре пакетов проекта
+type Package struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	PackageType string `json:"package_type"`
+}
+
+// PackageFile отображает файл пакета
+type PackageFile struct {
+	ID         int    `json:"id"`
+	FileName   string `json:"file_name"`
+	Size       int64  `json:"size"`
+	FileSHA256 string `json:"file_sha256"`
+}
+
+// syncPackages переносит файлы пакетов проекта в тот же проект на Gitlab-destination.
+// Поддерживаются форматы generic и maven, остальные только попадают в лог.
+// Уже перенесенные файлы (по имени, версии и sha256) пропускаются. Если API не отдает file_sha256,
+// файл скачивается и сравнивается по посчитанной контрольной сумме, но повторно не загружается
+func syncPackages(ctx context.Context, config Config, generalLogger *log.Logger, srcProjectID, destProjectID int) {
+	fmt.Printf("[DEBUG] syncPackages-> Syncing packages of project ID: %d -> %d\n", srcProjectID, destProjectID)
+	generalLogger.Printf("[DEBUG] syncPackages-> Syncing packages of project ID: %d -> %d\n", srcProjectID, destProjectID)
+	packages, err := getAllPages[Package](ctx, fmt.Sprintf("%s/api/v4/projects/%d/packages", config.GitlabURLSource, srcProjectID), config.PrivateTokenSource)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to get packages of project ID %d: %v\n", srcProjectID, err)
+		generalLogger.Printf("[ERROR] Failed to get packages of project ID %d: %v\n", srcProjectID, err)
+		return
+	}
+	for _, pkg := range packages {
+		if pkg.PackageType != "generic" && pkg.PackageType != "maven" {
+			fmt.Printf("[WARNING] Package type %s is not supported, skip package %s %s\n", pkg.PackageType, pkg.Name, pkg.Version)
+			generalLogger.Printf("[WARNING] Package type %s is not supported, skip package %s %s\n", pkg.PackageType, pkg.Name, pkg.Version)
+			continue
+		}
+		files, err := getAllPages[PackageFile](ctx, fmt.Sprintf("%s/api/v4/projects/%d/packages/%d/package_files", config.GitlabURLSource, srcProjectID, pkg.ID), config.PrivateTokenSource)
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to get files of package %s %s: %v\n", pkg.Name, pkg.Version, err)
+			generalLogger.Printf("[ERROR] Failed to get files of package %s %s: %v\n", pkg.Name, pkg.Version, err)
+			continue
+		}
+		for _, file := range files {
+			stateKey := fmt.Sprintf("%d/%s/%s/%s", destProjectID, pkg.Name, pkg.Version, file.FileName)
+			if file.FileSHA256 != "" && config.state.packageSynced(stateKey, file.FileSHA256) {
+				continue
+			}
+			checksum, err := copyPackageFile(ctx, config, pkg, file, srcProjectID, destProjectID, stateKey)
+			if err != nil {
+				fmt.Printf("[ERROR] Failed to copy package file %s/%s: %v\n", pkg.Name, file.FileName, err)
+				generalLogger.Printf("[ERROR] Failed to copy package file %s/%s: %v\n", pkg.Name, file.FileName, err)
+				continue
+			}
+			if config.state.packageSynced(stateKey, checksum) {
+				continue
+			}
+			if err := config.state.markPackageSynced(stateKey, checksum); err != nil {
+				fmt.Printf("[ERROR] Failed to save sync state: %v\n", err)
+				generalLogger.Printf("[ERROR] Failed to save sync state: %v\n", err)
+			}
+		}
+	}
+	fmt.Printf("[SUCCESS] syncPackages<- Packages of project ID %d synced\n", srcProjectID)
+	generalLogger.Printf("[SUCCESS] syncPackages<- Packages of project ID %d synced\n", srcProjectID)
+}
+
+// copyPackageFile скачивает файл пакета с Gitlab-source, загружает его на Gitlab-destination и возвращает
+// его sha256. Файл без file_sha256, который уже перенесен с той же контрольной суммой (stateKey), не загружается
+func copyPackageFile(ctx context.Context, config Config, pkg Package, file PackageFile, srcProjectID, destProjectID int, stateKey string) (string, error) {
+	filePath := packageFilePath(pkg, file)
+	tmpFile, checksum, err := downloadToTemp(ctx, fmt.Sprintf("%s/api/v4/projects/%d/%s", config.GitlabURLSource, srcProjectID, filePath), config.PrivateTokenSource)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	if file.FileSHA256 != "" && checksum != file.FileSHA256 {
+		return "", fmt.Errorf("checksum mismatch: expected %s, got %s", file.FileSHA256, checksum)
+	}
+	if file.FileSHA256 == "" && config.state.packageSynced(stateKey, checksum) {
+		return checksum, nil
+	}
+	return checksum, uploadFile(ctx, fmt.Sprintf("%s/api/v4/projects/%d/%s", config.GitlabURLDest, destProjectID, filePath), config.PrivateTokenDest, tmpFile)
+}
+
+// packageFilePath строит путь к файлу пакета в API. Для maven имя пакета -- это путь groupId/artifactId
+func packageFilePath(pkg Package, file PackageFile) string {
+	if pkg.PackageType == "maven" {
+		// maven-metadata.xml уровня артефакта хранится в пакете без версии
+		if pkg.Version == "" {
+			return fmt.Sprintf("packages/maven/%s/%s", pkg.Name, url.PathEscape(file.FileName))
+		}
+		return fmt.Sprintf("packages/maven/%s/%s/%s", pkg.Name, url.PathEscape(pkg.Version), url.PathEscape(file.FileName))
+	}
+	return fmt.Sprintf("packages/generic/%s/%s/%s", url.PathEscape(pkg.Name), url.PathEscape(pkg.Version), strings.TrimPrefix(file.FileName, "/"))
+}
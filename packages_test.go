@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSyncPackagesSkipsSyncedFiles проверяет, что перенесенные файлы пакетов не загружаются повторно,
+// в том числе когда Gitlab-source не отдает file_sha256, а измененный файл загружается заново
+func TestSyncPackagesSkipsSyncedFiles(t *testing.T) {
+	for _, withoutSHA := range []bool{false, true} {
+		name := "with file_sha256"
+		if withoutSHA {
+			name = "without file_sha256"
+		}
+		t.Run(name, func(t *testing.T) {
+			setUpTestWorkspace(t)
+			source := newSourceFixture(t)
+			app := source.projectByPath("xxxxx/app")
+			app.Packages = map[string]map[string]string{
+				"tool/1.0.0": {"tool.tar.gz": "tool 1.0.0\n", "tool.sha256": "checksum\n"},
+			}
+			app.PackagesWithoutSHA = withoutSHA
+			dest := newFakeGitLab(t, "dest-token")
+			configure := func(config *Config) { config.PackagesSync = true }
+
+			runSync(t, newTestConfig(t, source, dest, configure))
+			destApp := dest.projectByPath("mock-sync/xxxxx/app")
+			if destApp == nil {
+				t.Fatal("mock-sync/xxxxx/app is missing on destination")
+			}
+			if !reflect.DeepEqual(destApp.Packages, app.Packages) {
+				t.Errorf("destination packages = %v, want %v", destApp.Packages, app.Packages)
+			}
+			runSync(t, newTestConfig(t, source, dest, configure))
+			if destApp.PackageUploads != 2 {
+				t.Errorf("package uploads after second run = %d, want 2", destApp.PackageUploads)
+			}
+
+			app.Packages["tool/1.0.0"]["tool.tar.gz"] = "tool 1.0.0 rebuilt\n"
+			runSync(t, newTestConfig(t, source, dest, configure))
+			if destApp.PackageUploads != 3 {
+				t.Errorf("package uploads after file change = %d, want 3", destApp.PackageUploads)
+			}
+			if got := destApp.Packages["tool/1.0.0"]["tool.tar.gz"]; got != "tool 1.0.0 rebuilt\n" {
+				t.Errorf("changed file on destination = %q, want rebuilt content", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// PathRule -- правило переименования полного пути группы или проекта на Gitlab-destination.
+// Задается либо Prefix (заменяется начало пути по границе сегментов), либо Regex (замена по
+// регулярному выражению, в Replace доступны $1, $2 ...)
+type PathRule struct {
+	Prefix  string `json:"prefix"`
+	Regex   string `json:"regex"`
+	Replace string `json:"replace"`
+
+	re *regexp.Regexp
+}
+
+// pathMapper вычисляет пути на Gitlab-destination по путям на Gitlab-source и следит, чтобы
+// разные проекты не попали по одному и тому же пути
+type pathMapper struct {
+	explicit    map[string]string
+	rules       []PathRule
+	defaultPath func(string) string
+
+	mu      sync.Mutex
+	claimed map[string]string // Путь на Gitlab-destination (в нижнем регистре) -> путь на Gitlab-source
+}
+
+// newPathMapper собирает правила из конфигурации. Без правил пути строятся как раньше (destGroupPath)
+func newPathMapper(config Config) (*pathMapper, error) {
+	mapper := &pathMapper{
+		explicit: make(map[string]string),
+		defaultPath: func(sourcePath string) string {
+			return destGroupPath(config, sourcePath)
+		},
+		claimed: make(map[string]string),
+	}
+	if config.PathMapFile != "" {
+		data, err := os.ReadFile(config.PathMapFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read path map: %w", err)
+		}
+		if err := json.Unmarshal(data, &mapper.explicit); err != nil {
+			return nil, fmt.Errorf("failed to parse path map: %w", err)
+		}
+		// Два пути, явно отображенные в один, -- ошибка конфигурации
+		targets := make(map[string]string)
+		for source, dest := range mapper.explicit {
+			key := strings.ToLower(strings.Trim(dest, "/"))
+			if other, ok := targets[key]; ok {
+				return nil, fmt.Errorf("path map collision: %s and %s both map to %s", other, source, dest)
+			}
+			targets[key] = source
+		}
+	}
+	for _, rule := range config.PathRules {
+		switch {
+		case rule.Prefix != "" && rule.Regex != "":
+			return nil, fmt.Errorf("path rule must have either prefix or regex: %+v", rule)
+		case rule.Regex != "":
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path rule regex %s: %w", rule.Regex, err)
+			}
+			rule.re = re
+		case rule.Prefix == "":
+			return nil, fmt.Errorf("path rule must have either prefix or regex: %+v", rule)
+		}
+		mapper.rules = append(mapper.rules, rule)
+	}
+	return mapper, nil
+}
+
+// mapPath возвращает полный путь на Gitlab-destination. Сначала ищется явное соответствие для самого
+// пути или ближайшей родительской группы, затем первое подходящее правило, иначе путь по умолчанию.
+// Явные соответствия и правила задают полный путь на Gitlab-destination (без xxxArea)
+func (m *pathMapper) mapPath(sourcePath string) string {
+	for prefix, rest := sourcePath, ""; prefix != ""; {
+		if dest, ok := m.explicit[prefix]; ok {
+			return strings.Trim(dest, "/") + rest
+		}
+		slash := strings.LastIndex(prefix, "/")
+		if slash < 0 {
+			break
+		}
+		rest = prefix[slash:] + rest
+		prefix = prefix[:slash]
+	}
+	for _, rule := range m.rules {
+		if rule.re != nil {
+			if rule.re.MatchString(sourcePath) {
+				return strings.Trim(rule.re.ReplaceAllString(sourcePath, rule.Replace), "/")
+			}
+			continue
+		}
+		prefix := strings.Trim(rule.Prefix, "/")
+		if sourcePath == prefix || strings.HasPrefix(sourcePath, prefix+"/") {
+			return strings.Trim(strings.Trim(rule.Replace, "/")+sourcePath[len(prefix):], "/")
+		}
+	}
+	return m.defaultPath(sourcePath)
+}
+
+// claim закрепляет путь на Gitlab-destination за проектом. Путь занят, если в этом запуске его уже
+// закрепил другой проект с Gitlab-source, или если его закрепил другой проект в прошлых запусках
+// (состояние) и этот проект еще есть на Gitlab-destination. Пути в Gitlab не зависят от регистра
+func (m *pathMapper) claim(ctx context.Context, config Config, sourcePath, destPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := strings.ToLower(destPath)
+	if other, ok := m.claimed[key]; ok && other != sourcePath {
+		return fmt.Errorf("path collision: %s and %s both map to %s", other, sourcePath, destPath)
+	}
+	if other, ok := config.state.pathOwner(key); ok && other != sourcePath {
+		exists, err := destProjectExists(ctx, config, destPath)
+		if err != nil {
+			return err
+		}
+		// Проект удален с Gitlab-destination -- путь свободен
+		if exists {
+			return fmt.Errorf("path collision: %s (transferred by a previous run) and %s both map to %s", other, sourcePath, destPath)
+		}
+	}
+	if err := config.state.claimPath(key, sourcePath); err != nil {
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+	m.claimed[key] = sourcePath
+	return nil
+}
+
+// destProjectExists проверяет, есть ли проект с полным путем fullPath на Gitlab-destination
+func destProjectExists(ctx context.Context, config Config, fullPath string) (bool, error) {
+	resp, err := doRequest(ctx, "GET", fmt.Sprintf("%s/api/v4/projects/%s", config.GitlabURLDest, url.PathEscape(fullPath)), config.PrivateTokenDest, nil, "")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check project %s on Gitlab-destination. Status: %d", fullPath, resp.StatusCode)
+}
+
+// ensureGroupPath создает на Gitlab-destination все группы полного пути, которых еще нет,
+// и возвращает ID последней
+func ensureGroupPath(ctx context.Context, config Config, generalLogger *log.Logger, fullPath string) (int, error) {
+	parentID := 0
+	segments := strings.Split(fullPath, "/")
+	for i, segment := range segments {
+		groupPath := strings.Join(segments[:i+1], "/")
+		var existing Group
+		err := getJSON(ctx, fmt.Sprintf("%s/api/v4/groups/%s", config.GitlabURLDest, url.PathEscape(groupPath)), config.PrivateTokenDest, &existing)
+		if err == nil && existing.ID != 0 {
+			parentID = existing.ID
+			continue
+		}
+		groupID := createGroup(ctx, generalLogger, config.GitlabURLDest, config.PrivateTokenDest, Group{Name: segment, Path: segment, FullPath: groupPath}, parentID, parentID == 0)
+		if groupID == 0 || groupID == parentID {
+			return 0, fmt.Errorf("failed to create group %s", groupPath)
+		}
+		parentID = groupID
+	}
+	return parentID, nil
+}
+
+// splitDestPath делит полный путь проекта на Gitlab-destination на группу и путь проекта
+func splitDestPath(fullPath string) (string, string) {
+	return path.Dir(fullPath), path.Base(fullPath)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestPathCollisionAcrossRuns проверяет, что проект не переносится в путь, куда прошлый запуск перенес
+// другой проект, пока тот проект есть на Gitlab-destination
+func TestPathCollisionAcrossRuns(t *testing.T) {
+	for _, removed := range []bool{false, true} {
+		name := "project exists on destination"
+		if removed {
+			name = "project removed from destination"
+		}
+		t.Run(name, func(t *testing.T) {
+			setUpTestWorkspace(t)
+			source := newSourceFixture(t)
+			dest := newFakeGitLab(t, "dest-token")
+			runSync(t, newTestConfig(t, source, dest, nil))
+			appRefs := dest.refs("mock-sync/xxxxx/app")
+			// Следующий запуск переносит другой проект в тот же путь
+			source.removeProject("xxxxx/app")
+			if removed {
+				dest.removeProject("mock-sync/xxxxx/app")
+			}
+			config := newTestConfig(t, source, dest, func(config *Config) {
+				config.PathRules = []PathRule{{Regex: "^xxxxx/sub/lib$", Replace: "mock-sync/xxxxx/app"}}
+			})
+
+			runSync(t, config)
+
+			wantStatus, wantRefs := statusFailed, appRefs
+			if removed {
+				wantStatus, wantRefs = statusSuccess, source.refs("xxxxx/sub/lib")
+			}
+			if got := reportStatuses(config)["xxxxx/sub/lib"]; got != wantStatus {
+				t.Errorf("status of xxxxx/sub/lib = %q, want %q", got, wantStatus)
+			}
+			if got := dest.refs("mock-sync/xxxxx/app"); !reflect.DeepEqual(got, wantRefs) {
+				t.Errorf("refs of mock-sync/xxxxx/app = %v, want %v", got, wantRefs)
+			}
+		})
+	}
+}
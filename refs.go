@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RefNamespace -- пространство ссылок, которое пушится на Gitlab-destination. Source -- шаблон ссылок
+// репозитория, Dest -- куда они пушатся (пусто -- под тем же именем). Как в refspec git, шаблон может
+// содержать одну *, которая в Dest заменяется совпавшей частью имени: refs/merge-requests/*/head -> refs/mr/*
+type RefNamespace struct {
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+}
+
+// defaultRefNamespaces пушатся всегда, первыми. Ветки раньше тегов: первая ветка создает проект
+// и становится веткой по умолчанию
+var defaultRefNamespaces = []RefNamespace{
+	{Source: "refs/heads/*", Dest: "refs/heads/*"},
+	{Source: "refs/tags/*", Dest: "refs/tags/*"},
+}
+
+// hiddenRefPrefixes -- пространства ссылок, которые Gitlab ведет сам и пуш в которые отклоняет
+var hiddenRefPrefixes = []string{
+	"refs/merge-requests/",
+	"refs/keep-around/",
+	"refs/pipelines/",
+	"refs/environments/",
+	"refs/tmp/",
+}
+
+// RefMapping -- ссылка локального репозитория и её имя на Gitlab-destination
+type RefMapping struct {
+	Source string
+	Dest   string
+	SHA    string
+}
+
+// compileRefNamespaces проверяет пространства ссылок из конфигурации и добавляет их после defaultRefNamespaces
+func compileRefNamespaces(namespaces []RefNamespace) ([]RefNamespace, error) {
+	compiled := append([]RefNamespace(nil), defaultRefNamespaces...)
+	for _, namespace := range namespaces {
+		if namespace.Dest == "" {
+			namespace.Dest = namespace.Source
+		}
+		if !strings.HasPrefix(namespace.Source, "refs/") || !strings.HasPrefix(namespace.Dest, "refs/") {
+			return nil, fmt.Errorf("ref namespace must start with refs/: %+v", namespace)
+		}
+		if strings.Count(namespace.Source, "*") != 1 || strings.Count(namespace.Dest, "*") != 1 {
+			return nil, fmt.Errorf("ref namespace must contain exactly one * in source and dest: %+v", namespace)
+		}
+		for _, prefix := range hiddenRefPrefixes {
+			if strings.HasPrefix(namespace.Dest, prefix) {
+				return nil, fmt.Errorf("gitlab rejects pushes to %s, map ref namespace %s to another dest", prefix, namespace.Source)
+			}
+		}
+		compiled = append(compiled, namespace)
+	}
+	return compiled, nil
+}
+
+// match возвращает имя ссылки name на Gitlab-destination, если она попадает в пространство
+func (n RefNamespace) match(name string) (string, bool) {
+	prefix, suffix, _ := strings.Cut(n.Source, "*")
+	if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return strings.Replace(n.Dest, "*", name[len(prefix):len(name)-len(suffix)], 1), true
+}
+
+// listPrefix -- начало имени ссылок пространства до *, по целым сегментам, для ListRefs
+func (n RefNamespace) listPrefix() string {
+	prefix, _, _ := strings.Cut(n.Source, "*")
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+// mapRefs сопоставляет ссылкам имена на Gitlab-destination в порядке пространств. Ссылка попадает в первое
+// подходящее пространство; если две ссылки попали в одно имя, пушится первая
+func mapRefs(refs []GitRef, namespaces []RefNamespace) []RefMapping {
+	var mappings []RefMapping
+	mapped := make(map[string]bool)
+	claimed := make(map[string]bool)
+	for _, namespace := range namespaces {
+		for _, ref := range refs {
+			if mapped[ref.Name] {
+				continue
+			}
+			dest, ok := namespace.match(ref.Name)
+			if !ok || claimed[dest] {
+				continue
+			}
+			mapped[ref.Name], claimed[dest] = true, true
+			mappings = append(mappings, RefMapping{Source: ref.Name, Dest: dest, SHA: ref.SHA})
+		}
+	}
+	return mappings
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Типы манифестов, которые мы умеем переносить
+const (
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// RegistryRepository отображает репозиторий реестра контейнеров проекта
+type RegistryRepository struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// RegistryTag отображает тег образа в реестре контейнеров
+type RegistryTag struct {
+	Name string `json:"name"`
+}
+
+// ociDescriptor -- ссылка на blob или манифест в манифесте
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// ociManifest объединяет поля манифеста образа и индекса (списка манифестов)
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Config    *ociDescriptor  `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// registryTokenDefaultLifetime -- срок жизни токена, если реестр не указал expires_in (как в спецификации
+// docker token auth). registryTokenMargin -- за сколько до истечения токен получается заново
+const (
+	registryTokenDefaultLifetime = 60 * time.Second
+	registryTokenMargin          = 10 * time.Second
+)
+
+// registryToken -- bearer токен реестра и время, до которого им можно пользоваться
+type registryToken struct {
+	value   string
+	expires time.Time
+}
+
+// registryClient общается с реестром по протоколу OCI distribution (/v2/...)
+// и сам получает bearer токены, когда реестр их запрашивает
+type registryClient struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+	tokens   map[string]registryToken // scope -> bearer токен
+	// challenge -- последний WWW-Authenticate реестра, по нему истекший токен получается заново
+	challenge string
+}
+
+// newRegistryClient создает клиента реестра. client можно подменить (например, в тестах)
+func newRegistryClient(baseURL, username, password string, client *http.Client) *registryClient {
+	if client == nil {
+		client = newHTTPClient()
+	}
+	return &registryClient{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		username: username,
+		password: password,
+		client:   client,
+		tokens:   make(map[string]registryToken),
+	}
+}
+
+// do выполняет запрос к реестру. Если реестр ответил 401 (токена нет, он истек или отозван) -- получает
+// токен и повторяет запрос. Повторить можно только запрос без тела, поэтому запросы с телом должны идти
+// после запроса, получившего токен
+func (r *registryClient) do(ctx context.Context, method, path, scope string, header http.Header, body io.Reader, contentLength int64) (*http.Response, error) {
+	// Истекший токен получаем заново до запроса, а не по 401: запрос с телом повторить нельзя
+	if token, ok := r.tokens[scope]; ok && !time.Now().Before(token.expires) {
+		if err := r.authenticate(ctx, r.challenge, scope); err != nil {
+			return nil, err
+		}
+	}
+	send := func() (*http.Response, error) {
+		reqURL := path
+		if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+			reqURL = r.baseURL + path
+		}
+		req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		for key, values := range header {
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+		if body != nil {
+			req.ContentLength = contentLength
+		}
+		if token, ok := r.tokens[scope]; ok {
+			req.Header.Set("Authorization", "Bearer "+token.value)
+		} else if r.username != "" {
+			req.SetBasicAuth(r.username, r.password)
+		}
+		return r.client.Do(req)
+	}
+	resp, err := send()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	// Токен больше не действует: следующий запрос получит новый
+	delete(r.tokens, scope)
+	if body != nil {
+		return resp, nil
+	}
+	r.challenge = resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err := r.authenticate(ctx, r.challenge, scope); err != nil {
+		return nil, err
+	}
+	return send()
+}
+
+// authenticate получает bearer токен по заголовку WWW-Authenticate: Bearer realm="...",service="..."
+func (r *registryClient) authenticate(ctx context.Context, challenge, scope string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return fmt.Errorf("registry authentication failed: %s", challenge)
+	}
+	params := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if found {
+			params[key] = strings.Trim(value, `"`)
+		}
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid registry auth realm: %s", challenge)
+	}
+	query := realm.Query()
+	query.Set("service", params["service"])
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", realm.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get registry token. Status: %d", resp.StatusCode)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode registry token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	// Срок считаем от своего времени (issued_at не используем): часы реестра могут расходиться с нашими
+	lifetime := registryTokenDefaultLifetime
+	if token.ExpiresIn > 0 {
+		lifetime = time.Duration(token.ExpiresIn) * time.Second
+	}
+	r.tokens[scope] = registryToken{value: token.Token, expires: time.Now().Add(lifetime - min(registryTokenMargin, lifetime/2))}
+	return nil
+}
+
+// getManifest получает манифест по тегу или digest и возвращает его тело и тип
+func (r *registryClient) getManifest(ctx context.Context, repo, reference string) ([]byte, string, error) {
+	header := http.Header{}
+	header.Set("Accept", strings.Join([]string{mediaTypeOCIIndex, mediaTypeOCIManifest, mediaTypeDockerManifestList, mediaTypeDockerManifest}, ", "))
+	resp, err := r.do(ctx, "GET", fmt.Sprintf("/v2/%s/manifests/%s", repo, reference), pullScope(repo), header, nil, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to get manifest %s:%s. Status: %d", repo, reference, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// putManifest загружает манифест по тегу или digest
+func (r *registryClient) putManifest(ctx context.Context, repo, reference, mediaType string, data []byte) error {
+	// Сначала убедимся, что токен на запись уже получен -- запрос с телом повторить нельзя
+	if err := r.ensureAuth(ctx, repo); err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", mediaType)
+	resp, err := r.do(ctx, "PUT", fmt.Sprintf("/v2/%s/manifests/%s", repo, reference), pushScope(repo), header, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to put manifest %s:%s. Status: %d, Response: %s", repo, reference, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// hasBlob проверяет, есть ли blob в репозитории
+func (r *registryClient) hasBlob(ctx context.Context, repo, digest string) (bool, error) {
+	resp, err := r.do(ctx, "HEAD", fmt.Sprintf("/v2/%s/blobs/%s", repo, digest), pushScope(repo), nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// getBlob открывает поток чтения blob. Закрыть его должна вызывающая сторона
+func (r *registryClient) getBlob(ctx context.Context, repo, digest string) (io.ReadCloser, int64, error) {
+	resp, err := r.do(ctx, "GET", fmt.Sprintf("/v2/%s/blobs/%s", repo, digest), pullScope(repo), nil, nil, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("failed to get blob %s. Status: %d", digest, resp.StatusCode)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// putBlob загружает blob одним запросом (POST за адресом загрузки, затем PUT с телом)
+func (r *registryClient) putBlob(ctx context.Context, repo, digest string, blob io.Reader, size int64) error {
+	resp, err := r.do(ctx, "POST", fmt.Sprintf("/v2/%s/blobs/uploads/", repo), pushScope(repo), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to start blob upload. Status: %d", resp.StatusCode)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return fmt.Errorf("failed to get blob upload location: %w", err)
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err = r.do(ctx, "PUT", location.String(), pushScope(repo), header, blob, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to upload blob %s. Status: %d, Response: %s", digest, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// ensureAuth делает запрос без тела, чтобы получить токен на запись заранее
+func (r *registryClient) ensureAuth(ctx context.Context, repo string) error {
+	_, err := r.hasBlob(ctx, repo, "sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	return err
+}
+
+func pullScope(repo string) string {
+	return fmt.Sprintf("repository:%s:pull", repo)
+}
+
+func pushScope(repo string) string {
+	return fmt.Sprintf("repository:%s:pull,push", repo)
+}
+
+// copyImage переносит манифест (и все, на что он ссылается) из srcRepo в destRepo под тем же reference
+func copyImage(ctx context.Context, src, dest *registryClient, srcRepo, destRepo, reference string) error {
+	data, mediaType, err := src.getManifest(ctx, srcRepo, reference)
+	if err != nil {
+		return err
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if mediaType == "" {
+		mediaType = manifest.MediaType
+	}
+	switch mediaType {
+	case mediaTypeOCIIndex, mediaTypeDockerManifestList:
+		// Для мультиархитектурных образов сначала переносим все вложенные манифесты
+		for _, child := range manifest.Manifests {
+			if err := copyImage(ctx, src, dest, srcRepo, destRepo, child.Digest); err != nil {
+				return err
+			}
+		}
+	case mediaTypeOCIManifest, mediaTypeDockerManifest:
+		blobs := manifest.Layers
+		if manifest.Config != nil {
+			blobs = append([]ociDescriptor{*manifest.Config}, blobs...)
+		}
+		for _, blob := range blobs {
+			if err := copyBlob(ctx, src, dest, srcRepo, destRepo, blob); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported manifest media type: %s", mediaType)
+	}
+	return dest.putManifest(ctx, destRepo, reference, mediaType, data)
+}
+
+// copyBlob переносит blob, если его еще нет в репозитории назначения
+func copyBlob(ctx context.Context, src, dest *registryClient, srcRepo, destRepo string, blob ociDescriptor) error {
+	exists, err := dest.hasBlob(ctx, destRepo, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	reader, size, err := src.getBlob(ctx, srcRepo, blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if size < 0 {
+		size = blob.Size
+	}
+	return dest.putBlob(ctx, destRepo, blob.Digest, reader, size)
+}
+
+// tagAllowed проверяет тег по шаблонам include/exclude из конфигурации.
+// Пустой include разрешает все теги, exclude имеет приоритет
+func tagAllowed(tag string, include, exclude []*regexp.Regexp) bool {
+	for _, pattern := range exclude {
+		if pattern.MatchString(tag) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if pattern.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePatterns компилирует список регулярных выражений из конфигурации
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// syncRegistry переносит образы из реестра контейнеров проекта в реестр Gitlab-destination
+func syncRegistry(ctx context.Context, config Config, generalLogger *log.Logger, srcProjectID int, destProjectPath string) {
+	fmt.Printf("[DEBUG] syncRegistry-> Syncing container registry of project ID: %d -> %s\n", srcProjectID, destProjectPath)
+	generalLogger.Printf("[DEBUG] syncRegistry-> Syncing container registry of project ID: %d -> %s\n", srcProjectID, destProjectPath)
+	repositories, err := getAllPages[RegistryRepository](ctx, fmt.Sprintf("%s/api/v4/projects/%d/registry/repositories", config.GitlabURLSource, srcProjectID), config.PrivateTokenSource)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to get registry repositories of project ID %d: %v\n", srcProjectID, err)
+		generalLogger.Printf("[ERROR] Failed to get registry repositories of project ID %d: %v\n", srcProjectID, err)
+		return
+	}
+	src := newRegistryClient(config.RegistryURLSource, config.RegistryUserSource, config.PrivateTokenSource, nil)
+	dest := newRegistryClient(config.RegistryURLDest, config.RegistryUserDest, config.PrivateTokenDest, nil)
+	for _, repository := range repositories {
+		// Путь в реестре назначения повторяет путь проекта на Gitlab-destination
+		destRepo := strings.ToLower(destProjectPath)
+		if repository.Name != "" {
+			destRepo += "/" + repository.Name
+		}
+		tags, err := getAllPages[RegistryTag](ctx, fmt.Sprintf("%s/api/v4/projects/%d/registry/repositories/%d/tags", config.GitlabURLSource, srcProjectID, repository.ID), config.PrivateTokenSource)
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to get tags of registry repository %s: %v\n", repository.Path, err)
+			generalLogger.Printf("[ERROR] Failed to get tags of registry repository %s: %v\n", repository.Path, err)
+			continue
+		}
+		for _, tag := range tags {
+			if !tagAllowed(tag.Name, config.registryTagInclude, config.registryTagExclude) {
+				continue
+			}
+			fmt.Printf("[DEBUG] Copying image %s:%s -> %s:%s\n", repository.Path, tag.Name, destRepo, tag.Name)
+			generalLogger.Printf("[DEBUG] Copying image %s:%s -> %s:%s\n", repository.Path, tag.Name, destRepo, tag.Name)
+			if err := copyImage(ctx, src, dest, repository.Path, destRepo, tag.Name); err != nil {
+				fmt.Printf("[ERROR] Failed to copy image %s:%s: %v\n", repository.Path, tag.Name, err)
+				generalLogger.Printf("[ERROR] Failed to copy image %s:%s: %v\n", repository.Path, tag.Name, err)
+				continue
+			}
+		}
+	}
+	fmt.Printf("[SUCCESS] syncRegistry<- Container registry of project ID %d synced\n", srcProjectID)
+	generalLogger.Printf("[SUCCESS] syncRegistry<- Container registry of project ID %d synced\n", srcProjectID)
+}
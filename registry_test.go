@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeManifest -- манифест фейкового реестра с его типом
+type fakeManifest struct {
+	mediaType string
+	data      []byte
+}
+
+// fakeRegistry -- реестр контейнеров в памяти по протоколу OCI distribution с bearer токенами,
+// как у Gitlab: без токена /v2/... отвечает 401 с адресом /token, который выдает токен по логину и паролю
+type fakeRegistry struct {
+	t        *testing.T
+	server   *httptest.Server
+	username string
+	password string
+
+	mu        sync.Mutex
+	blobs     map[string][]byte       // "<репозиторий>@<digest>" -> содержимое
+	manifests map[string]fakeManifest // "<репозиторий>:<тег или digest>" -> манифест
+	tokens    map[string]string       // выданный токен -> scope
+	nextID    int
+	// Журналы: scope каждого запроса токена, digest каждого загруженного blob и запросы "<метод> <путь>",
+	// получившие 401
+	tokenRequests []string
+	uploads       []string
+	unauthorized  []string
+}
+
+// newFakeRegistry запускает фейковый реестр, который выдает токены только по username/password
+func newFakeRegistry(t *testing.T, username, password string) *fakeRegistry {
+	t.Helper()
+	fake := &fakeRegistry{
+		t:         t,
+		username:  username,
+		password:  password,
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string]fakeManifest),
+		tokens:    make(map[string]string),
+	}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+// URL -- адрес фейкового реестра (как registryURLSource/registryURLDest в конфигурации)
+func (f *fakeRegistry) URL() string {
+	return f.server.URL
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// addBlob кладет blob в репозиторий
+func (f *fakeRegistry) addBlob(repo, content string) ociDescriptor {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	digest := sha256Digest([]byte(content))
+	f.blobs[repo+"@"+digest] = []byte(content)
+	return ociDescriptor{MediaType: "application/vnd.oci.image.layer.v1.tar", Digest: digest, Size: int64(len(content))}
+}
+
+// addManifest кладет манифест в репозиторий по его digest и, если задан, по тегу
+func (f *fakeRegistry) addManifest(repo, tag, mediaType string, manifest ociManifest) ociDescriptor {
+	f.t.Helper()
+	manifest.MediaType = mediaType
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	digest := sha256Digest(data)
+	f.manifests[repo+":"+digest] = fakeManifest{mediaType: mediaType, data: data}
+	if tag != "" {
+		f.manifests[repo+":"+tag] = fakeManifest{mediaType: mediaType, data: data}
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+}
+
+// addImage кладет в репозиторий образ из конфигурации и слоев с заданным содержимым
+func (f *fakeRegistry) addImage(repo, tag, config string, layers ...string) ociDescriptor {
+	manifest := ociManifest{}
+	configBlob := f.addBlob(repo, config)
+	manifest.Config = &configBlob
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, f.addBlob(repo, layer))
+	}
+	return f.addManifest(repo, tag, mediaTypeOCIManifest, manifest)
+}
+
+// manifest возвращает манифест по тегу или digest
+func (f *fakeRegistry) manifest(repo, reference string) (fakeManifest, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	manifest, ok := f.manifests[repo+":"+reference]
+	return manifest, ok
+}
+
+// revokeTokens отзывает все выданные токены
+func (f *fakeRegistry) revokeTokens() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = make(map[string]string)
+}
+
+// registryPath разбирает путь /v2/<репозиторий>/<blobs|manifests>/<reference> (репозиторий содержит "/")
+var registryPath = regexp.MustCompile(`^/v2/(.+?)/(blobs/uploads|blobs|manifests)/(.*)$`)
+
+func (f *fakeRegistry) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/token" {
+		f.issueToken(w, r)
+		return
+	}
+	match := registryPath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		f.t.Errorf("fake registry: unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	repo, kind, reference := match[1], match[2], match[3]
+	push := r.Method != http.MethodGet && r.Method != http.MethodHead
+	if !f.authorized(r, repo, push) {
+		f.unauthorized = append(f.unauthorized, r.Method+" "+r.URL.Path)
+		scope := "pull"
+		if push {
+			scope = "push,pull"
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="container_registry",scope="repository:%s:%s"`, f.server.URL, repo, scope))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.Method + " " + kind {
+	case "HEAD blobs", "GET blobs":
+		blob, ok := f.blobs[repo+"@"+reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(blob)
+		}
+	case "POST blobs/uploads":
+		f.nextID++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/upload-%d", repo, f.nextID))
+		w.WriteHeader(http.StatusAccepted)
+	case "PUT blobs/uploads":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		digest := r.URL.Query().Get("digest")
+		if sha256Digest(data) != digest {
+			http.Error(w, "DIGEST_INVALID", http.StatusBadRequest)
+			return
+		}
+		f.blobs[repo+"@"+digest] = data
+		f.uploads = append(f.uploads, digest)
+		w.WriteHeader(http.StatusCreated)
+	case "GET manifests":
+		manifest, ok := f.manifests[repo+":"+reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", manifest.mediaType)
+		w.Write(manifest.data)
+	case "PUT manifests":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Настоящий реестр не принимает манифест, пока в репозитории нет всего, на что он ссылается
+		var manifest ociManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, child := range manifest.Manifests {
+			if _, ok := f.manifests[repo+":"+child.Digest]; !ok {
+				http.Error(w, "MANIFEST_BLOB_UNKNOWN", http.StatusBadRequest)
+				return
+			}
+		}
+		blobs := manifest.Layers
+		if manifest.Config != nil {
+			blobs = append(blobs, *manifest.Config)
+		}
+		for _, blob := range blobs {
+			if _, ok := f.blobs[repo+"@"+blob.Digest]; !ok {
+				http.Error(w, "MANIFEST_BLOB_UNKNOWN", http.StatusBadRequest)
+				return
+			}
+		}
+		stored := fakeManifest{mediaType: r.Header.Get("Content-Type"), data: data}
+		f.manifests[repo+":"+reference] = stored
+		f.manifests[repo+":"+sha256Digest(data)] = stored
+		w.WriteHeader(http.StatusCreated)
+	default:
+		f.t.Errorf("fake registry: unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// issueToken выдает токен на запрошенный scope по логину и паролю (GET /token)
+func (f *fakeRegistry) issueToken(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); !ok || username != f.username || password != f.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	scope := r.URL.Query().Get("scope")
+	f.tokenRequests = append(f.tokenRequests, scope)
+	f.nextID++
+	token := fmt.Sprintf("token-%d", f.nextID)
+	f.tokens[token] = scope
+	writeJSON(w, http.StatusOK, map[string]interface{}{"token": token, "expires_in": 300})
+}
+
+// authorized проверяет, что bearer токен запроса выдан на репозиторий (и на запись, если push)
+func (f *fakeRegistry) authorized(r *http.Request, repo string, push bool) bool {
+	scope, ok := f.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		return false
+	}
+	if push {
+		return scope == "repository:"+repo+":pull,push"
+	}
+	return scope == "repository:"+repo+":pull" || scope == "repository:"+repo+":pull,push"
+}
+
+// TestCopyImageIndex проверяет перенос мультиархитектурного образа: вложенные манифесты и их blob
+// переносятся до индекса, а blob, которые уже есть в реестре назначения, не загружаются
+func TestCopyImageIndex(t *testing.T) {
+	src := newFakeRegistry(t, "src-user", "source-token")
+	dest := newFakeRegistry(t, "dest-user", "dest-token")
+	amd64 := src.addImage("xxxxx/app", "", "config amd64", "base layer", "amd64 layer")
+	arm64 := src.addImage("xxxxx/app", "", "config arm64", "base layer", "arm64 layer")
+	index := src.addManifest("xxxxx/app", "v1.0.0", mediaTypeOCIIndex, ociManifest{Manifests: []ociDescriptor{amd64, arm64}})
+	// Общий базовый слой уже есть в реестре назначения
+	base := dest.addBlob("mock-sync/xxxxx/app", "base layer")
+	srcClient := newRegistryClient(src.URL(), "src-user", "source-token", nil)
+	destClient := newRegistryClient(dest.URL(), "dest-user", "dest-token", nil)
+
+	if err := copyImage(context.Background(), srcClient, destClient, "xxxxx/app", "mock-sync/xxxxx/app", "v1.0.0"); err != nil {
+		t.Fatalf("copyImage: %v", err)
+	}
+
+	got, ok := dest.manifest("mock-sync/xxxxx/app", "v1.0.0")
+	want, _ := src.manifest("xxxxx/app", "v1.0.0")
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("destination manifest v1.0.0 = %+v, want %+v", got, want)
+	}
+	for _, child := range []ociDescriptor{amd64, arm64} {
+		if _, ok := dest.manifest("mock-sync/xxxxx/app", child.Digest); !ok {
+			t.Errorf("child manifest %s is missing in destination", child.Digest)
+		}
+	}
+	var wantUploads []string
+	for _, content := range []string{"config amd64", "amd64 layer", "config arm64", "arm64 layer"} {
+		wantUploads = append(wantUploads, sha256Digest([]byte(content)))
+	}
+	sort.Strings(wantUploads)
+	sort.Strings(dest.uploads)
+	if !reflect.DeepEqual(dest.uploads, wantUploads) {
+		t.Errorf("uploaded blobs = %v, want %v (without existing %s)", dest.uploads, wantUploads, base.Digest)
+	}
+	// Токен получается один раз на scope
+	if want := []string{"repository:xxxxx/app:pull"}; !reflect.DeepEqual(src.tokenRequests, want) {
+		t.Errorf("source token requests = %q, want %q", src.tokenRequests, want)
+	}
+	if want := []string{"repository:mock-sync/xxxxx/app:pull,push"}; !reflect.DeepEqual(dest.tokenRequests, want) {
+		t.Errorf("destination token requests = %q, want %q", dest.tokenRequests, want)
+	}
+	if index.Digest != sha256Digest(got.data) {
+		t.Errorf("destination index digest = %s, want %s", sha256Digest(got.data), index.Digest)
+	}
+}
+
+// TestRegistryTokenRefresh проверяет, что истекший токен получается заново до запроса, а отозванный --
+// по ответу 401
+func TestRegistryTokenRefresh(t *testing.T) {
+	tests := []struct {
+		name             string
+		invalidate       func(client *registryClient, registry *fakeRegistry)
+		wantUnauthorized int
+	}{
+		{
+			name: "expired",
+			invalidate: func(client *registryClient, _ *fakeRegistry) {
+				for scope, token := range client.tokens {
+					token.expires = time.Now().Add(-time.Second)
+					client.tokens[scope] = token
+				}
+			},
+		},
+		{
+			name: "revoked",
+			invalidate: func(_ *registryClient, registry *fakeRegistry) {
+				registry.revokeTokens()
+			},
+			wantUnauthorized: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := newFakeRegistry(t, "src-user", "source-token")
+			dest := newFakeRegistry(t, "dest-user", "dest-token")
+			src.addImage("xxxxx/app", "v1.0.0", "config v1", "layer v1")
+			src.addImage("xxxxx/app", "v2.0.0", "config v2", "layer v2")
+			srcClient := newRegistryClient(src.URL(), "src-user", "source-token", nil)
+			destClient := newRegistryClient(dest.URL(), "dest-user", "dest-token", nil)
+			if err := copyImage(context.Background(), srcClient, destClient, "xxxxx/app", "mock-sync/xxxxx/app", "v1.0.0"); err != nil {
+				t.Fatalf("copyImage v1.0.0: %v", err)
+			}
+			unauthorized := len(dest.unauthorized)
+
+			tt.invalidate(destClient, dest)
+			if err := copyImage(context.Background(), srcClient, destClient, "xxxxx/app", "mock-sync/xxxxx/app", "v2.0.0"); err != nil {
+				t.Fatalf("copyImage v2.0.0: %v", err)
+			}
+
+			if _, ok := dest.manifest("mock-sync/xxxxx/app", "v2.0.0"); !ok {
+				t.Error("v2.0.0 is missing in destination")
+			}
+			if got := len(dest.tokenRequests); got != 2 {
+				t.Errorf("destination token requests = %q, want initial and refreshed", dest.tokenRequests)
+			}
+			if got := len(dest.unauthorized) - unauthorized; got != tt.wantUnauthorized {
+				t.Errorf("unauthorized requests after invalidation = %q, want %d", dest.unauthorized[unauthorized:], tt.wantUnauthorized)
+			}
+		})
+	}
+}
+
+// TestSyncRegistryTags проверяет, что при синхронизации переносятся только теги, разрешенные
+// registryTagInclude и не запрещенные registryTagExclude
+func TestSyncRegistryTags(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newSourceFixture(t)
+	source.projectByPath("xxxxx/app").RegistryTags = []string{"v1.0.0", "v1.1.0-rc1", "latest"}
+	dest := newFakeGitLab(t, "dest-token")
+	srcRegistry := newFakeRegistry(t, "src-user", "source-token")
+	destRegistry := newFakeRegistry(t, "dest-user", "dest-token")
+	for _, tag := range []string{"v1.0.0", "v1.1.0-rc1", "latest"} {
+		srcRegistry.addImage("xxxxx/app", tag, "config "+tag, "layer "+tag)
+	}
+	config := newTestConfig(t, source, dest, func(config *Config) {
+		config.RegistrySync = true
+		config.RegistryURLSource = srcRegistry.URL()
+		config.RegistryUserSource = "src-user"
+		config.RegistryURLDest = destRegistry.URL()
+		config.RegistryUserDest = "dest-user"
+		config.RegistryTagInclude = []string{`^v\d`}
+		config.RegistryTagExclude = []string{`-rc\d*$`}
+	})
+
+	runSync(t, config)
+
+	for tag, wantCopied := range map[string]bool{"v1.0.0": true, "v1.1.0-rc1": false, "latest": false} {
+		if _, copied := destRegistry.manifest("mock-sync/xxxxx/app", tag); copied != wantCopied {
+			t.Errorf("tag %s copied = %v, want %v", tag, copied, wantCopied)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Release отображает релиз проекта в gitlab
+type Release struct {
+	TagName     string `json:"tag_name"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ReleasedAt  string `json:"released_at"`
+	Milestones  []struct {
+		Title string `json:"title"`
+	} `json:"milestones"`
+	Assets struct {
+		Links []ReleaseLink `json:"links"`
+	} `json:"assets"`
+}
+
+// ReleaseLink отображает ссылку на ассет релиза
+type ReleaseLink struct {
+	Name            string `json:"name"`
+	URL             string `json:"url"`
+	DirectAssetPath string `json:"direct_asset_path,omitempty"`
+	LinkType        string `json:"link_type,omitempty"`
+}
+
+// Tag отображает тег репозитория
+type Tag struct {
+	Name string `json:"name"`
+}
+
+// genericPackageURL разбирает ссылку на файл generic пакета: .../api/v4/projects/:id/packages/generic/:name/:version/:file
+var genericPackageURL = regexp.MustCompile(`/api/v4/projects/([^/]+)/packages/generic/([^/]+)/([^/]+)/(.+)$`)
+
+// syncReleases воссоздает релизы проекта на Gitlab-destination для перенесенных тегов.
+// Evidence через API создать нельзя, gitlab собирает его сам при создании релиза
+func syncReleases(ctx context.Context, config Config, generalLogger *log.Logger, srcProjectID, destProjectID int) {
+	fmt.Printf("[DEBUG] syncReleases-> Syncing releases of project ID: %d -> %d\n", srcProjectID, destProjectID)
+	generalLogger.Printf("[DEBUG] syncReleases-> Syncing releases of project ID: %d -> %d\n", srcProjectID, destProjectID)
+	releases, err := getAllPages[Release](ctx, fmt.Sprintf("%s/api/v4/projects/%d/releases", config.GitlabURLSource, srcProjectID), config.PrivateTokenSource)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to get releases of project ID %d: %v\n", srcProjectID, err)
+		generalLogger.Printf("[ERROR] Failed to get releases of project ID %d: %v\n", srcProjectID, err)
+		return
+	}
+	if len(releases) == 0 {
+		return
+	}
+	// Релизы создаем только для тегов, которые уже есть на Gitlab-destination
+	destTags, err := getAllPages[Tag](ctx, fmt.Sprintf("%s/api/v4/projects/%d/repository/tags", config.GitlabURLDest, destProjectID), config.PrivateTokenDest)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to get tags of destination project ID %d: %v\n", destProjectID, err)
+		generalLogger.Printf("[ERROR] Failed to get tags of destination project ID %d: %v\n", destProjectID, err)
+		return
+	}
+	syncedTags := make(map[string]bool, len(destTags))
+	for _, tag := range destTags {
+		syncedTags[tag.Name] = true
+	}
+	for _, release := range releases {
+		if !syncedTags[release.TagName] {
+			fmt.Printf("[WARNING] Tag %s is not synced, skip release\n", release.TagName)
+			generalLogger.Printf("[WARNING] Tag %s is not synced, skip release\n", release.TagName)
+			continue
+		}
+		var milestones []string
+		for _, milestone := range release.Milestones {
+			if err := ensureMilestone(ctx, config.GitlabURLDest, config.PrivateTokenDest, destProjectID, milestone.Title); err != nil {
+				fmt.Printf("[WARNING] Failed to create milestone %s: %v\n", milestone.Title, err)
+				generalLogger.Printf("[WARNING] Failed to create milestone %s: %v\n", milestone.Title, err)
+				continue
+			}
+			milestones = append(milestones, milestone.Title)
+		}
+		links := make([]ReleaseLink, 0, len(release.Assets.Links))
+		for _, link := range release.Assets.Links {
+			if config.ReleasesCopyAssets {
+				newURL, err := copyGenericAsset(ctx, config, link.URL, destProjectID)
+				if err != nil {
+					fmt.Printf("[WARNING] Failed to copy release asset %s: %v\n", link.URL, err)
+					generalLogger.Printf("[WARNING] Failed to copy release asset %s: %v\n", link.URL, err)
+				} else if newURL != "" {
+					link.URL = newURL
+				}
+			}
+			links = append(links, link)
+		}
+		if err := createRelease(ctx, config.GitlabURLDest, config.PrivateTokenDest, destProjectID, release, milestones, links); err != nil {
+			fmt.Printf("[ERROR] Failed to create release %s: %v\n", release.TagName, err)
+			generalLogger.Printf("[ERROR] Failed to create release %s: %v\n", release.TagName, err)
+			continue
+		}
+	}
+	fmt.Printf("[SUCCESS] syncReleases<- Releases of project ID %d synced\n", srcProjectID)
+	generalLogger.Printf("[SUCCESS] syncReleases<- Releases of project ID %d synced\n", srcProjectID)
+}
+
+// createRelease создает релиз, а если он уже существует -- обновляет описание, имя и milestones и добавляет
+// недостающие ссылки
+func createRelease(ctx context.Context, gitlabURL, token string, projectID int, release Release, milestones []string, links []ReleaseLink) error {
+	data := map[string]interface{}{
+		"tag_name":    release.TagName,
+		"name":        release.Name,
+		"description": release.Description,
+		"milestones":  milestones,
+		"assets":      map[string]interface{}{"links": links},
+	}
+	if release.ReleasedAt != "" {
+		data["released_at"] = release.ReleasedAt
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	resp, err := doRequest(ctx, "POST", fmt.Sprintf("%s/api/v4/projects/%d/releases", gitlabURL, projectID), token, bytes.NewBuffer(body), "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		return nil
+	}
+	if resp.StatusCode != http.StatusConflict {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to create release. Status: %d, Response: %s", resp.StatusCode, string(respBody))
+	}
+	// Релиз уже существует -- обновим его, а ссылки добавим только недостающие, чтобы не задвоить их
+	delete(data, "assets")
+	delete(data, "tag_name")
+	body, err = json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	updateResp, err := doRequest(ctx, "PUT", fmt.Sprintf("%s/api/v4/projects/%d/releases/%s", gitlabURL, projectID, url.PathEscape(release.TagName)), token, bytes.NewBuffer(body), "application/json")
+	if err != nil {
+		return err
+	}
+	defer updateResp.Body.Close()
+	if updateResp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(updateResp.Body)
+		return fmt.Errorf("failed to update release. Status: %d, Response: %s", updateResp.StatusCode, string(respBody))
+	}
+	return addMissingReleaseLinks(ctx, gitlabURL, token, projectID, release.TagName, links)
+}
+
+// addMissingReleaseLinks добавляет в существующий релиз ссылки, которых в нем нет. Gitlab не допускает
+// в релизе двух ссылок с одинаковым именем или адресом, поэтому ссылка с таким же именем или адресом считается перенесенной
+func addMissingReleaseLinks(ctx context.Context, gitlabURL, token string, projectID int, tagName string, links []ReleaseLink) error {
+	linksURL := fmt.Sprintf("%s/api/v4/projects/%d/releases/%s/assets/links", gitlabURL, projectID, url.PathEscape(tagName))
+	existing, err := getAllPages[ReleaseLink](ctx, linksURL, token)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(existing))
+	urls := make(map[string]bool, len(existing))
+	for _, link := range existing {
+		names[link.Name] = true
+		urls[link.URL] = true
+	}
+	for _, link := range links {
+		if names[link.Name] || urls[link.URL] {
+			continue
+		}
+		body, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		resp, err := doRequest(ctx, "POST", linksURL, token, bytes.NewBuffer(body), "application/json")
+		if err != nil {
+			return err
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			return fmt.Errorf("failed to add release link %s. Status: %d, Response: %s", link.Name, resp.StatusCode, string(respBody))
+		}
+		names[link.Name] = true
+		urls[link.URL] = true
+	}
+	return nil
+}
+
+// ensureMilestone создает milestone в проекте, если его еще нет (без него релиз не создастся)
+func ensureMilestone(ctx context.Context, gitlabURL, token string, projectID int, title string) error {
+	var milestones []struct {
+		ID int `json:"id"`
+	}
+	if err := getJSON(ctx, fmt.Sprintf("%s/api/v4/projects/%d/milestones?title=%s&include_parent_milestones=true", gitlabURL, projectID, url.QueryEscape(title)), token, &milestones); err != nil {
+		return err
+	}
+	if len(milestones) != 0 {
+		return nil
+	}
+	body, err := json.Marshal(map[string]string{"title": title})
+	if err != nil {
+		return err
+	}
+	resp, err := doRequest(ctx, "POST", fmt.Sprintf("%s/api/v4/projects/%d/milestones", gitlabURL, projectID), token, bytes.NewBuffer(body), "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to create milestone. Status: %d, Response: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// copyGenericAsset скачивает файл generic пакета с Gitlab-source и загружает его в тот же пакет
+// проекта на Gitlab-destination. Возвращает новую ссылку или "", если ссылка не ведет на generic пакет Gitlab-source
+func copyGenericAsset(ctx context.Context, config Config, assetURL string, destProjectID int) (string, error) {
+	if !strings.HasPrefix(assetURL, config.GitlabURLSource) {
+		return "", nil
+	}
+	match := genericPackageURL.FindStringSubmatch(assetURL)
+	if match == nil {
+		return "", nil
+	}
+	packageName, packageVersion, fileName := match[2], match[3], match[4]
+	// Скачаем файл во временную директорию
+	tmpFile, _, err := downloadToTemp(ctx, assetURL, config.PrivateTokenSource)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	newURL := fmt.Sprintf("%s/api/v4/projects/%d/packages/generic/%s/%s/%s", config.GitlabURLDest, destProjectID, packageName, packageVersion, fileName)
+	if err := uploadFile(ctx, newURL, config.PrivateTokenDest, tmpFile); err != nil {
+		return "", err
+	}
+	return newURL, nil
+}
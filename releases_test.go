@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestSyncReleases проверяет перенос релизов: milestones создаются, файл generic пакета из ссылки загружается
+// на Gitlab-destination и ссылка переписывается на него, а в существующий релиз добавляются только новые ссылки
+func TestSyncReleases(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newFakeGitLab(t, "source-token")
+	root := source.addGroup("xxxxx", "xxxxx", nil)
+	app := source.addProject(root, "App", "app", []map[string]string{{"README.md": "app\n"}}, nil, []string{"v1.0.0"})
+	app.Packages = map[string]map[string]string{"tool/1.0.0": {"tool.bin": "binary\n"}}
+	app.Milestones = []string{"1.0"}
+	docs := ReleaseLink{Name: "docs", URL: "https://example.com/docs", LinkType: "other"}
+	app.Releases = []*fakeRelease{
+		{
+			TagName:     "v1.0.0",
+			Name:        "Release 1.0",
+			Description: "First release",
+			Milestones:  []string{"1.0"},
+			Links: []ReleaseLink{
+				{Name: "tool.bin", URL: fmt.Sprintf("%s/api/v4/projects/%d/packages/generic/tool/1.0.0/tool.bin", source.URL(), app.ID), LinkType: "package"},
+				docs,
+			},
+		},
+		// Тег не перенесен -- релиз пропускается
+		{TagName: "v2.0.0", Name: "Release 2.0"},
+	}
+	dest := newFakeGitLab(t, "dest-token")
+	configure := func(config *Config) {
+		config.ReleasesSync = true
+		config.ReleasesCopyAssets = true
+	}
+	config := newTestConfig(t, source, dest, configure)
+
+	runSync(t, config)
+
+	destApp := dest.projectByPath("mock-sync/xxxxx/app")
+	if destApp == nil {
+		t.Fatal("project was not transferred")
+	}
+	if want := []string{"1.0"}; !reflect.DeepEqual(destApp.Milestones, want) {
+		t.Errorf("milestones = %v, want %v", destApp.Milestones, want)
+	}
+	if len(destApp.Releases) != 1 {
+		t.Fatalf("releases = %+v, want only v1.0.0", destApp.Releases)
+	}
+	release := destApp.Releases[0]
+	if release.TagName != "v1.0.0" || release.Name != "Release 1.0" || release.Description != "First release" || !reflect.DeepEqual(release.Milestones, []string{"1.0"}) {
+		t.Errorf("release = %+v, want copy of source release", release)
+	}
+	assetURL := fmt.Sprintf("%s/api/v4/projects/%d/packages/generic/tool/1.0.0/tool.bin", dest.URL(), destApp.ID)
+	wantLinks := []ReleaseLink{{Name: "tool.bin", URL: assetURL, LinkType: "package"}, docs}
+	if !reflect.DeepEqual(release.Links, wantLinks) {
+		t.Errorf("release links = %+v, want %+v", release.Links, wantLinks)
+	}
+	if got := destApp.Packages["tool/1.0.0"]["tool.bin"]; got != "binary\n" {
+		t.Errorf("uploaded asset = %q, want source package file", got)
+	}
+
+	// В существующий релиз добавляется только новая ссылка
+	changelog := ReleaseLink{Name: "changelog", URL: "https://example.com/changelog", LinkType: "other"}
+	app.Releases[0].Links = append(app.Releases[0].Links, changelog)
+	config = newTestConfig(t, source, dest, configure)
+
+	runSync(t, config)
+
+	if len(destApp.Releases) != 1 {
+		t.Fatalf("releases after second run = %+v, want only v1.0.0", destApp.Releases)
+	}
+	wantLinks = append(wantLinks, changelog)
+	if !reflect.DeepEqual(destApp.Releases[0].Links, wantLinks) {
+		t.Errorf("release links after second run = %+v, want %+v", destApp.Releases[0].Links, wantLinks)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// reportFile -- файл с отчетом о последнем запуске
+const reportFile = "run-report.json"
+
+// Статусы переноса проекта в отчете
+const (
+	statusSuccess = "success"
+	statusFailed  = "failed"
+	statusSkipped = "skipped"
+	// Проект не перенесен из-за найденных секретов
+	statusBlocked     = "blocked"
+	statusQuarantined = "quarantined"
+)
+
+// ProjectReport -- результат переноса одного проекта
+type ProjectReport struct {
+	Project string    `json:"project"`
+	Mode    string    `json:"mode"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	LFS     *LFSStats `json:"lfs,omitempty"`
+	// Размер репозитория по статистике Gitlab-source, байт
+	RepositorySize int64 `json:"repository_size,omitempty"`
+	// Количество возможных секретов, найденных в истории
+	SecretFindings int `json:"secret_findings,omitempty"`
+	// Результаты пуша ссылок: сколько запушено и какие отклонены
+	PushedRefs int             `json:"pushed_refs,omitempty"`
+	FailedRefs []RefPushResult `json:"failed_refs,omitempty"`
+	// Импорт архива: сколько было попыток, import_error и данные, которые Gitlab-destination не импортировал
+	ImportAttempts  int              `json:"import_attempts,omitempty"`
+	ImportError     string           `json:"import_error,omitempty"`
+	FailedRelations []FailedRelation `json:"failed_relations,omitempty"`
+	// Проект перенесен через clone после неудачного импорта архива. Репозиторий пушится в проект, который
+	// оставил неудачный импорт (ReusedProject): в нем могут быть частично импортированные данные
+	FallbackClone bool `json:"fallback_clone,omitempty"`
+	ReusedProject bool `json:"reused_project,omitempty"`
+	// Участники, которых не удалось сопоставить с пользователями Gitlab-destination (username на Gitlab-source)
+	UnmatchedMembers []string `json:"unmatched_members,omitempty"`
+}
+
+// GroupReport -- результат переноса группы. В отчет попадают только группы, участники которых перенесены не все
+type GroupReport struct {
+	Group            string   `json:"group"`
+	UnmatchedMembers []string `json:"unmatched_members,omitempty"`
+}
+
+// addRefResults добавляет в отчет результаты пуша ссылок
+func (p *ProjectReport) addRefResults(results []RefPushResult) {
+	for _, result := range results {
+		if result.Status == pushStatusOK {
+			p.PushedRefs++
+			continue
+		}
+		p.FailedRefs = append(p.FailedRefs, result)
+	}
+}
+
+// RunReport -- отчет о запуске программы, сохраняется в run-report.json
+type RunReport struct {
+	mu         sync.Mutex
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Запуск прерван (Ctrl-C, SIGTERM): проекты после последнего в списке не переносились
+	Interrupted bool             `json:"interrupted,omitempty"`
+	Groups      []*GroupReport   `json:"groups,omitempty"`
+	Projects    []*ProjectReport `json:"projects"`
+}
+
+// newRunReport создает пустой отчет
+func newRunReport(startedAt time.Time) *RunReport {
+	return &RunReport{StartedAt: startedAt}
+}
+
+// add добавляет результат переноса проекта в отчет
+func (r *RunReport) add(entry *ProjectReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Projects = append(r.Projects, entry)
+}
+
+// addGroup добавляет результат переноса группы в отчет
+func (r *RunReport) addGroup(entry *GroupReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Groups = append(r.Groups, entry)
+}
+
+// interrupt отмечает прерванный запуск и возвращает проект, перенос которого был начат последним
+func (r *RunReport) interrupt() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Interrupted = true
+	if len(r.Projects) == 0 {
+		return ""
+	}
+	return r.Projects[len(r.Projects)-1].Project
+}
+
+// save записывает отчет в файл
+func (r *RunReport) save(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FinishedAt = time.Now()
+	data, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
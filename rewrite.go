@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// commitMapsDir -- директория с таблицами соответствия коммитов source -> rewritten
+const commitMapsDir = "./commit-maps"
+
+// HistoryFilter -- что удаляется из истории проекта перед пушем на Gitlab-destination
+type HistoryFilter struct {
+	DropPaths   []string `json:"dropPaths"`   // Файлы и директории, которые удаляются из всех коммитов
+	MaxFileSize int64    `json:"maxFileSize"` // Файлы больше этого размера в байтах удаляются (0 -- без ограничения)
+	DenyBlobs   []string `json:"denyBlobs"`   // Шаблоны (path.Match) имени или пути файлов, которые удаляются
+}
+
+// historyFilterFor возвращает фильтр истории для проекта: ищется сам проект, затем его группы
+func historyFilterFor(config Config, fullPath string) (HistoryFilter, bool) {
+	for path := fullPath; path != ""; {
+		if filter, ok := config.HistoryFilters[path]; ok {
+			return filter, true
+		}
+		slash := strings.LastIndex(path, "/")
+		if slash < 0 {
+			break
+		}
+		path = path[:slash]
+	}
+	return HistoryFilter{}, false
+}
+
+// historyRewriter переписывает поток git fast-export. Перезапись детерминирована: одна и та же
+// история с одним и тем же фильтром всегда дает одни и те же SHA коммитов
+type historyRewriter struct {
+	filter     HistoryFilter
+	identities *identityMapper // nil -- авторы не переписываются
+	// Метки blob'ов, удаленных по размеру
+	droppedBlobs map[string]bool
+	// Метка коммита -> SHA коммита на Gitlab-source
+	commits map[string]string
+	// Метки коммитов в порядке выгрузки
+	marks []string
+}
+
+// newHistoryRewriter создает переписчик истории с фильтром и перезаписью авторов
+func newHistoryRewriter(filter HistoryFilter, identities *identityMapper) *historyRewriter {
+	return &historyRewriter{
+		filter:       filter,
+		identities:   identities,
+		droppedBlobs: make(map[string]bool),
+		commits:      make(map[string]string),
+	}
+}
+
+// pathDropped проверяет, удаляется ли файл из истории
+func (r *historyRewriter) pathDropped(filePath string) bool {
+	for _, drop := range r.filter.DropPaths {
+		drop = strings.Trim(drop, "/")
+		if filePath == drop || strings.HasPrefix(filePath, drop+"/") {
+			return true
+		}
+	}
+	for _, pattern := range r.filter.DenyBlobs {
+		if matched, _ := path.Match(pattern, filePath); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, path.Base(filePath)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// unquotePath снимает кавычки с пути в формате git (C-style)
+func unquotePath(quoted string) string {
+	if !strings.HasPrefix(quoted, `"`) {
+		return quoted
+	}
+	unquoted, err := strconv.Unquote(quoted)
+	if err != nil {
+		return quoted
+	}
+	return unquoted
+}
+
+// readData читает содержимое команды data <n>
+func readData(reader *bufio.Reader, line string) ([]byte, error) {
+	size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "data ")))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported fast-export data: %s", strings.TrimSpace(line))
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// rewrite читает поток fast-export из in и пишет измененный поток для fast-import в out
+func (r *historyRewriter) rewrite(in io.Reader, out io.Writer) error {
+	reader := bufio.NewReader(in)
+	writer := bufio.NewWriter(out)
+	// Заголовок blob'а копится до data, потому что blob может быть удален целиком
+	var blob []string
+	var blobMark, mark string
+	inBlob, inCommit := false, false
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		switch {
+		case line == "blob\n":
+			inBlob, inCommit = true, false
+			blob = []string{line}
+			blobMark = ""
+			continue
+		case strings.HasPrefix(line, "commit "):
+			inBlob, inCommit = false, true
+			mark = ""
+		case strings.HasPrefix(line, "tag "), strings.HasPrefix(line, "reset "):
+			inBlob, inCommit = false, false
+		case strings.HasPrefix(line, "data "):
+			data, err := readData(reader, line)
+			if err != nil {
+				return err
+			}
+			if inBlob {
+				inBlob = false
+				if r.filter.MaxFileSize > 0 && int64(len(data)) > r.filter.MaxFileSize {
+					r.droppedBlobs[blobMark] = true
+					// Перевод строки после data относится к удаленному blob'у
+					if next, err := reader.Peek(1); err == nil && next[0] == '\n' {
+						reader.Discard(1)
+					}
+					continue
+				}
+				for _, header := range blob {
+					writer.WriteString(header)
+				}
+			}
+			writer.WriteString(line)
+			writer.Write(data)
+			continue
+		}
+		if inBlob {
+			if strings.HasPrefix(line, "mark ") {
+				blobMark = strings.TrimSpace(strings.TrimPrefix(line, "mark "))
+			}
+			blob = append(blob, line)
+			continue
+		}
+		if r.identities != nil && (strings.HasPrefix(line, "author ") || strings.HasPrefix(line, "committer ") || strings.HasPrefix(line, "tagger ")) {
+			line = r.identities.rewriteLine(line)
+		}
+		if inCommit {
+			switch {
+			case strings.HasPrefix(line, "mark "):
+				mark = strings.TrimSpace(strings.TrimPrefix(line, "mark "))
+			case strings.HasPrefix(line, "original-oid ") && mark != "":
+				r.commits[mark] = strings.TrimSpace(strings.TrimPrefix(line, "original-oid "))
+				r.marks = append(r.marks, mark)
+			case strings.HasPrefix(line, "M "):
+				// M <mode> <dataref> <path>
+				fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 4)
+				if len(fields) == 4 && (r.droppedBlobs[fields[2]] || r.pathDropped(unquotePath(fields[3]))) {
+					continue
+				}
+			case strings.HasPrefix(line, "D "):
+				if r.pathDropped(unquotePath(strings.TrimSuffix(line[2:], "\n"))) {
+					continue
+				}
+			}
+		}
+		writer.WriteString(line)
+	}
+	return writer.Flush()
+}
+
+// rewriteHistory переписывает все ссылки зеркала через git fast-export | fast-import и сохраняет
+// соответствие коммитов source -> rewritten в commit-maps/<путь проекта>.map
+func rewriteHistory(generalLogger *log.Logger, rewriter *historyRewriter, repoDir, fullPath string) error {
+	fmt.Println("[DEBUG] rewriteHistory-> Rewriting history of repository: ", fullPath)
+	generalLogger.Println("[DEBUG] rewriteHistory-> Rewriting history of repository: ", fullPath)
+	marksFile, err := os.CreateTemp(tmpDir, "marks-*")
+	if err != nil {
+		return err
+	}
+	marksFile.Close()
+	defer os.Remove(marksFile.Name())
+	marksPath, err := filepath.Abs(marksFile.Name())
+	if err != nil {
+		return err
+	}
+	// Вывод git пишется в лог, а не в консоль, как у остальных операций git
+	var exportOutput, importOutput bytes.Buffer
+	exportCmd := exec.Command("git", "-C", repoDir, "fast-export", "--all", "--show-original-ids", "--reencode=no", "--signed-tags=strip", "--tag-of-filtered-object=rewrite", "--fake-missing-tagger")
+	exportCmd.Stderr = &exportOutput
+	exported, err := exportCmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	importCmd := exec.Command("git", "-C", repoDir, "fast-import", "--force", "--quiet", "--export-marks="+marksPath)
+	importCmd.Stdout = &importOutput
+	importCmd.Stderr = &importOutput
+	imported, err := importCmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := exportCmd.Start(); err != nil {
+		return fmt.Errorf("failed to start fast-export: %w", err)
+	}
+	if err := importCmd.Start(); err != nil {
+		exportCmd.Process.Kill()
+		exportCmd.Wait()
+		return fmt.Errorf("failed to start fast-import: %w", err)
+	}
+	rewriteErr := rewriter.rewrite(exported, imported)
+	if rewriteErr != nil {
+		// fast-import обновляет ссылки по концу потока, поэтому неполный поток до него доходить не должен
+		importCmd.Process.Kill()
+		// Дочитаем выгрузку, чтобы fast-export не завис на записи
+		io.Copy(io.Discard, exported)
+	}
+	imported.Close()
+	exportErr := exportCmd.Wait()
+	importErr := importCmd.Wait()
+	logGitOutput(generalLogger, exportOutput.String())
+	logGitOutput(generalLogger, importOutput.String())
+	switch {
+	case rewriteErr != nil:
+		return fmt.Errorf("failed to rewrite history: %w", rewriteErr)
+	case exportErr != nil:
+		return fmt.Errorf("fast-export failed: %w: %s", exportErr, lastGitLines(exportOutput.String()))
+	case importErr != nil:
+		return fmt.Errorf("fast-import failed: %w: %s", importErr, lastGitLines(importOutput.String()))
+	}
+	return writeCommitMap(rewriter, marksPath, fullPath)
+}
+
+// writeCommitMap сохраняет соответствие коммитов source -> rewritten по файлу меток fast-import
+func writeCommitMap(rewriter *historyRewriter, marksPath, fullPath string) error {
+	data, err := os.ReadFile(marksPath)
+	if err != nil {
+		return fmt.Errorf("failed to read fast-import marks: %w", err)
+	}
+	rewritten := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if mark, sha, found := strings.Cut(line, " "); found {
+			rewritten[mark] = sha
+		}
+	}
+	mapPath := filepath.Join(commitMapsDir, fullPath+".map")
+	if err := os.MkdirAll(filepath.Dir(mapPath), 0755); err != nil {
+		return err
+	}
+	var commitMap strings.Builder
+	commitMap.WriteString("old new\n")
+	for _, mark := range rewriter.marks {
+		fmt.Fprintf(&commitMap, "%s %s\n", rewriter.commits[mark], rewritten[mark])
+	}
+	return os.WriteFile(mapPath, []byte(commitMap.String()), 0644)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestRewriteHistoryDeterministic проверяет, что перезапись одной и той же истории дважды дает те же
+// SHA коммитов и ту же таблицу соответствия коммитов
+func TestRewriteHistoryDeterministic(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newSourceFixture(t)
+	project := source.addProject(source.projectByPath("xxxxx/app").Namespace, "Secrets", "secrets", []map[string]string{
+		{"README.md": "secrets\n", "certs/server.pem": "pem\n"},
+		{"vendor/sdk/sdk.go": "package sdk\n", "src/main.go": "package main\n"},
+		{"src/main.go": "package main\n\nfunc main() {}\n", "big.bin": strings.Repeat("x", 64)},
+	}, []string{"develop"}, []string{"v1.0.0"})
+	filter := HistoryFilter{DropPaths: []string{"vendor/sdk"}, MaxFileSize: 32, DenyBlobs: []string{"*.pem"}}
+	discard := log.New(io.Discard, "", 0)
+
+	var refs []map[string]string
+	var commitMaps []string
+	for run := 0; run < 2; run++ {
+		mirrorDir := filepath.Join(t.TempDir(), "secrets.git")
+		source.git("", "clone", "--quiet", "--mirror", source.repoDir(project), mirrorDir)
+		if err := rewriteHistory(discard, newHistoryRewriter(filter, nil), mirrorDir, "xxxxx/secrets"); err != nil {
+			t.Fatalf("run %d: rewriteHistory: %v", run+1, err)
+		}
+		if files := source.git(mirrorDir, "log", "--all", "--name-only", "--format="); strings.Contains(files, "vendor/sdk") || strings.Contains(files, "server.pem") || strings.Contains(files, "big.bin") {
+			t.Errorf("run %d: filtered files left in history:\n%s", run+1, files)
+		}
+		mirrorRefs, err := listRefs(mirrorDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, mirrorRefs)
+		commitMap, err := os.ReadFile(filepath.Join(commitMapsDir, "xxxxx", "secrets.map"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		commitMaps = append(commitMaps, string(commitMap))
+	}
+
+	if !reflect.DeepEqual(refs[0], refs[1]) {
+		t.Errorf("refs after second rewrite = %v, want %v", refs[1], refs[0])
+	}
+	if refs[0]["refs/heads/main"] == source.refs("xxxxx/secrets")["refs/heads/main"] {
+		t.Error("main was not rewritten")
+	}
+	if commitMaps[0] != commitMaps[1] {
+		t.Errorf("commit map after second rewrite:\n%s\nwant:\n%s", commitMaps[1], commitMaps[0])
+	}
+	if lines := strings.Count(commitMaps[0], "\n"); lines != 4 {
+		t.Errorf("commit map has %d lines, want header and 3 commits:\n%s", lines, commitMaps[0])
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Политики проверки на секреты перед пушем на Gitlab-destination
+const (
+	secretPolicyReport     = "report"     // Только записать находки в лог
+	secretPolicyQuarantine = "quarantine" // Не пушить, а отложить репозиторий в quarantineDir для ручной проверки
+	secretPolicyBlock      = "block"      // Не пушить проект
+)
+
+const (
+	findingsLogFile     = "secret-findings.log"
+	quarantineDir       = "./quarantine"
+	secretScanMaxBlob   = 1024 * 1024 // Blob'ы больше 1 MiB не проверяем
+	secretMaxPerProject = 1000        // После стольких находок дальше не ищем
+)
+
+// errSecretGateClosed -- репозиторий не прошел проверку на секреты, статус проекта выставил secretGate
+var errSecretGateClosed = errors.New("repository did not pass secret scan")
+
+// SecretRule -- правило поиска секрета. Если задан Entropy, то совпадение (или его первая группа)
+// считается секретом, только если энтропия Шеннона не меньше Entropy
+type SecretRule struct {
+	Name    string  `json:"name"`
+	Pattern string  `json:"pattern"`
+	Entropy float64 `json:"entropy"`
+
+	re *regexp.Regexp
+}
+
+// SecretFinding -- найденный секрет
+type SecretFinding struct {
+	Rule string
+	Blob string
+	Path string
+	Line int
+	Text string // Замаскированное совпадение
+}
+
+// builtinSecretRules -- встроенный набор правил
+var builtinSecretRules = []SecretRule{
+	{Name: "private-key", Pattern: `-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`},
+	{Name: "aws-access-key", Pattern: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`},
+	{Name: "gitlab-token", Pattern: `\bgl(?:pat|dt|ptt|rt|cbt|oas)-[0-9A-Za-z_\-]{20,}`},
+	{Name: "github-token", Pattern: `\bgh[pousr]_[0-9A-Za-z]{36,}\b`},
+	{Name: "slack-token", Pattern: `\bxox[baprs]-[0-9A-Za-z\-]{10,}`},
+	{Name: "google-api-key", Pattern: `\bAIza[0-9A-Za-z_\-]{35}\b`},
+	{Name: "generic-secret", Pattern: `(?i)(?:password|passwd|secret|token|api[_-]?key)["']?\s*[:=]\s*["']?([^\s"'<>]{12,})`, Entropy: 3.5},
+}
+
+// compileSecretRules собирает встроенные правила и правила из конфигурации
+func compileSecretRules(extra []SecretRule) ([]SecretRule, error) {
+	rules := append(append([]SecretRule{}, builtinSecretRules...), extra...)
+	for i := range rules {
+		re, err := regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret rule %s: %w", rules[i].Name, err)
+		}
+		rules[i].re = re
+	}
+	return rules, nil
+}
+
+// shannonEntropy -- энтропия строки в битах на символ
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	length := float64(len([]rune(s)))
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// maskSecret оставляет от секрета только первые символы
+func maskSecret(s string) string {
+	if len(s) <= 4 {
+		return "****"
+	}
+	return s[:4] + strings.Repeat("*", 8)
+}
+
+// scanContent ищет секреты в содержимом одного файла
+func scanContent(rules []SecretRule, content []byte, blob, path string) []SecretFinding {
+	var findings []SecretFinding
+	for lineNumber, line := range strings.Split(string(content), "\n") {
+		for _, rule := range rules {
+			for _, match := range rule.re.FindAllStringSubmatch(line, -1) {
+				secret := match[0]
+				if len(match) > 1 && match[1] != "" {
+					secret = match[1]
+				}
+				if rule.Entropy > 0 && shannonEntropy(secret) < rule.Entropy {
+					continue
+				}
+				findings = append(findings, SecretFinding{Rule: rule.Name, Blob: blob, Path: path, Line: lineNumber + 1, Text: maskSecret(secret)})
+			}
+		}
+	}
+	return findings
+}
+
+// scanRepoSecrets проверяет все blob'ы, достижимые из любой ссылки зеркала (то есть всю историю)
+func scanRepoSecrets(rules []SecretRule, repoDir string) ([]SecretFinding, error) {
+	// Соберем blob'ы и пути, под которыми они впервые встретились
+	output, err := exec.Command("git", "-C", repoDir, "rev-list", "--objects", "--all").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	paths := make(map[string]string)
+	var oids []string
+	for _, line := range strings.Split(string(output), "\n") {
+		oid, path, found := strings.Cut(line, " ")
+		if !found || path == "" {
+			continue
+		}
+		if _, seen := paths[oid]; !seen {
+			paths[oid] = path
+			oids = append(oids, oid)
+		}
+	}
+	cmd := exec.Command("git", "-C", repoDir, "cat-file", "--batch")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		for _, oid := range oids {
+			fmt.Fprintln(stdin, oid)
+		}
+		stdin.Close()
+	}()
+	var findings []SecretFinding
+	reader := bufio.NewReader(stdout)
+	for range oids {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			cmd.Wait()
+			return nil, fmt.Errorf("failed to read object: %w", err)
+		}
+		// Формат заголовка: <oid> <type> <size>
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			cmd.Wait()
+			return nil, fmt.Errorf("invalid object header: %s", header)
+		}
+		content := make([]byte, size+1) // +1 -- перевод строки после содержимого
+		if _, err := io.ReadFull(reader, content); err != nil {
+			cmd.Wait()
+			return nil, fmt.Errorf("failed to read object: %w", err)
+		}
+		content = content[:size]
+		// Деревья, большие и бинарные файлы пропускаем
+		if fields[1] != "blob" || size > secretScanMaxBlob || bytes.IndexByte(content, 0) >= 0 {
+			continue
+		}
+		if len(findings) < secretMaxPerProject {
+			findings = append(findings, scanContent(rules, content, fields[0], paths[fields[0]])...)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+	return findings, nil
+}
+
+// secretGate проверяет зеркало репозитория на секреты и применяет политику из конфигурации.
+// noteNotScanned отмечает в журнале находок, что проект переносится архивом экспорта без проверки на секреты.
+// Такое возможно только с политикой report (см. checkArchiveMode)
+func noteNotScanned(config Config, findingsLogger *log.Logger, fullPath string) {
+	if config.SecretScanPolicy == "" {
+		return
+	}
+	findingsLogger.Printf("Not scanned: %s; exported archives are not scanned for secrets\n", fullPath)
+}
+
+// Возвращает true, если репозиторий можно пушить
+func secretGate(config Config, generalLogger, findingsLogger *log.Logger, repoDir, fullPath string, projectReport *ProjectReport) bool {
+	if config.SecretScanPolicy == "" {
+		return true
+	}
+	fmt.Println("[DEBUG] secretGate-> Scanning repository for secrets: ", fullPath)
+	generalLogger.Println("[DEBUG] secretGate-> Scanning repository for secrets: ", fullPath)
+	findings, err := scanRepoSecrets(config.secretRules, repoDir)
+	if err != nil {
+		// Если проверить не удалось, то пушить непроверенный репозиторий можно только в режиме report
+		fmt.Printf("[ERROR] Failed to scan repository %s for secrets: %v\n", fullPath, err)
+		generalLogger.Printf("[ERROR] Failed to scan repository %s for secrets: %v\n", fullPath, err)
+		findingsLogger.Printf("Scan failed: %s; error: %v\n", fullPath, err)
+		if config.SecretScanPolicy == secretPolicyReport {
+			return true
+		}
+		projectReport.Status = statusFailed
+		projectReport.Error = err.Error()
+		return false
+	}
+	projectReport.SecretFindings = len(findings)
+	for _, finding := range findings {
+		findingsLogger.Printf("Secret found: %s; rule: %s; path: %s:%d; blob: %s; match: %s\n", fullPath, finding.Rule, finding.Path, finding.Line, finding.Blob, finding.Text)
+	}
+	if len(findings) == 0 {
+		return true
+	}
+	fmt.Printf("[WARNING] Found %d possible secrets in %s, policy: %s\n", len(findings), fullPath, config.SecretScanPolicy)
+	generalLogger.Printf("[WARNING] Found %d possible secrets in %s, policy: %s\n", len(findings), fullPath, config.SecretScanPolicy)
+	switch config.SecretScanPolicy {
+	case secretPolicyBlock:
+		projectReport.Status = statusBlocked
+		return false
+	case secretPolicyQuarantine:
+		projectReport.Status = statusQuarantined
+		target := filepath.Join(quarantineDir, fullPath+".git")
+		os.RemoveAll(target)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+			err = os.Rename(repoDir, target)
+		}
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to quarantine repository %s: %v\n", fullPath, err)
+			generalLogger.Printf("[ERROR] Failed to quarantine repository %s: %v\n", fullPath, err)
+		}
+		return false
+	}
+	return true
+}
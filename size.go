@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	megabyte                = 1 << 20
+	pushDefaultChunkCommits = 1000 // По сколько коммитов пушится история больших репозиториев
+)
+
+// ProjectStatistics -- статистика проекта Gitlab (запрос с statistics=true), размеры в байтах
+type ProjectStatistics struct {
+	RepositorySize int64 `json:"repository_size"`
+	LFSObjectsSize int64 `json:"lfs_objects_size"`
+}
+
+// errRepositoryTooLarge -- репозиторий больше maxRepositorySizeMB, проект пропускается
+var errRepositoryTooLarge = errors.New("repository is too large")
+
+// errDiskSpaceUnknown -- на этой платформе свободное место не определяется, проверка пропускается
+var errDiskSpaceUnknown = errors.New("free disk space is unknown on this platform")
+
+// checkProjectSize до клонирования проверяет по статистике Gitlab-source, что репозиторий не больше
+// maxRepositorySizeMB и что он вместе с LFS объектами поместится в tmpDir, оставив minFreeDiskMB свободными
+func checkProjectSize(config Config, project Project) error {
+	stats := project.Statistics
+	if config.MaxRepositorySizeMB > 0 && stats.RepositorySize > config.MaxRepositorySizeMB*megabyte {
+		return fmt.Errorf("%w: %d MB, limit %d MB", errRepositoryTooLarge, stats.RepositorySize/megabyte, config.MaxRepositorySizeMB)
+	}
+	free, err := freeDiskSpace(tmpDir)
+	if errors.Is(err, errDiskSpaceUnknown) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check free disk space: %w", err)
+	}
+	need := stats.RepositorySize + stats.LFSObjectsSize + config.MinFreeDiskMB*megabyte
+	if free < need {
+		return fmt.Errorf("not enough disk space in %s: need %d MB, free %d MB", tmpDir, need/megabyte, free/megabyte)
+	}
+	return nil
+}
+
+// dirSize -- суммарный размер файлов в директории
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// pushChunkCommits возвращает, по сколько коммитов пушить историю веток зеркала repoDir. 0 -- пушить целиком:
+// частями пушатся только зеркала больше pushChunkThresholdMB
+func pushChunkCommits(config Config, repoDir string) int {
+	if config.PushChunkThresholdMB <= 0 {
+		return 0
+	}
+	size, err := dirSize(repoDir)
+	if err != nil || size <= config.PushChunkThresholdMB*megabyte {
+		return 0
+	}
+	if config.PushChunkCommits > 0 {
+		return config.PushChunkCommits
+	}
+	return pushDefaultChunkCommits
+}
+
+// chunkRefPrefix -- временные ссылки на Gitlab-destination, в которые пушится история по частям. Сами ветки
+// при этом не двигаются: пуш части в ветку откатывал бы её и запускал pipelines и webhooks
+const chunkRefPrefix = "refs/tmp-chunks/"
+
+// pushHistoryChunks пушит историю веток частями по chunkCommits коммитов: временная ссылка ветки
+// последовательно передвигается на каждый chunkCommits-й коммит по первым родителям, чтобы один пуш не
+// превысил ограничение размера на Gitlab-destination. Коммиты, которые уже есть на Gitlab-destination
+// (pushed -- ссылки, запушенные прошлыми запусками), пропускаются. Сами ветки после этого пушит
+// pushRefsBatched; возвращает временные ссылки, которые нужно удалить после него
+func pushHistoryChunks(ctx context.Context, generalLogger *log.Logger, mirror GitMirror, repoDir, newRepoURL string, refs []RefMapping, chunkCommits int, pushed map[string]string) []string {
+	// Коммиты, которые уже есть на Gitlab-destination
+	known := make(map[string]bool)
+	for _, sha := range pushed {
+		known[sha] = true
+	}
+	var chunkRefs []string
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.Source, "refs/heads/") {
+			continue
+		}
+		commits, err := mirror.FirstParentCommits(ctx, repoDir, ref.Source)
+		if err != nil {
+			fmt.Printf("[WARNING] Failed to push %s in chunks: %v\n", ref.Dest, err)
+			generalLogger.Printf("[WARNING] Failed to push %s in chunks: %v\n", ref.Dest, err)
+			continue
+		}
+		// Историю, которая уже есть на Gitlab-destination, пропустим
+		start := 0
+		for i := len(commits) - 1; i >= 0; i-- {
+			if known[commits[i]] {
+				start = i + 1
+				break
+			}
+		}
+		chunkRef := chunkRefPrefix + strings.TrimPrefix(ref.Dest, "refs/")
+		for i := start + chunkCommits - 1; i < len(commits)-1; i += chunkCommits {
+			fmt.Printf("[DEBUG] Pushing %s up to commit %d of %d\n", ref.Dest, i+1, len(commits))
+			generalLogger.Printf("[DEBUG] Pushing %s up to commit %d of %d\n", ref.Dest, i+1, len(commits))
+			if len(chunkRefs) == 0 || chunkRefs[len(chunkRefs)-1] != chunkRef {
+				chunkRefs = append(chunkRefs, chunkRef)
+			}
+			pushCtx, cancel := context.WithTimeout(ctx, operationTimeouts.Push)
+			_, err := mirror.PushRefs(pushCtx, repoDir, newRepoURL, []string{"+" + commits[i] + ":" + chunkRef})
+			cancel()
+			if err != nil {
+				fmt.Printf("[WARNING] Failed to push %s in chunks: %v\n", ref.Dest, err)
+				generalLogger.Printf("[WARNING] Failed to push %s in chunks: %v\n", ref.Dest, err)
+				break
+			}
+			for _, commit := range commits[start : i+1] {
+				known[commit] = true
+			}
+			start = i + 1
+		}
+	}
+	return chunkRefs
+}
+
+// deleteChunkRefs удаляет временные ссылки pushHistoryChunks с Gitlab-destination
+func deleteChunkRefs(ctx context.Context, generalLogger *log.Logger, mirror GitMirror, repoDir, newRepoURL string, chunkRefs []string) {
+	if len(chunkRefs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, operationTimeouts.Push)
+	defer cancel()
+	if err := mirror.DeleteRefs(ctx, repoDir, newRepoURL, chunkRefs); err != nil {
+		fmt.Printf("[WARNING] Failed to delete temporary refs %v: %v\n", chunkRefs, err)
+		generalLogger.Printf("[WARNING] Failed to delete temporary refs %v: %v\n", chunkRefs, err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// stateFile -- файл, в котором хранится состояние синхронизации между запусками
+const stateFile = "sync-state.json"
+
+// SyncState хранит то, что уже было перенесено, для инкрементальной синхронизации
+type SyncState struct {
+	mu   sync.Mutex
+	path string
+	// Packages: "<dest project id>/<package name>/<version>/<file name>" -> sha256 файла
+	Packages map[string]string `json:"packages"`
+	// Identities: "Имя <почта>" на Gitlab-source -> "Имя <почта>" после перезаписи авторов
+	Identities map[string]string `json:"identities"`
+	// PushedRefs: полный путь проекта на Gitlab-destination -> ссылка -> SHA последнего успешного пуша
+	PushedRefs map[string]map[string]string `json:"pushed_refs"`
+	// Paths: полный путь на Gitlab-destination (в нижнем регистре) -> проект на Gitlab-source, который туда переносится
+	Paths map[string]string `json:"paths"`
+	// Checkpoint -- где был прерван последний запуск (nil -- запуск завершился)
+	Checkpoint *SyncCheckpoint `json:"checkpoint,omitempty"`
+}
+
+// SyncCheckpoint -- отметка о прерванном запуске
+type SyncCheckpoint struct {
+	InterruptedAt time.Time `json:"interrupted_at"`
+	Project       string    `json:"project,omitempty"` // Проект на Gitlab-source, перенос которого был начат последним
+}
+
+// loadSyncState читает состояние из файла. Если файла нет -- возвращает пустое состояние
+func loadSyncState(path string) (*SyncState, error) {
+	state := &SyncState{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("failed to parse state file: %w", err)
+		}
+	}
+	if state.Packages == nil {
+		state.Packages = make(map[string]string)
+	}
+	if state.Identities == nil {
+		state.Identities = make(map[string]string)
+	}
+	if state.PushedRefs == nil {
+		state.PushedRefs = make(map[string]map[string]string)
+	}
+	if state.Paths == nil {
+		state.Paths = make(map[string]string)
+	}
+	return state, nil
+}
+
+// save записывает состояние в файл через временный файл, чтобы не повредить его при падении
+func (s *SyncState) save() error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(s.path+".tmp", s.path)
+}
+
+// packageSynced проверяет, был ли файл пакета с такой контрольной суммой уже перенесен
+func (s *SyncState) packageSynced(key, sha256 string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	synced, ok := s.Packages[key]
+	return ok && synced == sha256
+}
+
+// markPackageSynced запоминает перенесенный файл пакета и сохраняет состояние
+func (s *SyncState) markPackageSynced(key, sha256 string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Packages[key] = sha256
+	return s.save()
+}
+
+// pathOwner возвращает проект на Gitlab-source, который переносился в путь key на Gitlab-destination
+func (s *SyncState) pathOwner(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owner, ok := s.Paths[key]
+	return owner, ok
+}
+
+// claimPath запоминает, что в путь key переносится проект sourcePath, и сохраняет состояние
+func (s *SyncState) claimPath(key, sourcePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Paths[key] == sourcePath {
+		return nil
+	}
+	s.Paths[key] = sourcePath
+	return s.save()
+}
+
+// identity возвращает автора, в которого уже переписывался автор с Gitlab-source
+func (s *SyncState) identity(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rewritten, ok := s.Identities[key]
+	return rewritten, ok
+}
+
+// rememberIdentity запоминает перезапись автора. Состояние сохраняется вызовом persist после
+// перезаписи всей истории, чтобы не писать файл на каждого автора
+func (s *SyncState) rememberIdentity(key, rewritten string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Identities[key] = rewritten
+}
+
+// setCheckpoint отмечает прерванный запуск (nil -- снимает отметку) и сохраняет состояние
+func (s *SyncState) setCheckpoint(checkpoint *SyncCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Checkpoint = checkpoint
+	return s.save()
+}
+
+// persist сохраняет состояние в файл
+func (s *SyncState) persist() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// pushedRefs возвращает ссылки проекта, запушенные прошлыми запусками: ссылка -> SHA
+func (s *SyncState) pushedRefs(destPath string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refs := make(map[string]string, len(s.PushedRefs[destPath]))
+	for ref, sha := range s.PushedRefs[destPath] {
+		refs[ref] = sha
+	}
+	return refs
+}
+
+// markRefsPushed запоминает успешно запушенные ссылки проекта и сохраняет состояние
+func (s *SyncState) markRefsPushed(destPath string, results []RefPushResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refs := s.PushedRefs[destPath]
+	if refs == nil {
+		refs = make(map[string]string)
+		s.PushedRefs[destPath] = refs
+	}
+	for _, result := range results {
+		if result.Status == pushStatusOK {
+			refs[result.Ref] = result.SHA
+		}
+	}
+	return s.save()
+}
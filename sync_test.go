@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setUpTestWorkspace переходит во временную рабочую директорию с tmpDir, как setUpWorkspace
+func setUpTestWorkspace(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Mkdir(tmpDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setUpTestGitTransport настраивает git как setUpGitTransport и восстанавливает окружение после теста
+func setUpTestGitTransport(t *testing.T, config Config) {
+	t.Helper()
+	environ := os.Environ()
+	t.Cleanup(func() {
+		os.Clearenv()
+		for _, entry := range environ {
+			key, value, _ := strings.Cut(entry, "=")
+			os.Setenv(key, value)
+		}
+	})
+	if err := setUpGitTransport(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestConfig собирает конфигурацию для синхронизации source -> dest по https, как это делает main
+func newTestConfig(t *testing.T, source, dest *fakeGitLab, configure func(*Config)) Config {
+	t.Helper()
+	config := Config{
+		GitlabURLSource:    source.URL(),
+		PrivateTokenSource: source.token,
+		GitlabURLDest:      dest.URL(),
+		PrivateTokenDest:   dest.token,
+		GitTransportSource: gitTransportHTTPS,
+		GitTransportDest:   gitTransportHTTPS,
+	}
+	if configure != nil {
+		configure(&config)
+	}
+	paths, err := newPathMapper(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.paths = paths
+	config.refNamespaces, err = compileRefNamespaces(config.RefNamespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.registryTagInclude, err = compilePatterns(config.RegistryTagInclude); err != nil {
+		t.Fatal(err)
+	}
+	if config.registryTagExclude, err = compilePatterns(config.RegistryTagExclude); err != nil {
+		t.Fatal(err)
+	}
+	if config.SecretScanPolicy != "" {
+		if config.secretRules, err = compileSecretRules(config.SecretScanRules); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setUpTestGitTransport(t, config)
+	config.gitSource, config.gitDest, err = newGitMirrors(config, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.report = newRunReport(time.Now())
+	config.exports = newExportScheduler(config, log.New(io.Discard, "", 0))
+	config.state, err = loadSyncState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.identities, err = newIdentityMapper(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// runSync запускает синхронизацию групп с отключенными логами
+func runSync(t *testing.T, config Config) {
+	t.Helper()
+	discard := log.New(io.Discard, "", 0)
+	if err := syncGroups(context.Background(), config, discard, discard, discard); err != nil {
+		t.Fatalf("syncGroups: %v", err)
+	}
+}
+
+// reportStatuses возвращает статусы проектов из отчета: путь на Gitlab-source -> статус
+func reportStatuses(config Config) map[string]string {
+	statuses := make(map[string]string)
+	for _, project := range config.report.Projects {
+		statuses[project.Project] = project.Status
+	}
+	return statuses
+}
+
+// newSourceFixture создает на Gitlab-source группу xxxxx с подгруппой и проектами и группу,
+// которая в резервацию не переносится
+func newSourceFixture(t *testing.T) *fakeGitLab {
+	source := newFakeGitLab(t, "source-token")
+	root := source.addGroup("xxxxx", "xxxxx", nil)
+	source.addBadge(root, "private")
+	source.addProject(root, "App Name", "app", []map[string]string{
+		{"README.md": "app\n"},
+		{"src/main.go": "package main\n"},
+	}, []string{"develop"}, []string{"v1.0.0"})
+	source.addProject(root, "Empty", "empty", nil, nil, nil)
+	sub := source.addGroup("Sub", "sub", root)
+	source.addProject(sub, "Lib", "lib", []map[string]string{{"lib.go": "package lib\n"}}, nil, []string{"v0.1.0"})
+	other := source.addGroup("other", "other", nil)
+	source.addProject(other, "Tool", "tool", []map[string]string{{"tool.sh": "echo\n"}}, nil, nil)
+	return source
+}
+
+func TestSyncClone(t *testing.T) {
+	for _, backend := range []string{gitBackendExec, gitBackendGoGit} {
+		t.Run(backend, func(t *testing.T) {
+			setUpTestWorkspace(t)
+			source := newSourceFixture(t)
+			dest := newFakeGitLab(t, "dest-token")
+			config := newTestConfig(t, source, dest, func(config *Config) {
+				config.GitBackend = backend
+			})
+
+			runSync(t, config)
+
+			wantTree := []string{
+				"mock-sync/",
+				"mock-sync/xxxxx/",
+				"mock-sync/xxxxx/app",
+				"mock-sync/xxxxx/sub/",
+				"mock-sync/xxxxx/sub/lib",
+			}
+			if got := dest.tree(); !reflect.DeepEqual(got, wantTree) {
+				t.Errorf("destination tree = %q, want %q", got, wantTree)
+			}
+			for sourcePath, destPath := range map[string]string{
+				"xxxxx/app":     "mock-sync/xxxxx/app",
+				"xxxxx/sub/lib": "mock-sync/xxxxx/sub/lib",
+			} {
+				if got, want := dest.refs(destPath), source.refs(sourcePath); !reflect.DeepEqual(got, want) {
+					t.Errorf("refs of %s = %v, want %v", destPath, got, want)
+				}
+			}
+			wantStatuses := map[string]string{
+				"xxxxx/app":     statusSuccess,
+				"xxxxx/empty":   statusSkipped,
+				"xxxxx/sub/lib": statusSuccess,
+			}
+			if got := reportStatuses(config); !reflect.DeepEqual(got, wantStatuses) {
+				t.Errorf("report statuses = %v, want %v", got, wantStatuses)
+			}
+		})
+	}
+}
+
+func TestSyncArchive(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newSourceFixture(t)
+	dest := newFakeGitLab(t, "dest-token")
+	config := newTestConfig(t, source, dest, func(config *Config) {
+		config.TransferModes = map[string]string{"xxxxx/app": transferModeArchive}
+	})
+
+	runSync(t, config)
+
+	if got, want := dest.refs("mock-sync/xxxxx/app"), source.refs("xxxxx/app"); !reflect.DeepEqual(got, want) {
+		t.Errorf("refs of imported project = %v, want %v", got, want)
+	}
+	if got := reportStatuses(config)["xxxxx/app"]; got != statusSuccess {
+		t.Errorf("status of archived project = %q, want %q", got, statusSuccess)
+	}
+}
+
+// TestCheckArchiveMode проверяет, с какими настройками проект нельзя перенести архивом экспорта: архив не
+// проверяется на секреты и его история не переписывается
+func TestCheckArchiveMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "default"},
+		{name: "secret scan report", config: Config{SecretScanPolicy: secretPolicyReport}},
+		{name: "secret scan block", config: Config{SecretScanPolicy: secretPolicyBlock}, wantErr: true},
+		{name: "secret scan quarantine", config: Config{SecretScanPolicy: secretPolicyQuarantine}, wantErr: true},
+		{name: "history filter of group", config: Config{HistoryFilters: map[string]HistoryFilter{"xxxxx": {DenyBlobs: []string{"*.pem"}}}}, wantErr: true},
+		{name: "history filter of other project", config: Config{HistoryFilters: map[string]HistoryFilter{"xxxxx/lib": {DropPaths: []string{"src"}}}}},
+		{name: "identity mailmap", config: Config{IdentityMailmap: "mailmap"}, wantErr: true},
+		{name: "identity hash", config: Config{IdentityHash: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkArchiveMode(tt.config, "xxxxx/app"); (err != nil) != tt.wantErr {
+				t.Errorf("checkArchiveMode() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestSyncArchiveModeChecks проверяет, что отклоненный проверкой конфигурации проект даже не экспортируется,
+// а с политикой report переносится архивом с отметкой в журнале находок
+func TestSyncArchiveModeChecks(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       string
+		wantStatus   string
+		wantFindings string
+	}{
+		{name: "refused", policy: secretPolicyBlock, wantStatus: statusFailed},
+		{name: "not scanned", policy: secretPolicyReport, wantStatus: statusSuccess, wantFindings: "Not scanned: xxxxx/app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setUpTestWorkspace(t)
+			source := newSourceFixture(t)
+			dest := newFakeGitLab(t, "dest-token")
+			config := newTestConfig(t, source, dest, func(config *Config) {
+				config.TransferModes = map[string]string{"xxxxx/app": transferModeArchive}
+				config.SecretScanPolicy = tt.policy
+			})
+			var findings strings.Builder
+			discard := log.New(io.Discard, "", 0)
+
+			if err := syncGroups(context.Background(), config, discard, discard, log.New(&findings, "", 0)); err != nil {
+				t.Fatalf("syncGroups: %v", err)
+			}
+
+			if got := reportStatuses(config)["xxxxx/app"]; got != tt.wantStatus {
+				t.Errorf("status of archived project = %q, want %q", got, tt.wantStatus)
+			}
+			exports := len(source.projectByPath("xxxxx/app").exportRequests)
+			imported := dest.projectByPath("mock-sync/xxxxx/app") != nil
+			if wantImported := tt.wantStatus == statusSuccess; imported != wantImported || (exports != 0) != wantImported {
+				t.Errorf("export requests = %d, imported = %v; want transferred %v", exports, imported, wantImported)
+			}
+			if !strings.Contains(findings.String(), tt.wantFindings) || (tt.wantFindings == "" && strings.Contains(findings.String(), "Not scanned")) {
+				t.Errorf("findings log = %q, want %q", findings.String(), tt.wantFindings)
+			}
+		})
+	}
+}
+
+// TestSyncArchiveImportFailure проверяет, что неудачный импорт архива попадает в отчет с import_error
+// и failed_relations, повторяется с importRetries и переносится через clone с importFallbackClone
+func TestSyncArchiveImportFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		failures      int
+		configure     func(*Config)
+		wantStatus    string
+		wantAttempts  int
+		wantFallback  bool
+		wantDiagnosis bool
+	}{
+		{name: "failed", failures: 1, wantStatus: statusFailed, wantAttempts: 1, wantDiagnosis: true},
+		{name: "retried", failures: 1, configure: func(config *Config) { config.ImportRetries = 2 }, wantStatus: statusSuccess, wantAttempts: 2},
+		{name: "fallback to clone", failures: 3, configure: func(config *Config) {
+			config.ImportRetries = 1
+			config.ImportFallbackClone = true
+		}, wantStatus: statusSuccess, wantAttempts: 2, wantFallback: true, wantDiagnosis: true},
+		// Архив отклонен проверкой конфигурации до импорта: clone эту проверку не обходит
+		{name: "no fallback without failed import", configure: func(config *Config) {
+			config.ImportFallbackClone = true
+			config.SecretScanPolicy = secretPolicyBlock
+		}, wantStatus: statusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setUpTestWorkspace(t)
+			source := newSourceFixture(t)
+			dest := newFakeGitLab(t, "dest-token")
+			dest.failImports(tt.failures)
+			config := newTestConfig(t, source, dest, func(config *Config) {
+				config.TransferModes = map[string]string{"xxxxx/app": transferModeArchive}
+				if tt.configure != nil {
+					tt.configure(config)
+				}
+			})
+
+			runSync(t, config)
+
+			var report *ProjectReport
+			for _, project := range config.report.Projects {
+				if project.Project == "xxxxx/app" {
+					report = project
+				}
+			}
+			if report == nil {
+				t.Fatal("xxxxx/app is missing in report")
+			}
+			if report.Status != tt.wantStatus || report.ImportAttempts != tt.wantAttempts || report.FallbackClone != tt.wantFallback || report.ReusedProject != tt.wantFallback {
+				t.Errorf("report = %+v, want status %s, %d import attempts, fallback into reused project %v", report, tt.wantStatus, tt.wantAttempts, tt.wantFallback)
+			}
+			if hasDiagnosis := report.ImportError != "" && len(report.FailedRelations) == 1; hasDiagnosis != tt.wantDiagnosis {
+				t.Errorf("import error %q, failed relations %+v; want diagnosis %v", report.ImportError, report.FailedRelations, tt.wantDiagnosis)
+			}
+			if tt.wantDiagnosis && report.FailedRelations[0].RelationName != "merge_requests" {
+				t.Errorf("failed relation = %+v, want merge_requests", report.FailedRelations[0])
+			}
+			if tt.wantStatus == statusSuccess {
+				if got, want := dest.refs("mock-sync/xxxxx/app"), source.refs("xxxxx/app"); !reflect.DeepEqual(got, want) {
+					t.Errorf("refs on destination = %v, want %v", got, want)
+				}
+			} else if tt.wantAttempts == 0 && dest.projectByPath("mock-sync/xxxxx/app") != nil {
+				t.Error("project was transferred without import attempt")
+			}
+		})
+	}
+}
+
+func TestSyncRepeatedRunIsIdempotent(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newSourceFixture(t)
+	dest := newFakeGitLab(t, "dest-token")
+
+	runSync(t, newTestConfig(t, source, dest, nil))
+	first := dest.tree()
+	runSync(t, newTestConfig(t, source, dest, nil))
+
+	if got := dest.tree(); !reflect.DeepEqual(got, first) {
+		t.Errorf("tree after second run = %q, want %q", got, first)
+	}
+}
+
+// TestSyncRecordsRejectedRefs проверяет, что отклоненная сервером ветка не мешает пушу остальных ссылок
+// и попадает в отчет, а запушенные ссылки -- в состояние
+func TestSyncRecordsRejectedRefs(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newSourceFixture(t)
+	dest := newFakeGitLab(t, "dest-token")
+	root := dest.addGroup("xxxxx", "xxxxx", dest.addGroup("mock-sync", "mock-sync", nil))
+	dest.protectRef(dest.addProject(root, "App Name", "app", nil, nil, nil), "refs/heads/develop")
+	config := newTestConfig(t, source, dest, nil)
+
+	runSync(t, config)
+
+	want := source.refs("xxxxx/app")
+	delete(want, "refs/heads/develop")
+	if got := dest.refs("mock-sync/xxxxx/app"); !reflect.DeepEqual(got, want) {
+		t.Errorf("refs of mock-sync/xxxxx/app = %v, want %v", got, want)
+	}
+	if got := config.state.PushedRefs["mock-sync/xxxxx/app"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("pushed refs in state = %v, want %v", got, want)
+	}
+	var app *ProjectReport
+	for _, project := range config.report.Projects {
+		if project.Project == "xxxxx/app" {
+			app = project
+		}
+	}
+	if app == nil {
+		t.Fatal("xxxxx/app is missing from the report")
+	}
+	if app.Status != statusFailed || app.PushedRefs != len(want) {
+		t.Errorf("report status = %q, pushed refs = %d, want %q, %d", app.Status, app.PushedRefs, statusFailed, len(want))
+	}
+	if len(app.FailedRefs) != 1 || app.FailedRefs[0].Ref != "refs/heads/develop" || app.FailedRefs[0].Status != pushStatusProtected {
+		t.Errorf("failed refs = %+v, want refs/heads/develop %s", app.FailedRefs, pushStatusProtected)
+	}
+}
+
+// TestSyncRefNamespaces проверяет, что головы merge requests переносятся в другое пространство, а заметки -- как есть
+func TestSyncRefNamespaces(t *testing.T) {
+	for _, backend := range []string{gitBackendExec, gitBackendGoGit} {
+		t.Run(backend, func(t *testing.T) {
+			setUpTestWorkspace(t)
+			source := newSourceFixture(t)
+			appRepo := source.repoDir(source.projectByPath("xxxxx/app"))
+			head := strings.TrimSpace(source.git(appRepo, "rev-parse", "refs/heads/main"))
+			source.git(appRepo, "update-ref", "refs/merge-requests/1/head", head)
+			source.git(appRepo, "update-ref", "refs/merge-requests/1/merge", head)
+			source.git(appRepo, "notes", "add", "-m", "reviewed", head)
+			dest := newFakeGitLab(t, "dest-token")
+			config := newTestConfig(t, source, dest, func(config *Config) {
+				config.GitBackend = backend
+				config.RefNamespaces = []RefNamespace{
+					{Source: "refs/merge-requests/*/head", Dest: "refs/mr/*"},
+					{Source: "refs/notes/*"},
+				}
+			})
+
+			runSync(t, config)
+
+			want := map[string]string{
+				"refs/mr/1":          head,
+				"refs/notes/commits": source.refs("xxxxx/app", "refs/notes/")["refs/notes/commits"],
+			}
+			if got := dest.refs("mock-sync/xxxxx/app", "refs/mr/", "refs/notes/", "refs/merge-requests/"); !reflect.DeepEqual(got, want) {
+				t.Errorf("extra refs of mock-sync/xxxxx/app = %v, want %v", got, want)
+			}
+			if got := reportStatuses(config)["xxxxx/app"]; got != statusSuccess {
+				t.Errorf("status of xxxxx/app = %q, want %q", got, statusSuccess)
+			}
+		})
+	}
+}
+
+// TestSyncRepositorySizeLimits проверяет, что слишком большие проекты пропускаются, а при нехватке
+// места на диске проекты не клонируются
+func TestSyncRepositorySizeLimits(t *testing.T) {
+	t.Run("max size", func(t *testing.T) {
+		setUpTestWorkspace(t)
+		source := newSourceFixture(t)
+		source.projectByPath("xxxxx/app").RepositorySize = 200 * megabyte
+		dest := newFakeGitLab(t, "dest-token")
+		config := newTestConfig(t, source, dest, func(config *Config) {
+			config.MaxRepositorySizeMB = 100
+		})
+
+		runSync(t, config)
+
+		if dest.projectByPath("mock-sync/xxxxx/app") != nil {
+			t.Error("project larger than maxRepositorySizeMB was transferred")
+		}
+		wantStatuses := map[string]string{
+			"xxxxx/app":     statusSkipped,
+			"xxxxx/empty":   statusSkipped,
+			"xxxxx/sub/lib": statusSuccess,
+		}
+		if got := reportStatuses(config); !reflect.DeepEqual(got, wantStatuses) {
+			t.Errorf("report statuses = %v, want %v", got, wantStatuses)
+		}
+		for _, project := range config.report.Projects {
+			if project.Project == "xxxxx/app" && (project.RepositorySize != 200*megabyte || !strings.Contains(project.Error, "200 MB")) {
+				t.Errorf("report of xxxxx/app = %+v, want repository size and reason", project)
+			}
+		}
+	})
+	t.Run("free disk space", func(t *testing.T) {
+		setUpTestWorkspace(t)
+		if _, err := freeDiskSpace(tmpDir); err != nil {
+			t.Skip(err)
+		}
+		source := newSourceFixture(t)
+		dest := newFakeGitLab(t, "dest-token")
+		config := newTestConfig(t, source, dest, func(config *Config) {
+			config.MinFreeDiskMB = 1 << 40
+		})
+
+		runSync(t, config)
+
+		if got := dest.tree(); len(got) != 3 {
+			t.Errorf("destination tree = %q, want only groups", got)
+		}
+		for _, project := range config.report.Projects {
+			if project.Status != statusFailed || !strings.Contains(project.Error, "not enough disk space") {
+				t.Errorf("report of %s = %+v, want failed for disk space", project.Project, project)
+			}
+		}
+	})
+}
+
+// cancelingMirror -- GitMirror, который прерывает запуск сразу после первого клонирования, как Ctrl-C
+type cancelingMirror struct {
+	GitMirror
+	cancel context.CancelFunc
+}
+
+func (m cancelingMirror) Clone(ctx context.Context, repoURL, repoDir string) error {
+	defer m.cancel()
+	return m.GitMirror.Clone(ctx, repoURL, repoDir)
+}
+
+// TestSyncInterrupted проверяет, что прерванный запуск не начинает следующие проекты, а отметка
+// о прерывании сохраняется в состоянии вместе с очищенной временной директорией
+func TestSyncInterrupted(t *testing.T) {
+	setUpTestWorkspace(t)
+	source := newSourceFixture(t)
+	dest := newFakeGitLab(t, "dest-token")
+	config := newTestConfig(t, source, dest, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config.gitSource = cancelingMirror{GitMirror: config.gitSource, cancel: cancel}
+	discard := log.New(io.Discard, "", 0)
+
+	if err := syncGroups(ctx, config, discard, discard, discard); !errors.Is(err, context.Canceled) {
+		t.Fatalf("syncGroups error = %v, want %v", err, context.Canceled)
+	}
+	writeCheckpoint(config, discard)
+
+	if len(config.report.Projects) != 1 || config.report.Projects[0].Status != statusFailed {
+		t.Fatalf("report projects = %+v, want one failed project", config.report.Projects)
+	}
+	if !config.report.Interrupted {
+		t.Error("report is not marked as interrupted")
+	}
+	if dest.projectByPath("mock-sync/"+config.report.Projects[0].Project) != nil {
+		t.Error("interrupted project was pushed")
+	}
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("tmpDir after interrupt: %d entries, err %v; want empty", len(entries), err)
+	}
+	state, err := loadSyncState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Checkpoint == nil || state.Checkpoint.Project != config.report.Projects[0].Project {
+		t.Errorf("checkpoint = %+v, want project %s", state.Checkpoint, config.report.Projects[0].Project)
+	}
+}
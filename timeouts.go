@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TimeoutConfig -- ограничения времени операций в формате time.ParseDuration ("30s", "2h").
+// Пусто -- значение по умолчанию из defaultTimeouts
+type TimeoutConfig struct {
+	API    string `json:"api"`    // Один запрос к API Gitlab (кроме скачивания и загрузки файлов)
+	Clone  string `json:"clone"`  // Клонирование зеркала и скачивание LFS объектов
+	Push   string `json:"push"`   // Один git push (пачка ссылок, часть истории, LFS объекты)
+	Export string `json:"export"` // Ожидание экспорта проекта на Gitlab-source
+	Import string `json:"import"` // Ожидание импорта проекта на Gitlab-destination
+}
+
+// timeouts -- ограничения времени операций
+type timeouts struct {
+	API    time.Duration
+	Clone  time.Duration
+	Push   time.Duration
+	Export time.Duration
+	Import time.Duration
+}
+
+var defaultTimeouts = timeouts{
+	API:    time.Minute,
+	Clone:  2 * time.Hour,
+	Push:   time.Hour,
+	Export: time.Hour,
+	Import: time.Hour,
+}
+
+// operationTimeouts -- ограничения времени, общие для всех операций. Задаются setUpTimeouts
+var operationTimeouts = defaultTimeouts
+
+// setUpTimeouts применяет ограничения времени из конфигурации
+func setUpTimeouts(config TimeoutConfig) error {
+	parsed := defaultTimeouts
+	for _, field := range []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"api", config.API, &parsed.API},
+		{"clone", config.Clone, &parsed.Clone},
+		{"push", config.Push, &parsed.Push},
+		{"export", config.Export, &parsed.Export},
+		{"import", config.Import, &parsed.Import},
+	} {
+		if field.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(field.value)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("invalid %s timeout %q", field.name, field.value)
+		}
+		*field.dest = duration
+	}
+	operationTimeouts = parsed
+	return nil
+}
+
+// sleepContext ждет d или отмены ctx
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// cancelOnClose отменяет контекст запроса, когда тело ответа закрыто
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetUpTimeouts(t *testing.T) {
+	t.Cleanup(func() { operationTimeouts = defaultTimeouts })
+
+	if err := setUpTimeouts(TimeoutConfig{API: "30s", Push: "3h"}); err != nil {
+		t.Fatal(err)
+	}
+	want := defaultTimeouts
+	want.API = 30 * time.Second
+	want.Push = 3 * time.Hour
+	if operationTimeouts != want {
+		t.Errorf("operationTimeouts = %+v, want %+v", operationTimeouts, want)
+	}
+	for _, config := range []TimeoutConfig{{Clone: "2 hours"}, {Export: "0s"}, {Import: "-1m"}} {
+		if err := setUpTimeouts(config); err == nil {
+			t.Errorf("setUpTimeouts(%+v) succeeded, want error", config)
+		}
+	}
+	if operationTimeouts != want {
+		t.Errorf("operationTimeouts changed by invalid config: %+v", operationTimeouts)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Способы доступа git к Gitlab
+const (
+	gitTransportSSH   = "ssh"   // ssh://git@host:port/... (по умолчанию)
+	gitTransportHTTPS = "https" // https://host/... с токеном из конфигурации
+)
+
+// Переменные окружения, из которых credential helper отдает токены git и git-lfs.
+// Токены не попадают ни в адреса репозиториев, ни в аргументы команд, ни в логи
+const (
+	envTokenSource = "GITLAB_INJECT_TOKEN_SOURCE"
+	envTokenDest   = "GITLAB_INJECT_TOKEN_DEST"
+)
+
+// sharedTLSConfig -- настройки TLS, общие для клиента API и git. По умолчанию сертификаты не проверяются
+var sharedTLSConfig = &tls.Config{InsecureSkipVerify: true}
+
+// tlsClientConfig возвращает копию общих настроек TLS для нового HTTP-транспорта
+func tlsClientConfig() *tls.Config {
+	return sharedTLSConfig.Clone()
+}
+
+// setUpTLS применяет настройки TLS из конфигурации: проверку сертификатов и дополнительный корневой сертификат
+func setUpTLS(config Config) error {
+	tlsConfig := &tls.Config{InsecureSkipVerify: !config.TLSVerify}
+	if config.TLSCAFile != "" {
+		data, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	sharedTLSConfig = tlsConfig
+	return nil
+}
+
+// credentialHelper -- credential helper, который отдает токен из переменной окружения env
+func credentialHelper(env string) string {
+	return fmt.Sprintf(`!f() { test "$1" = get || exit 0; echo username=oauth2; echo "password=${%s}"; }; f`, env)
+}
+
+// setUpGitTransport настраивает git через переменные окружения процесса (GIT_CONFIG_COUNT и т.д.),
+// которые наследуют все вызовы git и git-lfs: credential helper'ы для обоих Gitlab и те же настройки TLS,
+// что и у клиента API. Настройки пользователя (~/.gitconfig) для этих адресов не используются, а уже
+// заданные в окружении GIT_CONFIG_KEY_*/GIT_CONFIG_VALUE_* сохраняются: наши добавляются после них
+func setUpGitTransport(config Config) error {
+	existing := 0
+	if count := os.Getenv("GIT_CONFIG_COUNT"); count != "" {
+		var err error
+		if existing, err = strconv.Atoi(count); err != nil || existing < 0 {
+			return fmt.Errorf("invalid GIT_CONFIG_COUNT %q", count)
+		}
+	}
+	var gitConfig [][2]string
+	if !config.TLSVerify {
+		gitConfig = append(gitConfig, [2]string{"http.sslVerify", "false"})
+	}
+	if config.TLSCAFile != "" {
+		caFile, err := filepath.Abs(config.TLSCAFile)
+		if err != nil {
+			return err
+		}
+		gitConfig = append(gitConfig, [2]string{"http.sslCAInfo", caFile})
+	}
+	instances := []struct {
+		url, token, env string
+	}{
+		{config.GitlabURLSource, config.PrivateTokenSource, envTokenSource},
+		{config.GitlabURLDest, config.PrivateTokenDest, envTokenDest},
+	}
+	for _, instance := range instances {
+		if !strings.HasPrefix(instance.url, "https://") {
+			continue
+		}
+		if err := os.Setenv(instance.env, instance.token); err != nil {
+			return err
+		}
+		// Пустое значение сбрасывает helper'ы, настроенные пользователем для этого адреса
+		key := "credential." + strings.TrimSuffix(instance.url, "/") + ".helper"
+		gitConfig = append(gitConfig, [2]string{key, ""}, [2]string{key, credentialHelper(instance.env)})
+	}
+	env := map[string]string{
+		"GIT_TERMINAL_PROMPT": "0",
+		"GIT_CONFIG_COUNT":    strconv.Itoa(existing + len(gitConfig)),
+	}
+	for i, entry := range gitConfig {
+		env[fmt.Sprintf("GIT_CONFIG_KEY_%d", existing+i)] = entry[0]
+		env[fmt.Sprintf("GIT_CONFIG_VALUE_%d", existing+i)] = entry[1]
+	}
+	for key, value := range env {
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SSHConfig -- настройки ssh для одного Gitlab. Применяются через GIT_SSH_COMMAND к каждому вызову git,
+// ~/.ssh/config пользователя не читается
+type SSHConfig struct {
+	IdentityFile   string `json:"identityFile"`   // Закрытый ключ
+	KnownHostsFile string `json:"knownHostsFile"` // Файл known_hosts, включает строгую проверку ключа сервера
+	ProxyJump      string `json:"proxyJump"`      // Jump host(ы) в формате ssh -J: user@host:port[,...]
+	Port           int    `json:"port"`           // Порт ssh Gitlab (0 -- по умолчанию)
+}
+
+// shellQuote экранирует аргумент для sh, которым git запускает GIT_SSH_COMMAND
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sshOptions -- параметры ssh, общие для Gitlab и jump host'ов: без ~/.ssh/config, без вопросов,
+// с ключом и known_hosts из конфигурации
+func sshOptions(ssh SSHConfig) ([]string, error) {
+	options := []string{"-F", "/dev/null", "-o", "BatchMode=yes"}
+	if ssh.IdentityFile != "" {
+		identityFile, err := filepath.Abs(ssh.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, "-o", "IdentitiesOnly=yes", "-i", shellQuote(identityFile))
+	}
+	if ssh.KnownHostsFile != "" {
+		knownHostsFile, err := filepath.Abs(ssh.KnownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, "-o", shellQuote("UserKnownHostsFile="+knownHostsFile), "-o", "StrictHostKeyChecking=yes")
+	}
+	return options, nil
+}
+
+// splitJumpHost делит jump host в формате ssh -J (user@host:port) на адрес для ssh и порт
+func splitJumpHost(hop string) (string, string) {
+	colon := strings.LastIndex(hop, ":")
+	if colon < 0 || strings.LastIndex(hop, "]") > colon {
+		return strings.NewReplacer("[", "", "]", "").Replace(hop), ""
+	}
+	port := hop[colon+1:]
+	if _, err := strconv.Atoi(port); err != nil {
+		return hop, ""
+	}
+	return strings.NewReplacer("[", "", "]", "").Replace(hop[:colon]), port
+}
+
+// sshCommand собирает GIT_SSH_COMMAND по настройкам ssh. Jump host'ы подключаются через ProxyCommand,
+// а не -J: ssh -J не передает jump host'ам ни ключ, ни known_hosts, ни BatchMode
+func sshCommand(ssh SSHConfig) (string, error) {
+	options, err := sshOptions(ssh)
+	if err != nil {
+		return "", err
+	}
+	args := append([]string{"ssh"}, options...)
+	if ssh.ProxyJump != "" {
+		// ssh подставляет %h и %p во всю строку ProxyCommand, поэтому во вложенных командах % экранируется
+		escape := strings.NewReplacer("%", "%%")
+		proxy := ""
+		for _, hop := range strings.Split(ssh.ProxyJump, ",") {
+			hopArgs := []string{"ssh"}
+			for _, option := range options {
+				hopArgs = append(hopArgs, escape.Replace(option))
+			}
+			if proxy != "" {
+				hopArgs = append(hopArgs, "-o", shellQuote("ProxyCommand="+escape.Replace(proxy)))
+			}
+			host, port := splitJumpHost(strings.TrimSpace(hop))
+			if port != "" {
+				hopArgs = append(hopArgs, "-p", port)
+			}
+			proxy = strings.Join(append(hopArgs, "-W", "%h:%p", shellQuote(escape.Replace(host))), " ")
+		}
+		args = append(args, "-o", shellQuote("ProxyCommand="+proxy))
+	}
+	if ssh.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(ssh.Port))
+	}
+	return strings.Join(args, " "), nil
+}
+
+// gitEnv возвращает окружение для вызовов git, которые обращаются к Gitlab с настройками ssh
+func gitEnv(ssh SSHConfig) ([]string, error) {
+	command, err := sshCommand(ssh)
+	if err != nil {
+		return nil, err
+	}
+	return append(os.Environ(), "GIT_SSH_COMMAND="+command), nil
+}
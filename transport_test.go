@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSSH -- ssh для тестов: записывает аргументы в $SSH_LOG и, как ssh, запускает ProxyCommand,
+// подставляя %h и %p адрес, к которому подключается
+const fakeSSH = `#!/bin/sh
+echo "$*" >> "$SSH_LOG"
+proxy=""; port=22; host=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-o) case "$2" in ProxyCommand=*) proxy="${2#ProxyCommand=}";; esac; shift 2;;
+	-p) port="$2"; shift 2;;
+	-F|-i|-W) shift 2;;
+	-*) shift;;
+	*) host="${1#*@}"; break;;
+	esac
+done
+if [ -n "$proxy" ]; then
+	sh -c "$(printf '%s' "$proxy" | sed -e 's/%%/@@PCT@@/g' -e "s/%h/$host/g" -e "s/%p/$port/g" -e 's/@@PCT@@/%/g')"
+fi
+`
+
+// TestSSHCommandProxyJump проверяет, что каждый jump host получает ключ, known_hosts и BatchMode,
+// а цепочка jump host'ов подключается по порядку
+func TestSSHCommandProxyJump(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(fakeSSH), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	sshLog := filepath.Join(dir, "ssh.log")
+	t.Setenv("SSH_LOG", sshLog)
+	// Пробел и кавычка в путях проверяют экранирование во вложенных ProxyCommand
+	keys := filepath.Join(dir, "it's keys")
+	ssh := SSHConfig{
+		IdentityFile:   filepath.Join(keys, "id_ed25519"),
+		KnownHostsFile: filepath.Join(keys, "known_hosts"),
+		ProxyJump:      "u1@jump1.example.com,u2@jump2.example.com:2200",
+		Port:           2222,
+	}
+
+	command, err := sshCommand(ssh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// git запускает GIT_SSH_COMMAND через shell, дописывая адрес и команду
+	if output, err := exec.Command("sh", "-c", command+` "$@"`, "ssh", "git@gitlab.example.com", "git-upload-pack 'group/app.git'").CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v\n%s", command, err, output)
+	}
+
+	data, err := os.ReadFile(sshLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(calls) != 3 {
+		t.Fatalf("ssh calls:\n%s\nwant gitlab, jump2 and jump1", data)
+	}
+	wantEnds := []string{
+		"-p 2222 git@gitlab.example.com git-upload-pack 'group/app.git'",
+		"-p 2200 -W gitlab.example.com:2222 u2@jump2.example.com",
+		"-W jump2.example.com:2200 u1@jump1.example.com",
+	}
+	for i, call := range calls {
+		if !strings.HasSuffix(call, wantEnds[i]) {
+			t.Errorf("ssh call %d = %q, want it to end with %q", i+1, call, wantEnds[i])
+		}
+		for _, option := range []string{"-F /dev/null", "-o BatchMode=yes", "-i " + ssh.IdentityFile, "-o UserKnownHostsFile=" + ssh.KnownHostsFile, "-o StrictHostKeyChecking=yes"} {
+			if !strings.Contains(call, option) {
+				t.Errorf("ssh call %d = %q, want option %q", i+1, call, option)
+			}
+		}
+	}
+}
+
+func TestSSHCommandWithoutJump(t *testing.T) {
+	command, err := sshCommand(SSHConfig{Port: 2222})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ssh -F /dev/null -o BatchMode=yes -p 2222"; command != want {
+		t.Errorf("sshCommand = %q, want %q", command, want)
+	}
+}
+
+// TestSetUpGitTransportKeepsGitConfig проверяет, что настройки git из окружения сохраняются,
+// а credential helper'ы добавляются после них
+func TestSetUpGitTransportKeepsGitConfig(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "http.proxy")
+	t.Setenv("GIT_CONFIG_VALUE_0", "http://proxy.example.com:3128")
+	setUpTestGitTransport(t, Config{
+		GitlabURLSource:    "https://source.example.com",
+		PrivateTokenSource: "source-token",
+		GitlabURLDest:      "https://dest.example.com/",
+		PrivateTokenDest:   "dest-token",
+	})
+
+	gitConfig := func(args ...string) string {
+		output, err := exec.Command("git", append([]string{"config"}, args...)...).Output()
+		if err != nil {
+			t.Fatalf("git config %s: %v", strings.Join(args, " "), err)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	if got := gitConfig("--get", "http.proxy"); got != "http://proxy.example.com:3128" {
+		t.Errorf("http.proxy = %q, want value from environment", got)
+	}
+	if got := gitConfig("--get", "http.sslVerify"); got != "false" {
+		t.Errorf("http.sslVerify = %q, want false", got)
+	}
+	for url, env := range map[string]string{"https://source.example.com": envTokenSource, "https://dest.example.com": envTokenDest} {
+		if got := gitConfig("--get", "credential."+url+".helper"); got != credentialHelper(env) {
+			t.Errorf("credential helper of %s = %q, want %q", url, got, credentialHelper(env))
+		}
+	}
+}